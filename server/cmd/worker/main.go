@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/services"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/gen_image"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/parser"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
+)
+
+// Worker runs pipeline jobs from the shared database without an HTTP
+// listener. Start the API server with PROCESSING_MODE=external and as many
+// workers as needed. Volumes are read from their stored file path, so workers
+// need the same STORAGE_PATH as the server. SIGTERM drains running jobs for
+// DRAIN_TIMEOUT seconds.
+func main() {
+	config.LoadConfig()
+	cfg := config.AppConfig
+
+	db.InitBookture()
+
+	llmService := llm.NewLLMService()
+	if cfg.LLM_CACHE_TTL_HOURS > 0 && !llm.Recording() {
+		llmService = llm.NewCachedLLMService(llmService, time.Duration(cfg.LLM_CACHE_TTL_HOURS)*time.Hour)
+	}
+	if err := llmService.Init(); err != nil {
+		log.Printf("Warning: LLM Service failed to init: %v", err)
+	}
+
+	imageService := gen_image.NewImageService()
+	if err := imageService.Init(); err != nil {
+		log.Printf("Warning: Image Service failed to init: %v", err)
+	}
+
+	// Scene images are stored here and served by the API server
+	storageService := storage.NewStorageService()
+	if err := storageService.Init(); err != nil {
+		log.Fatalf("Fatal: Storage Service failed to init: %v", err)
+	}
+
+	// Events are written as webhook deliveries; the API server sends them
+	parserService := parser.NewParserService(llmService, imageService, storageService, services.NewWebhookService())
+
+	worker := services.NewWorker(
+		cfg.WORKER_ID,
+		services.NewJobStore(),
+		parserService,
+		cfg.WORKER_COUNT,
+		services.ParseJobLimits(cfg.JOB_CONCURRENCY),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	worker.Run(ctx, time.Duration(cfg.DRAIN_TIMEOUT)*time.Second)
+}
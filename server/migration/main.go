@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"log"
+
 	"github.com/Mahaveer86619/bookture/server/pkg/config"
 	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/assets"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
 )
 
+// inlineImageBatch is how many inline scene images are moved to storage per
+// query.
+const inlineImageBatch = 50
+
 func main() {
 	config.LoadConfig()
 
@@ -12,4 +21,16 @@ func main() {
 
 	booktureDB := db.GetBooktureDB()
 	booktureDB.MigrateTables()
+
+	// Scene images saved as base64 in the scene row, as they were before
+	// assets, are moved into storage once
+	storageService := storage.NewStorageService()
+	if err := storageService.Init(); err != nil {
+		log.Fatalf("Failed to init storage: %v", err)
+	}
+	moved, err := assets.NewStore(storageService).MigrateInlineImages(context.Background(), inlineImageBatch)
+	if err != nil {
+		log.Fatalf("Failed to move inline images: %v", err)
+	}
+	log.Printf("Moved %d inline scene images into storage", moved)
 }
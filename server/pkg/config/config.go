@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -30,9 +32,51 @@ type Config struct {
 	LLM_MODEL    string
 	LLM_HOST       string
 
+	LLM_CACHE_TTL_HOURS int // 0 disables the LLM response cache
+
+	LLM_CHAIN           string // ordered fallback providers, e.g. "gemini-api:gemini-2.5-flash,ollama:llama3.1"
+	LLM_CHAIN_METADATA  string // overrides LLM_CHAIN for metadata calls
+	LLM_CHAIN_SCENES    string // overrides LLM_CHAIN for scene calls
+	LLM_KEYS            string // keys of chain providers other than LLM_PROVIDER, e.g. "openai=sk-..."
+	LLM_ATTEMPT_TIMEOUT int    // seconds one provider gets before the next is tried
+	LLM_MODEL_LIMITS    string // per model request limits, e.g. "gemini-2.5-flash=10/200" (rpm/rpd)
+	LLM_CONTEXT_WINDOWS string // per model context windows in tokens, e.g. "llama3.1=32768"
+	LLM_OUTPUT_LIMITS   string // per model reply limits in tokens, e.g. "llama3.1=4096"
+	LLM_MODEL_PRICES    string // per model USD per million tokens, e.g. "gpt-4o-mini=0.15/0.6" (input/output)
+	LLM_SCENE_BATCH     int    // most short chapters sent in one scene request, 1 disables batching
+
 	IMAGE_PROVIDER string
 	IMAGE_KEY      string
 	IMAGE_MODEL    string
+
+	// Defaults of every scene image, 0 or empty leaves them to the provider
+	IMAGE_WIDTH           int
+	IMAGE_HEIGHT          int
+	IMAGE_STEPS           int
+	IMAGE_GUIDANCE        float64
+	IMAGE_NEGATIVE_PROMPT string
+	IMAGE_SEED            int // seeds each book's images from this, 0 picks a random seed per image
+
+	// Self-hosted image providers (a1111, comfyui)
+	IMAGE_HOST      string
+	IMAGE_WORKFLOW  string // ComfyUI workflow in API format, empty uses the built-in one
+	IMAGE_TIMEOUT   int    // seconds one image may take, queueing included
+	IMAGE_MAX_SIDE  int    // longest side sent to the provider, larger requests are scaled down
+	IMAGE_MAX_STEPS int
+
+	FIXTURES_PATH string // where the record and replay providers keep their fixtures
+
+	WORKER_COUNT    int
+	QUEUE_SIZE      int
+	JOB_CONCURRENCY string // per job type limits, e.g. "parse=2,image=1"
+
+	PROCESSING_MODE string // "embedded" runs jobs in the API server, "external" leaves them to cmd/worker
+	WORKER_ID       string
+	DRAIN_TIMEOUT   int // seconds running jobs get to finish on shutdown
+
+	SOFT_DELETE_RETENTION_DAYS  int
+	TASK_HISTORY_RETENTION_DAYS int
+	ERROR_RETRY_SCHEDULE        string // cron spec in UTC for retrying errored volumes
 }
 
 var AppConfig Config
@@ -65,9 +109,49 @@ func LoadConfig() {
 		LLM_MODEL:    getEnv("LLM_MODEL", "gemini-1.5-flash"),
 		LLM_HOST:     getEnv("LLM_HOST", "http://localhost:11434"),
 
+		LLM_CACHE_TTL_HOURS: getEnvInt("LLM_CACHE_TTL_HOURS", 720),
+
+		LLM_CHAIN:           getEnv("LLM_CHAIN", ""),
+		LLM_CHAIN_METADATA:  getEnv("LLM_CHAIN_METADATA", ""),
+		LLM_CHAIN_SCENES:    getEnv("LLM_CHAIN_SCENES", ""),
+		LLM_KEYS:            getEnv("LLM_KEYS", ""),
+		LLM_ATTEMPT_TIMEOUT: getEnvInt("LLM_ATTEMPT_TIMEOUT", 60),
+		LLM_MODEL_LIMITS:    getEnv("LLM_MODEL_LIMITS", ""),
+		LLM_CONTEXT_WINDOWS: getEnv("LLM_CONTEXT_WINDOWS", ""),
+		LLM_OUTPUT_LIMITS:   getEnv("LLM_OUTPUT_LIMITS", ""),
+		LLM_MODEL_PRICES:    getEnv("LLM_MODEL_PRICES", ""),
+		LLM_SCENE_BATCH:     getEnvInt("LLM_SCENE_BATCH", 8),
+
 		IMAGE_PROVIDER: getEnv("IMAGE_PROVIDER", ""),
 		IMAGE_KEY:      getEnv("IMAGE_KEY", ""),
 		IMAGE_MODEL:    getEnv("IMAGE_MODEL", ""),
+
+		IMAGE_WIDTH:           getEnvInt("IMAGE_WIDTH", 1024),
+		IMAGE_HEIGHT:          getEnvInt("IMAGE_HEIGHT", 768),
+		IMAGE_STEPS:           getEnvInt("IMAGE_STEPS", 0),
+		IMAGE_GUIDANCE:        getEnvFloat("IMAGE_GUIDANCE", 0),
+		IMAGE_NEGATIVE_PROMPT: getEnv("IMAGE_NEGATIVE_PROMPT", "text, watermark, signature, blurry, deformed hands"),
+		IMAGE_SEED:            getEnvInt("IMAGE_SEED", 0),
+
+		IMAGE_HOST:      getEnv("IMAGE_HOST", "http://localhost:7860"),
+		IMAGE_WORKFLOW:  getEnv("IMAGE_WORKFLOW", ""),
+		IMAGE_TIMEOUT:   getEnvInt("IMAGE_TIMEOUT", 300),
+		IMAGE_MAX_SIDE:  getEnvInt("IMAGE_MAX_SIDE", 1536),
+		IMAGE_MAX_STEPS: getEnvInt("IMAGE_MAX_STEPS", 50),
+
+		FIXTURES_PATH: getEnv("FIXTURES_PATH", "./fixtures"),
+
+		WORKER_COUNT:    getEnvInt("WORKER_COUNT", 3),
+		QUEUE_SIZE:      getEnvInt("QUEUE_SIZE", 100),
+		JOB_CONCURRENCY: getEnv("JOB_CONCURRENCY", ""),
+
+		PROCESSING_MODE: getEnv("PROCESSING_MODE", "embedded"),
+		WORKER_ID:       getEnv("WORKER_ID", defaultWorkerID()),
+		DRAIN_TIMEOUT:   getEnvInt("DRAIN_TIMEOUT", 30),
+
+		SOFT_DELETE_RETENTION_DAYS:  getEnvInt("SOFT_DELETE_RETENTION_DAYS", 30),
+		TASK_HISTORY_RETENTION_DAYS: getEnvInt("TASK_HISTORY_RETENTION_DAYS", 90),
+		ERROR_RETRY_SCHEDULE:        getEnv("ERROR_RETRY_SCHEDULE", "30 8 * * *"),
 	}
 }
 
@@ -78,6 +162,42 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := lookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s: %q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, exists := lookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid number for %s: %q, using %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return f
+}
+
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 var lookupEnv = func(key string) (string, bool) {
 	return os.LookupEnv(key)
 }
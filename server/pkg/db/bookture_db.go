@@ -44,7 +44,16 @@ func GetBooktureDB() *BooktureDB {
 }
 
 func (bdb *BooktureDB) MigrateTables() {
-	err := bdb.DB.AutoMigrate(
+	if err := Migrate(bdb.DB); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	log.Println("Database migration completed")
+}
+
+// Migrate creates or updates the tables of every model.
+func Migrate(conn *gorm.DB) error {
+	return conn.AutoMigrate(
 		&models.User{},
 		&models.Library{},
 		&models.Book{},
@@ -65,11 +74,14 @@ func (bdb *BooktureDB) MigrateTables() {
 		&models.Asset{},
 		&models.EmbeddingTarget{},
 		&models.Embedding{},
+		&models.PipelineJob{},
+		&models.TaskRun{},
+		&models.TaskStageRun{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.ScheduledJob{},
+		&models.LLMCacheEntry{},
+		&models.LLMUsage{},
+		&models.PromptTemplate{},
 	)
-
-	if err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
-	}
-
-	log.Println("Database migration completed")
 }
\ No newline at end of file
@@ -34,6 +34,7 @@ const (
 	VolumeEnhancing VolumeStatus = "enhancing" // LLM processing (summaries, scenes)
 	VolumeCompleted VolumeStatus = "completed" // Fully processed and ready
 	VolumeError     VolumeStatus = "error"     // Error during processing
+	VolumeCancelled VolumeStatus = "cancelled" // Processing stopped by the user
 )
 
 func (vs VolumeStatus) ToString() string {
@@ -43,7 +44,7 @@ func (vs VolumeStatus) ToString() string {
 func (vs VolumeStatus) IsValid() bool {
 	switch vs {
 	case VolumeCreated, VolumeUploaded, VolumeParsing, VolumeParsed,
-		VolumeEnhancing, VolumeCompleted, VolumeError:
+		VolumeEnhancing, VolumeCompleted, VolumeError, VolumeCancelled:
 		return true
 	default:
 		return false
@@ -54,12 +55,13 @@ func (vs VolumeStatus) IsValid() bool {
 func (vs VolumeStatus) CanTransitionTo(next VolumeStatus) bool {
 	validTransitions := map[VolumeStatus][]VolumeStatus{
 		VolumeCreated:   {VolumeUploaded, VolumeError},
-		VolumeUploaded:  {VolumeParsing, VolumeError},
-		VolumeParsing:   {VolumeParsed, VolumeError},
-		VolumeParsed:    {VolumeEnhancing, VolumeCompleted, VolumeError},
-		VolumeEnhancing: {VolumeCompleted, VolumeError},
+		VolumeUploaded:  {VolumeParsing, VolumeError, VolumeCancelled},
+		VolumeParsing:   {VolumeParsed, VolumeError, VolumeCancelled, VolumeUploaded},
+		VolumeParsed:    {VolumeEnhancing, VolumeCompleted, VolumeError, VolumeCancelled, VolumeUploaded},
+		VolumeEnhancing: {VolumeCompleted, VolumeError, VolumeCancelled, VolumeUploaded},
 		VolumeCompleted: {VolumeParsing, VolumeEnhancing}, // Allow re-processing
 		VolumeError:     {VolumeParsing, VolumeEnhancing}, // Allow retry
+		VolumeCancelled: {VolumeParsing, VolumeEnhancing}, // Allow resume
 	}
 
 	allowed, exists := validTransitions[vs]
@@ -133,3 +135,195 @@ const (
 func (jt JobType) ToString() string {
 	return string(jt)
 }
+
+func (jt JobType) IsValid() bool {
+	switch jt {
+	case JobTypeParse, JobTypeEnhance, JobTypeAudio, JobTypeImage:
+		return true
+	default:
+		return false
+	}
+}
+
+// JobPriority orders jobs in the processing queue
+type JobPriority string
+
+const (
+	PriorityBulk        JobPriority = "bulk"        // Uploads and batch ingestion
+	PriorityNormal      JobPriority = "normal"      // Default
+	PriorityInteractive JobPriority = "interactive" // A user is waiting on the result
+)
+
+func (jp JobPriority) ToString() string {
+	return string(jp)
+}
+
+func (jp JobPriority) IsValid() bool {
+	switch jp {
+	case PriorityBulk, PriorityNormal, PriorityInteractive:
+		return true
+	default:
+		return false
+	}
+}
+
+// Rank is higher for jobs that should run first
+func (jp JobPriority) Rank() int {
+	switch jp {
+	case PriorityInteractive:
+		return 2
+	case PriorityNormal:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// TaskStatus represents the state of a job in the processing queue
+type TaskStatus string
+
+const (
+	TaskQueued    TaskStatus = "queued"     // Waiting for a free worker
+	TaskRunning   TaskStatus = "processing" // Picked up by a worker
+	TaskCompleted TaskStatus = "completed"  // Finished without error
+	TaskError     TaskStatus = "error"      // Job returned an error or panicked
+	TaskCancelled TaskStatus = "cancelled"  // Stopped through its context
+)
+
+func (ts TaskStatus) ToString() string {
+	return string(ts)
+}
+
+// IsFinal reports whether the task can no longer change state
+func (ts TaskStatus) IsFinal() bool {
+	switch ts {
+	case TaskCompleted, TaskError, TaskCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// TaskStage is the pipeline stage a task is currently in
+type TaskStage string
+
+const (
+	StageQueued    TaskStage = "queued"    // Waiting for a worker
+	StageParsing   TaskStage = "parsing"   // Reading the uploaded file
+	StageMetadata  TaskStage = "metadata"  // LLM title/author inference
+	StageStructure TaskStage = "structure" // Saving chapters and sections
+	StageScenes    TaskStage = "scenes"    // LLM scene generation
+	StageImages    TaskStage = "images"    // Scene illustration
+	StageFinished  TaskStage = "finished"  // No more work to do
+)
+
+func (ts TaskStage) ToString() string {
+	return string(ts)
+}
+
+// TaskEventType classifies events pushed to task progress streams
+type TaskEventType string
+
+const (
+	EventProgress    TaskEventType = "progress"     // Percentage changed
+	EventStage       TaskEventType = "stage"        // Entered a new stage
+	EventChapter     TaskEventType = "chapter"      // Finished a chapter in the scene stage
+	EventImage       TaskEventType = "image"        // Finished a scene image
+	EventRetry       TaskEventType = "retry"        // A call failed and will be retried
+	EventRateLimited TaskEventType = "rate_limited" // Waiting for a provider quota window
+	EventWarning     TaskEventType = "warning"      // Non-fatal problem, processing continues
+	EventCompleted   TaskEventType = "completed"    // Terminal: success
+	EventError       TaskEventType = "error"        // Terminal: failure
+	EventCancelled   TaskEventType = "cancelled"    // Terminal: stopped by the user
+)
+
+func (et TaskEventType) ToString() string {
+	return string(et)
+}
+
+// IsFinal reports whether no further events follow this one
+func (et TaskEventType) IsFinal() bool {
+	switch et {
+	case EventCompleted, EventError, EventCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// WebhookEvent is a domain event that can be delivered to user webhooks
+type WebhookEvent string
+
+const (
+	WebhookVolumeParsed    WebhookEvent = "volume.parsed"    // Chapters and sections are saved
+	WebhookVolumeCompleted WebhookEvent = "volume.completed" // Scenes and images are done
+	WebhookVolumeError     WebhookEvent = "volume.error"     // Processing failed
+	WebhookVolumeCancelled WebhookEvent = "volume.cancelled" // Processing stopped by the user
+	WebhookBookCreated     WebhookEvent = "book.created"     // A draft book was created
+	WebhookLibraryDeleted  WebhookEvent = "library.deleted"  // A library was deleted
+	WebhookTest            WebhookEvent = "webhook.test"     // Sent on request, always delivered
+)
+
+func (we WebhookEvent) ToString() string {
+	return string(we)
+}
+
+// IsValid reports whether users may subscribe to the event
+func (we WebhookEvent) IsValid() bool {
+	switch we {
+	case WebhookVolumeParsed, WebhookVolumeCompleted, WebhookVolumeError, WebhookVolumeCancelled,
+		WebhookBookCreated, WebhookLibraryDeleted:
+		return true
+	default:
+		return false
+	}
+}
+
+// DeliveryStatus is the state of a webhook delivery
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"   // Waiting for its next attempt
+	DeliverySucceeded DeliveryStatus = "succeeded" // Receiver answered 2xx
+	DeliveryFailed    DeliveryStatus = "failed"    // Out of attempts
+)
+
+func (ds DeliveryStatus) ToString() string {
+	return string(ds)
+}
+
+// PromptKind is the kind of AI call recorded in the prompt audit log
+type PromptKind string
+
+const (
+	PromptLLM   PromptKind = "llm"   // Text generation
+	PromptImage PromptKind = "image" // Image generation
+)
+
+func (pk PromptKind) ToString() string {
+	return string(pk)
+}
+
+// PromptStatus is the outcome of a recorded AI call
+type PromptStatus string
+
+const (
+	PromptCompleted PromptStatus = "completed" // Reply passed validation
+	PromptInvalid   PromptStatus = "invalid"   // Reply did not match the schema
+	PromptFailed    PromptStatus = "failed"    // Call failed
+)
+
+func (ps PromptStatus) ToString() string {
+	return string(ps)
+}
+
+// AssetOwner is the kind of row a stored file belongs to
+type AssetOwner string
+
+const (
+	AssetOwnerScene AssetOwner = "scene" // Scene illustration
+)
+
+func (ao AssetOwner) ToString() string {
+	return string(ao)
+}
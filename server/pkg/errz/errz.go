@@ -4,6 +4,8 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/views"
 )
@@ -17,6 +19,7 @@ const (
 	Unauthorized        ErrzType = "unauthorized"
 	Forbidden           ErrzType = "forbidden"
 	InternalServerError ErrzType = "internal_server_error"
+	ServiceUnavailable  ErrzType = "service_unavailable"
 )
 
 var statusMap = map[ErrzType]int{
@@ -26,12 +29,14 @@ var statusMap = map[ErrzType]int{
 	Unauthorized:        http.StatusUnauthorized,
 	Forbidden:           http.StatusForbidden,
 	InternalServerError: http.StatusInternalServerError,
+	ServiceUnavailable:  http.StatusServiceUnavailable,
 }
 
 type BooktureError struct {
-	Type    ErrzType
-	Message string
-	Err     error
+	Type       ErrzType
+	Message    string
+	Err        error
+	RetryAfter time.Duration
 }
 
 func (e *BooktureError) Error() string {
@@ -46,6 +51,16 @@ func New(errType ErrzType, msg string, err error) error {
 	}
 }
 
+// Unavailable is a 503 telling the client when to try again.
+func Unavailable(msg string, retryAfter time.Duration, err error) error {
+	return &BooktureError{
+		Type:       ServiceUnavailable,
+		Message:    msg,
+		Err:        err,
+		RetryAfter: retryAfter,
+	}
+}
+
 func HandleErrors(w http.ResponseWriter, err error) {
 	if err == nil {
 		return
@@ -62,6 +77,10 @@ func HandleErrors(w http.ResponseWriter, err error) {
 			log.Printf("Internal Error: %v | Source: %v", bkErr.Message, bkErr.Err)
 		}
 
+		if bkErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(bkErr.RetryAfter.Seconds())))
+		}
+
 		resp := &views.Failure{
 			StatusCode: statusCode,
 			Message:    bkErr.Message,
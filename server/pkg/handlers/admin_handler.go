@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/services"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/scheduler"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+type AdminHandler struct {
+	processor *services.ProcessingService
+	jobs      *services.JobStore // set when PROCESSING_MODE=external
+	scheduler *scheduler.Scheduler
+	llmCache  *llm.CachedLLMService // nil when the cache is disabled
+}
+
+func NewAdminHandler(processor *services.ProcessingService, jobs *services.JobStore, scheduler *scheduler.Scheduler, llmCache *llm.CachedLLMService) *AdminHandler {
+	return &AdminHandler{processor: processor, jobs: jobs, scheduler: scheduler, llmCache: llmCache}
+}
+
+func (h *AdminHandler) GetQueue(w http.ResponseWriter, r *http.Request) {
+	h.writeQueue(w, "Queue fetched")
+}
+
+// writeQueue answers with the queue of the processing mode in use.
+func (h *AdminHandler) writeQueue(w http.ResponseWriter, message string) {
+	if h.jobs == nil {
+		success := views.Success{StatusCode: http.StatusOK, Data: h.processor.Snapshot(), Message: message}
+		_ = success.JSON(w)
+		return
+	}
+
+	queue, err := h.jobs.Snapshot()
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.InternalServerError, "Failed to load queue", err))
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: queue, Message: message}
+	_ = success.JSON(w)
+}
+
+// externalQueue rejects pausing and resuming in external mode, where each
+// worker runs its own queue.
+func (h *AdminHandler) externalQueue(w http.ResponseWriter, action string) bool {
+	if h.jobs == nil {
+		return false
+	}
+	msg := action + " is not available with PROCESSING_MODE=external, workers schedule their own jobs"
+	errz.HandleErrors(w, errz.New(errz.Conflict, msg, nil))
+	return true
+}
+
+// ReorderQueue moves a waiting job and changes its priority. In external mode
+// only the priority can change.
+func (h *AdminHandler) ReorderQueue(w http.ResponseWriter, r *http.Request) {
+	var req views.ReorderQueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid request body", err))
+		return
+	}
+
+	if err := req.Valid(); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, err.Error(), err))
+		return
+	}
+
+	priority := enums.JobPriority(req.Priority)
+	if priority != "" && !priority.IsValid() {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "priority must be bulk, normal or interactive", nil))
+		return
+	}
+
+	var err error
+	if h.jobs != nil {
+		if req.Position != nil {
+			errz.HandleErrors(w, errz.New(errz.BadRequest, "position is not available with PROCESSING_MODE=external, change the priority instead", nil))
+			return
+		}
+		err = h.jobs.Reorder(req.TaskID, priority)
+	} else {
+		err = h.processor.Reorder(req.TaskID, req.Position, priority)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTaskRunning):
+			errz.HandleErrors(w, errz.New(errz.Conflict, "Task is already running", err))
+		case errors.Is(err, services.ErrTaskFinished):
+			errz.HandleErrors(w, errz.New(errz.Conflict, "Task already finished", err))
+		case errors.Is(err, services.ErrTaskNotFound):
+			errz.HandleErrors(w, errz.New(errz.NotFound, "Task not found", err))
+		default:
+			errz.HandleErrors(w, errz.New(errz.InternalServerError, "Failed to reorder queue", err))
+		}
+		return
+	}
+
+	h.writeQueue(w, "Queue reordered")
+}
+
+// PauseQueue stops new jobs from starting; ?job_type= limits it to one type.
+func (h *AdminHandler) PauseQueue(w http.ResponseWriter, r *http.Request) {
+	if h.externalQueue(w, "Pausing the queue") {
+		return
+	}
+
+	jobType, ok := jobTypeParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.processor.Pause(jobType)
+
+	success := views.Success{StatusCode: http.StatusOK, Data: h.processor.Snapshot(), Message: "Queue paused"}
+	_ = success.JSON(w)
+}
+
+func (h *AdminHandler) ResumeQueue(w http.ResponseWriter, r *http.Request) {
+	if h.externalQueue(w, "Resuming the queue") {
+		return
+	}
+
+	jobType, ok := jobTypeParam(w, r)
+	if !ok {
+		return
+	}
+
+	h.processor.Resume(jobType)
+
+	success := views.Success{StatusCode: http.StatusOK, Data: h.processor.Snapshot(), Message: "Queue resumed"}
+	_ = success.JSON(w)
+}
+
+func (h *AdminHandler) GetScheduledJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.scheduler.List()
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.InternalServerError, "Failed to load scheduled jobs", err))
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: jobs, Message: "Scheduled jobs fetched"}
+	_ = success.JSON(w)
+}
+
+// RunScheduledJob starts the job named by ?name= now, outside its schedule.
+func (h *AdminHandler) RunScheduledJob(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "name is required", nil))
+		return
+	}
+
+	if err := h.scheduler.Trigger(name); err != nil {
+		switch {
+		case errors.Is(err, scheduler.ErrUnknownJob):
+			errz.HandleErrors(w, errz.New(errz.NotFound, "Scheduled job not found", err))
+		case errors.Is(err, scheduler.ErrJobRunning):
+			errz.HandleErrors(w, errz.New(errz.Conflict, "Scheduled job is already running", err))
+		default:
+			errz.HandleErrors(w, errz.New(errz.InternalServerError, "Failed to start scheduled job", err))
+		}
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusAccepted, Data: nil, Message: "Scheduled job started"}
+	_ = success.JSON(w)
+}
+
+func (h *AdminHandler) GetLLMCacheStats(w http.ResponseWriter, r *http.Request) {
+	if h.llmCache == nil {
+		errz.HandleErrors(w, errz.New(errz.NotFound, "LLM cache is disabled", nil))
+		return
+	}
+
+	stats, err := h.llmCache.Stats()
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.InternalServerError, "Failed to load cache stats", err))
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: stats, Message: "LLM cache stats fetched"}
+	_ = success.JSON(w)
+}
+
+// GetLLMUsage returns today's requests and tokens per model next to its limits.
+func (h *AdminHandler) GetLLMUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := llm.UsageReport()
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.InternalServerError, "Failed to load LLM usage", err))
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: usage, Message: "LLM usage fetched"}
+	_ = success.JSON(w)
+}
+
+// InvalidateLLMCache drops the cached replies of the book given by ?book_id=.
+func (h *AdminHandler) InvalidateLLMCache(w http.ResponseWriter, r *http.Request) {
+	if h.llmCache == nil {
+		errz.HandleErrors(w, errz.New(errz.NotFound, "LLM cache is disabled", nil))
+		return
+	}
+
+	queryID := r.URL.Query().Get("book_id")
+	if queryID == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "book_id is required", nil))
+		return
+	}
+
+	bookID, err := utils.UnmaskID(queryID)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid book ID", err))
+		return
+	}
+
+	removed, err := h.llmCache.InvalidateBook(bookID)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.InternalServerError, "Failed to invalidate cache", err))
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: map[string]int64{"removed": removed}, Message: "LLM cache invalidated"}
+	_ = success.JSON(w)
+}
+
+func jobTypeParam(w http.ResponseWriter, r *http.Request) (enums.JobType, bool) {
+	jobType := enums.JobType(r.URL.Query().Get("job_type"))
+	if jobType != "" && !jobType.IsValid() {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid job_type", nil))
+		return "", false
+	}
+	return jobType, true
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/middleware"
+	"github.com/Mahaveer86619/bookture/server/pkg/services"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+)
+
+type AssetHandler struct {
+	svc *services.AssetService
+}
+
+func NewAssetHandler(svc *services.AssetService) *AssetHandler {
+	return &AssetHandler{svc: svc}
+}
+
+// GetAsset serves a stored file, e.g. the image at a scene's image_url. The
+// checksum is the ETag, so a cached copy is revalidated without a download.
+func (h *AssetHandler) GetAsset(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	assetID, err := utils.UnmaskID(r.URL.Query().Get("id"))
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid asset ID", err))
+		return
+	}
+
+	asset, file, err := h.svc.OpenAsset(userID, assetID)
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", asset.MimeType)
+	w.Header().Set("ETag", `"`+asset.Checksum+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", asset.UpdatedAt, file)
+}
@@ -2,15 +2,27 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
 	"github.com/Mahaveer86619/bookture/server/pkg/errz"
 	"github.com/Mahaveer86619/bookture/server/pkg/middleware"
 	"github.com/Mahaveer86619/bookture/server/pkg/services"
 	"github.com/Mahaveer86619/bookture/server/pkg/utils"
 	"github.com/Mahaveer86619/bookture/server/pkg/views"
+	"github.com/gorilla/websocket"
 )
 
+const streamHeartbeat = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	// Streams are authenticated by token, not by origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
 type BookHandler struct {
 	svc *services.BookService
 }
@@ -92,7 +104,11 @@ func (h *BookHandler) UploadVolume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	success := views.Success{StatusCode: http.StatusCreated, Data: resp, Message: "Volume uploaded successfully"}
+	message := "Volume uploaded successfully"
+	if resp.Pending {
+		message = "Volume uploaded, processing starts once the queue has room"
+	}
+	success := views.Success{StatusCode: http.StatusCreated, Data: resp, Message: message}
 	_ = success.JSON(w)
 }
 
@@ -103,29 +119,228 @@ func (h *BookHandler) GetTaskProgress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	progress, err := h.svc.GetTaskProgress(taskID)
+	state, err := h.svc.GetTaskProgress(taskID)
 	if err != nil {
 		errz.HandleErrors(w, err)
 		return
 	}
 
-	status := "processing"
-	if progress >= 100 {
-		status = "completed"
-	} else if progress < 0 {
-		status = "error"
-	}
-
 	response := map[string]interface{}{
 		"task_id":  taskID,
-		"progress": progress,
-		"status":   status,
+		"progress": state.Progress,
+		"status":   state.Status.ToString(),
+	}
+	if eta, ok := h.svc.EstimateTask(taskID); ok {
+		response["eta_seconds"] = int(eta.Seconds())
 	}
 
 	success := views.Success{StatusCode: http.StatusOK, Data: response, Message: "Progress retrieved successfully"}
 	_ = success.JSON(w)
 }
 
+func (h *BookHandler) RegenerateVolume(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req views.RegenerateVolumeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid request body", err))
+		return
+	}
+
+	if err := req.Valid(); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, err.Error(), err))
+		return
+	}
+
+	volID, err := utils.UnmaskID(req.VolumeID)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid volume ID", err))
+		return
+	}
+
+	taskID, err := h.svc.RegenerateVolume(userID, volID, enums.TaskStage(req.Stage))
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"task_id": taskID,
+	}
+
+	success := views.Success{StatusCode: http.StatusAccepted, Data: response, Message: "Regeneration queued"}
+	_ = success.JSON(w)
+}
+
+func (h *BookHandler) CancelTask(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	taskID := r.URL.Query().Get("task_id")
+	if taskID == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "task_id is required", nil))
+		return
+	}
+
+	if err := h.svc.CancelTask(userID, taskID); err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: nil, Message: "Task cancelled"}
+	_ = success.JSON(w)
+}
+
+// StreamTaskEvents pushes task progress as Server-Sent Events. Clients that
+// reconnect with Last-Event-ID get the events they missed replayed first.
+func (h *BookHandler) StreamTaskEvents(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	taskID := r.URL.Query().Get("task_id")
+	if taskID == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "task_id is required", nil))
+		return
+	}
+
+	lastEventID := lastEventIDFrom(r)
+	replay, events, unsubscribe, err := h.svc.SubscribeTask(userID, taskID, lastEventID)
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+	defer unsubscribe()
+
+	// The server-wide write timeout would cut the stream off
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range replay {
+		if err := writeSSE(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// StreamTaskEventsWS is the WebSocket alternative to StreamTaskEvents. Each
+// message is one JSON encoded event; last_event_id replays missed events.
+func (h *BookHandler) StreamTaskEventsWS(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	taskID := r.URL.Query().Get("task_id")
+	if taskID == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "task_id is required", nil))
+		return
+	}
+
+	replay, events, unsubscribe, err := h.svc.SubscribeTask(userID, taskID, lastEventIDFrom(r))
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+	defer unsubscribe()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Drain client frames so close and pong messages are processed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, event := range replay {
+		if err := writeWS(conn, event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-events:
+			if !ok {
+				msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "task finished")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				return
+			}
+			if err := writeWS(conn, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func lastEventIDFrom(r *http.Request) int64 {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	id, _ := strconv.ParseInt(raw, 10, 64)
+	return id
+}
+
+func writeSSE(w http.ResponseWriter, event views.TaskEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+func writeWS(conn *websocket.Conn, event views.TaskEvent) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return conn.WriteJSON(event)
+}
+
 func (h *BookHandler) GetVolumeDetails(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
@@ -151,3 +366,79 @@ func (h *BookHandler) GetVolumeDetails(w http.ResponseWriter, r *http.Request) {
 	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Volume details fetched"}
 	_ = success.JSON(w)
 }
+
+func (h *BookHandler) EstimateVolume(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	idStr := r.URL.Query().Get("volume_id")
+	if idStr == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "volume_id is required", nil))
+		return
+	}
+
+	volID, err := utils.UnmaskID(idStr)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid volume ID", err))
+		return
+	}
+
+	resp, err := h.svc.EstimateVolume(r.Context(), userID, uint(volID))
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Volume estimate calculated"}
+	_ = success.JSON(w)
+}
+
+func (h *BookHandler) GetVolumeHistory(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	idStr := r.URL.Query().Get("volume_id")
+	if idStr == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "volume_id is required", nil))
+		return
+	}
+
+	volID, err := utils.UnmaskID(idStr)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid volume ID", err))
+		return
+	}
+
+	resp, err := h.svc.GetVolumeHistory(userID, uint(volID))
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Task history fetched"}
+	_ = success.JSON(w)
+}
+
+func (h *BookHandler) GetScenePrompts(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	idStr := r.URL.Query().Get("scene_id")
+	if idStr == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "scene_id is required", nil))
+		return
+	}
+
+	// Scene IDs are not masked, see views.SceneView
+	sceneID, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid scene ID", err))
+		return
+	}
+
+	resp, err := h.svc.GetScenePrompts(userID, uint(sceneID))
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Scene prompts fetched"}
+	_ = success.JSON(w)
+}
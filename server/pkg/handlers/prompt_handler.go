@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/middleware"
+	"github.com/Mahaveer86619/bookture/server/pkg/services"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+type PromptHandler struct {
+	svc *services.PromptService
+}
+
+func NewPromptHandler(svc *services.PromptService) *PromptHandler {
+	return &PromptHandler{svc: svc}
+}
+
+// CreateTemplate saves a library or book template.
+func (h *PromptHandler) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	h.createTemplate(w, r, false)
+}
+
+// CreateGlobalTemplate also accepts templates for every book. Admin only.
+func (h *PromptHandler) CreateGlobalTemplate(w http.ResponseWriter, r *http.Request) {
+	h.createTemplate(w, r, true)
+}
+
+func (h *PromptHandler) createTemplate(w http.ResponseWriter, r *http.Request, admin bool) {
+	userID := middleware.GetUserID(r)
+
+	var req views.CreatePromptTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid request body", err))
+		return
+	}
+
+	if err := req.Valid(); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, err.Error(), err))
+		return
+	}
+
+	resp, err := h.svc.CreateTemplate(userID, admin, req)
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusCreated, Data: resp, Message: "Prompt template saved"}
+	_ = success.JSON(w)
+}
+
+func (h *PromptHandler) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	query := r.URL.Query()
+
+	task := query.Get("task")
+	if task == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "task is required", nil))
+		return
+	}
+
+	resp, err := h.svc.GetTemplates(userID, task, query.Get("library_id"), query.Get("book_id"))
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Prompt templates fetched"}
+	_ = success.JSON(w)
+}
+
+// DeleteTemplate removes a library or book template.
+func (h *PromptHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	h.deleteTemplate(w, r, false)
+}
+
+// DeleteGlobalTemplate also removes templates for every book. Admin only.
+func (h *PromptHandler) DeleteGlobalTemplate(w http.ResponseWriter, r *http.Request) {
+	h.deleteTemplate(w, r, true)
+}
+
+func (h *PromptHandler) deleteTemplate(w http.ResponseWriter, r *http.Request, admin bool) {
+	userID := middleware.GetUserID(r)
+
+	idStr := r.URL.Query().Get("id")
+	if idStr == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "id is required", nil))
+		return
+	}
+
+	templateID, err := utils.UnmaskID(idStr)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid prompt template ID", err))
+		return
+	}
+
+	if err := h.svc.DeleteTemplate(userID, admin, templateID); err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: nil, Message: "Prompt template deleted"}
+	_ = success.JSON(w)
+}
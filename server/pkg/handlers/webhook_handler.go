@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/middleware"
+	"github.com/Mahaveer86619/bookture/server/pkg/services"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+// defaultDeliveryLimit is how many deliveries GET /webhook/deliveries returns
+// when no limit is given.
+const defaultDeliveryLimit = 50
+
+type WebhookHandler struct {
+	svc *services.WebhookService
+}
+
+func NewWebhookHandler(svc *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{svc: svc}
+}
+
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req views.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid request body", err))
+		return
+	}
+
+	if err := req.Valid(); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, err.Error(), err))
+		return
+	}
+
+	resp, err := h.svc.CreateWebhook(userID, req)
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusCreated, Data: resp, Message: "Webhook created, store the secret now as it is not shown again"}
+	_ = success.JSON(w)
+}
+
+func (h *WebhookHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	resp, err := h.svc.GetWebhooks(userID)
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Webhooks fetched"}
+	_ = success.JSON(w)
+}
+
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req views.UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid request body", err))
+		return
+	}
+
+	if err := req.Valid(); err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, err.Error(), err))
+		return
+	}
+
+	hookID, err := utils.UnmaskID(req.ID)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid webhook ID", err))
+		return
+	}
+
+	resp, err := h.svc.UpdateWebhook(userID, hookID, req)
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Webhook updated"}
+	_ = success.JSON(w)
+}
+
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	hookID, ok := webhookIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.svc.DeleteWebhook(userID, hookID); err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: nil, Message: "Webhook deleted"}
+	_ = success.JSON(w)
+}
+
+// SendTestEvent posts a webhook.test event and returns the delivery result.
+func (h *WebhookHandler) SendTestEvent(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	hookID, ok := webhookIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := h.svc.SendTest(userID, hookID)
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Test event sent"}
+	_ = success.JSON(w)
+}
+
+func (h *WebhookHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	hookID, ok := webhookIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			errz.HandleErrors(w, errz.New(errz.BadRequest, "limit must be between 1 and 500", err))
+			return
+		}
+		limit = n
+	}
+
+	resp, err := h.svc.GetDeliveries(userID, hookID, limit)
+	if err != nil {
+		errz.HandleErrors(w, err)
+		return
+	}
+
+	success := views.Success{StatusCode: http.StatusOK, Data: resp, Message: "Deliveries fetched"}
+	_ = success.JSON(w)
+}
+
+func webhookIDParam(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	queryID := r.URL.Query().Get("id")
+	if queryID == "" {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "id is required", nil))
+		return 0, false
+	}
+
+	hookID, err := utils.UnmaskID(queryID)
+	if err != nil {
+		errz.HandleErrors(w, errz.New(errz.BadRequest, "Invalid webhook ID", err))
+		return 0, false
+	}
+	return hookID, true
+}
@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
 	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -104,6 +106,35 @@ func Middleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// StreamMiddleware authenticates like Middleware but also accepts the token as
+// an access_token query parameter, since EventSource, browser WebSockets and
+// img tags cannot set an Authorization header.
+func StreamMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	auth := Middleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			if token := r.URL.Query().Get("access_token"); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		auth(w, r)
+	}
+}
+
+// AdminMiddleware authenticates like Middleware and then requires the user to
+// be flagged as admin.
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return Middleware(func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		err := db.GetBooktureDB().DB.Select("id", "is_admin").First(&user, GetUserID(r)).Error
+		if err != nil || !user.IsAdmin {
+			errz.HandleErrors(w, errz.New(errz.Forbidden, "Admin access required", err))
+			return
+		}
+		next(w, r)
+	})
+}
+
 func GetUserID(r *http.Request) uint {
 	val := r.Context().Value(userIDKey)
 	if val == nil {
@@ -4,13 +4,41 @@ import (
 	"gorm.io/gorm"
 )
 
+// AIPrompt is one LLM or image call, kept so a bad output can be traced
+// back to the exact request and replayed. Scene and image calls are linked
+// to the scene, scene generation calls to their chapter (scenes point back
+// through Scene.SourcePromptID) and metadata calls to their volume.
 type AIPrompt struct {
 	gorm.Model
 
-	SceneID    *uint  `gorm:"index"`
-	SummaryID  *uint  `gorm:"index"`
-	PromptText string `gorm:"type:text"`
-	ModelName  string
+	SceneID   *uint `gorm:"index"`
+	SummaryID *uint `gorm:"index"`
+	VolumeID  *uint `gorm:"index"`
+	ChapterID *uint `gorm:"index"`
+
+	Kind    string `gorm:"type:varchar(20)"` // llm / image
+	Task    string `gorm:"type:varchar(30)"` // metadata / scenes / image
+	Attempt int
+	Repair  bool // Asked the model to fix its previous, invalid reply
+
+	Provider     string `gorm:"type:varchar(50)"`
+	ModelName    string
+	SystemPrompt string `gorm:"type:text"`
+	PromptText   string `gorm:"type:text"`
+	Schema       string `gorm:"type:text"` // JSON schema sent with the prompt
+	Response     string `gorm:"type:text"`
+	Cached       bool
+
+	// Template the prompts were rendered from, see PromptTemplate
+	TemplateVersion string `gorm:"type:varchar(50)"`
+	TemplateID      *uint
+
+	Status       string `gorm:"type:varchar(20)"` // completed / invalid / failed
+	Error        string `gorm:"type:text"`
+	FinishReason string `gorm:"type:varchar(50)"`
+	LatencyMs    int64
+	PromptTokens int
+	OutputTokens int
 }
 
 func (aiPrompt *AIPrompt) TableName() string {
@@ -9,6 +9,14 @@ type Asset struct {
 
 	OwnerType string // scene / character_version / summary / tts / misc
 	OwnerID   uint   `gorm:"index"`
-	FileURL   string
+	FileURL   string // Where the API serves it
 	FileType  string
+
+	// Where storage keeps the file, never sent to clients
+	StoragePath string
+	MimeType    string `gorm:"type:varchar(50)"`
+	Width       int
+	Height      int
+	Size        int64
+	Checksum    string `gorm:"type:varchar(64)"` // SHA-256 of the file, hex
 }
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// LLMCacheEntry is a stored LLM reply, keyed by a hash of the provider and
+// model meant to answer, the prompts and the schema. Provider and Model are
+// the ones that actually answered.
+type LLMCacheEntry struct {
+	ID        uint   `gorm:"primarykey"`
+	Key       string `gorm:"type:char(64);uniqueIndex;not null"`
+	Provider  string `gorm:"type:varchar(50)"`
+	Model     string `gorm:"type:varchar(100)"`
+	BookID    uint   `gorm:"index"` // 0 when the call was not made for a book
+	Response  string `gorm:"type:text"`
+	Hits      int    `gorm:"default:0"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
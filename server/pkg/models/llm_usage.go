@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// LLMUsage counts the requests and tokens a provider model used in one
+// period. Rows are shared by every process, which makes them the quota.
+type LLMUsage struct {
+	ID           uint      `gorm:"primarykey"`
+	Provider     string    `gorm:"type:varchar(50);uniqueIndex:idx_llm_usage_period"`
+	Model        string    `gorm:"type:varchar(100);uniqueIndex:idx_llm_usage_period"`
+	Period       string    `gorm:"type:varchar(10);uniqueIndex:idx_llm_usage_period"` // day / minute
+	PeriodStart  time.Time `gorm:"uniqueIndex:idx_llm_usage_period"`
+	Requests     int       `gorm:"default:0"`
+	PromptTokens int64     `gorm:"default:0"`
+	OutputTokens int64     `gorm:"default:0"`
+	UpdatedAt    time.Time
+
+	// Replies that failed validation, and how many of those a repair fixed
+	InvalidReplies  int `gorm:"default:0"`
+	RepairedReplies int `gorm:"default:0"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PipelineJob is a volume job waiting for, or claimed by, a standalone worker.
+type PipelineJob struct {
+	gorm.Model
+
+	// At most one queued or running job per task, so the API server and the
+	// reconciler cannot both queue the same work
+	TaskID       string `gorm:"index;uniqueIndex:idx_pipeline_jobs_active_task,where:(status = 'queued' OR status = 'processing') AND deleted_at IS NULL"`
+	Kind         string `gorm:"type:varchar(20)"` // parse / scenes / images
+	VolumeID     uint   `gorm:"index"`
+	UserID       uint   `gorm:"index"`
+	JobType      string `gorm:"type:varchar(20)"` // Use enums.JobType
+	Priority     string `gorm:"type:varchar(20)"` // Use enums.JobPriority
+	PriorityRank int    `gorm:"index"`
+
+	Status          string `gorm:"type:varchar(20);index"` // Use enums.TaskStatus
+	Progress        int    `gorm:"default:0"`
+	CancelRequested bool   `gorm:"default:false"`
+	Attempts        int    `gorm:"default:0"`
+	Error           string `gorm:"type:text"`
+
+	WorkerID    string
+	ClaimedAt   *time.Time
+	HeartbeatAt *time.Time
+	FinishedAt  *time.Time
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// PromptTemplate overrides the built-in prompt of a task, for every book
+// (no library or book), for a library or for a single book. System and User
+// are Go text/template sources. Each save adds a new version of its scope
+// and the highest version wins.
+type PromptTemplate struct {
+	gorm.Model
+
+	Task      string `gorm:"type:varchar(30);index"`
+	LibraryID *uint  `gorm:"index"`
+	BookID    *uint  `gorm:"index"`
+	Version   int
+
+	System string `gorm:"type:text"`
+	User   string `gorm:"type:text"`
+	Note   string
+}
+
+// Label names the template as <scope>:<task>:v<version>, e.g. book:scenes:v3.
+func (t *PromptTemplate) Label() string {
+	scope := "global"
+	switch {
+	case t.BookID != nil:
+		scope = "book"
+	case t.LibraryID != nil:
+		scope = "library"
+	}
+	return fmt.Sprintf("%s:%s:v%d", scope, t.Task, t.Version)
+}
@@ -7,8 +7,8 @@ import (
 type Scene struct {
 	gorm.Model
 
-	SectionID uint `gorm:"index"`
-	ImageURL  string
+	SectionID uint   `gorm:"index"`
+	ImageURL  string // URL of the scene's image Asset
 	Caption   string `gorm:"type:text"`
 
 	Summary         string  `gorm:"type:text"`
@@ -22,6 +22,15 @@ type Scene struct {
 
 	IsKeyframe bool
 
+	// Provider and model that generated the scene
+	LLMProvider string `gorm:"type:varchar(50)"`
+	LLMModel    string `gorm:"type:varchar(100)"`
+	// The scene generation call that produced it, see AIPrompt
+	SourcePromptID *uint `gorm:"index"`
+	// Prompt template version it was generated with, e.g. builtin:scenes:v1
+	PromptVersion string `gorm:"type:varchar(50);index"`
+
 	AIPrompts        []AIPrompt        `gorm:"constraint:OnDelete:CASCADE;"`
 	AIGenerationJobs []AIGenerationJob `gorm:"polymorphic:Target;polymorphicValue:scene;constraint:OnDelete:CASCADE;"`
+	Assets           []Asset           `gorm:"polymorphic:Owner;polymorphicValue:scene;constraint:OnDelete:CASCADE;"`
 }
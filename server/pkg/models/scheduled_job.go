@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ScheduledJob holds the schedule and last run of a maintenance job. Server
+// instances take a lease on the row before running the job, so each run
+// happens once across instances.
+type ScheduledJob struct {
+	gorm.Model
+
+	Name string `gorm:"uniqueIndex;not null"`
+	Spec string // five-field cron spec, evaluated in UTC
+
+	NextRunAt   *time.Time `gorm:"index"`
+	LockedBy    string
+	LockedUntil *time.Time
+
+	LastRunAt      *time.Time
+	LastFinishedAt *time.Time
+	LastStatus     string `gorm:"type:varchar(20)"` // succeeded / failed
+	LastResult     string `gorm:"type:text"`
+	LastError      string `gorm:"type:text"`
+	LastDurationMs int64
+	LastRunBy      string
+}
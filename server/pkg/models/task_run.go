@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TaskRun is the history of one execution of a volume task.
+type TaskRun struct {
+	gorm.Model
+
+	TaskID   string `gorm:"index"`
+	Kind     string `gorm:"type:varchar(20)"` // parse / scenes / images
+	VolumeID uint   `gorm:"index"`
+	UserID   uint   `gorm:"index"`
+
+	Status       string `gorm:"type:varchar(20);index"` // Use enums.TaskStatus
+	CurrentStage string `gorm:"type:varchar(20)"`       // Use enums.TaskStage
+	Progress     int    `gorm:"default:0"`
+	ItemsDone    int    `gorm:"default:0"` // chapters or images of the current stage
+	ItemsTotal   int    `gorm:"default:0"`
+
+	LLMProvider   string `gorm:"index:idx_task_runs_llm"`
+	LLMModel      string `gorm:"index:idx_task_runs_llm"`
+	ImageProvider string `gorm:"index:idx_task_runs_image"`
+	ImageModel    string `gorm:"index:idx_task_runs_image"`
+
+	LLMCalls        int    `gorm:"default:0"`
+	ImageCalls      int    `gorm:"default:0"`
+	Retries         int    `gorm:"default:0"`
+	RateLimitWaitMs int64  `gorm:"default:0"`
+	Warnings        int    `gorm:"default:0"`
+	Error           string `gorm:"type:text"`
+
+	StartedAt   time.Time
+	HeartbeatAt *time.Time // Refreshed while the run is alive, on whichever instance
+	FinishedAt  *time.Time
+	DurationMs  int64
+
+	Stages []TaskStageRun `gorm:"foreignKey:TaskRunID;constraint:OnDelete:CASCADE"`
+}
+
+// TaskStageRun records one stage of a TaskRun.
+type TaskStageRun struct {
+	gorm.Model
+
+	TaskRunID uint   `gorm:"index"`
+	Stage     string `gorm:"type:varchar(20);index"` // Use enums.TaskStage
+	Items     int    `gorm:"default:0"`              // chapters or images handled
+
+	LLMCalls        int    `gorm:"default:0"`
+	ImageCalls      int    `gorm:"default:0"`
+	Retries         int    `gorm:"default:0"`
+	RateLimitWaitMs int64  `gorm:"default:0"`
+	Errors          string `gorm:"type:text"` // JSON array of warnings and errors
+
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	DurationMs int64
+}
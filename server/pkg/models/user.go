@@ -8,6 +8,7 @@ type User struct {
 	Email        string `gorm:"uniqueIndex"`
 	PasswordHash string
 	DisplayName  string
+	IsAdmin      bool `gorm:"default:false"`
 
 	Libraries []Library `gorm:"constraint:OnDelete:CASCADE;"`
 }
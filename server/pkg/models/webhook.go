@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook is a user-registered URL that receives signed event payloads.
+type Webhook struct {
+	gorm.Model
+
+	UserID      uint   `gorm:"index"`
+	URL         string `gorm:"not null"`
+	Secret      string `gorm:"not null"`  // HMAC-SHA256 key, shown once on creation
+	Events      string `gorm:"type:text"` // JSON array of enums.WebhookEvent
+	Description string
+	Active      bool `gorm:"default:true"`
+
+	Deliveries []WebhookDelivery `gorm:"foreignKey:WebhookID;constraint:OnDelete:CASCADE"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook.
+type WebhookDelivery struct {
+	gorm.Model
+
+	WebhookID uint   `gorm:"index"`
+	EventID   string `gorm:"index"` // Same for every webhook that receives the event
+	Event     string `gorm:"type:varchar(50)"`
+	Payload   string `gorm:"type:text"`
+
+	Status        string     `gorm:"type:varchar(20);index"` // Use enums.DeliveryStatus
+	Attempts      int        `gorm:"default:0"`
+	NextAttemptAt *time.Time `gorm:"index"`
+	ResponseCode  int
+	ResponseBody  string `gorm:"type:text"` // Truncated
+	Error         string `gorm:"type:text"`
+	DeliveredAt   *time.Time
+}
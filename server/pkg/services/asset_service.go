@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"io"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/assets"
+	"gorm.io/gorm"
+)
+
+// AssetService serves stored files, such as scene images, to the owner of
+// the book they belong to.
+type AssetService struct {
+	db     *gorm.DB
+	assets *assets.Store
+}
+
+func NewAssetService(store *assets.Store) *AssetService {
+	return &AssetService{
+		db:     db.GetBooktureDB().DB,
+		assets: store,
+	}
+}
+
+// OpenAsset returns an asset of the user's books and its file, which the
+// caller closes.
+func (as *AssetService) OpenAsset(userID, assetID uint) (*models.Asset, io.ReadSeekCloser, error) {
+	var asset models.Asset
+	err := as.db.Joins("JOIN scenes ON scenes.id = assets.owner_id").
+		Joins("JOIN sections ON sections.id = scenes.section_id").
+		Joins("JOIN chapters ON chapters.id = sections.chapter_id").
+		Joins("JOIN volumes ON volumes.id = chapters.volume_id").
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("assets.id = ? AND assets.owner_type = ? AND libraries.user_id = ?", assetID, enums.AssetOwnerScene.ToString(), userID).
+		First(&asset).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errz.New(errz.NotFound, "Asset not found", err)
+		}
+		return nil, nil, errz.New(errz.InternalServerError, "Failed to fetch asset", err)
+	}
+
+	file, err := as.assets.Open(&asset)
+	if err != nil {
+		return nil, nil, errz.New(errz.NotFound, "Asset file not found", err)
+	}
+	return &asset, file, nil
+}
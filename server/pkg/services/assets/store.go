@@ -0,0 +1,263 @@
+package assets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// extensions names stored files by their detected type.
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Store keeps generated files in storage and tracks each as an Asset row.
+// Clients only ever see the asset's URL.
+type Store struct {
+	db *gorm.DB
+	ss storage.StorageService
+}
+
+func NewStore(ss storage.StorageService) *Store {
+	return &Store{
+		db: db.GetBooktureDB().DB,
+		ss: ss,
+	}
+}
+
+// URL is where the API serves an asset.
+func URL(assetID uint) string {
+	return "/asset?id=" + utils.MaskID(assetID)
+}
+
+// imageInfo is what SaveSceneImage records about an image.
+type imageInfo struct {
+	mimeType      string
+	ext           string
+	width, height int
+}
+
+// inspectImage checks that data is an image of a supported type.
+func inspectImage(data []byte) (imageInfo, error) {
+	info := imageInfo{mimeType: http.DetectContentType(data)}
+	ext, ok := extensions[info.mimeType]
+	if !ok {
+		return info, fmt.Errorf("unsupported image type %s", info.mimeType)
+	}
+	info.ext = ext
+
+	// WebP has no decoder in the standard library, its size stays unknown
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		info.width, info.height = config.Width, config.Height
+	} else if info.mimeType != "image/webp" {
+		return info, fmt.Errorf("image is corrupt: %w", err)
+	}
+	return info, nil
+}
+
+// SaveSceneImage stores an image of a scene in its volume's directory and
+// points the scene at it. A scene has one image, a new one replaces it.
+func (s *Store) SaveSceneImage(ctx context.Context, bookID, volumeID uint, scene *models.Scene, data []byte) error {
+	info, err := inspectImage(data)
+	if err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(data)
+	name := fmt.Sprintf("scene_%d%s", scene.ID, info.ext)
+	path, err := s.ss.SaveAsset(fmt.Sprintf("%d", bookID), fmt.Sprintf("%d", volumeID), name, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	var replaced string
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Struct conditions, unlike a string, are copied into a new asset
+		var asset models.Asset
+		err := tx.Where(models.Asset{OwnerType: enums.AssetOwnerScene.ToString(), OwnerID: scene.ID}).
+			Attrs(models.Asset{FileType: "image"}).
+			FirstOrInit(&asset).Error
+		if err != nil {
+			return err
+		}
+		if asset.StoragePath != path {
+			replaced = asset.StoragePath
+		}
+
+		asset.StoragePath = path
+		asset.MimeType = info.mimeType
+		asset.Width, asset.Height = info.width, info.height
+		asset.Size = int64(len(data))
+		asset.Checksum = hex.EncodeToString(checksum[:])
+		if err := tx.Save(&asset).Error; err != nil {
+			return err
+		}
+
+		asset.FileURL = URL(asset.ID)
+		if err := tx.Model(&asset).Update("file_url", asset.FileURL).Error; err != nil {
+			return err
+		}
+
+		scene.ImageURL = asset.FileURL
+		return tx.Model(scene).Update("image_url", scene.ImageURL).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save image asset: %w", err)
+	}
+
+	if replaced != "" {
+		if err := s.ss.DeleteFile(replaced); err != nil {
+			log.Printf("Failed to delete replaced image of Scene %d: %v", scene.ID, err)
+		}
+	}
+	return nil
+}
+
+// Open returns the file of an asset.
+func (s *Store) Open(asset *models.Asset) (io.ReadSeekCloser, error) {
+	return s.ss.OpenFile(asset.StoragePath)
+}
+
+// DeleteSceneImages removes the image files and assets of every scene of a
+// volume and clears the scenes' image URLs.
+func (s *Store) DeleteSceneImages(ctx context.Context, volumeID uint) error {
+	db := s.db.WithContext(ctx)
+
+	volumeScenes := db.Model(&models.Scene{}).
+		Select("scenes.id").
+		Joins("JOIN sections ON sections.id = scenes.section_id").
+		Joins("JOIN chapters ON chapters.id = sections.chapter_id").
+		Where("chapters.volume_id = ?", volumeID)
+
+	if err := s.deleteImages(ctx, volumeScenes); err != nil {
+		return err
+	}
+
+	if err := db.Model(&models.Scene{}).
+		Where("id IN (?)", volumeScenes).
+		Update("image_url", gorm.Expr("NULL")).Error; err != nil {
+		return fmt.Errorf("failed to clear scene images: %w", err)
+	}
+	return nil
+}
+
+// DeleteImagesOf removes the image files and assets of scenes that have
+// been replaced.
+func (s *Store) DeleteImagesOf(ctx context.Context, sceneIDs []uint) error {
+	if len(sceneIDs) == 0 {
+		return nil
+	}
+	return s.deleteImages(ctx, sceneIDs)
+}
+
+// deleteImages removes the files and assets owned by scenes, a list of
+// scene IDs or a query selecting them.
+func (s *Store) deleteImages(ctx context.Context, scenes any) error {
+	db := s.db.WithContext(ctx)
+
+	var assets []models.Asset
+	if err := db.Unscoped().
+		Where("owner_type = ? AND owner_id IN (?)", enums.AssetOwnerScene.ToString(), scenes).
+		Find(&assets).Error; err != nil {
+		return fmt.Errorf("failed to fetch scene images: %w", err)
+	}
+
+	for _, asset := range assets {
+		if err := s.ss.DeleteFile(asset.StoragePath); err != nil {
+			log.Printf("Failed to delete image of Scene %d: %v", asset.OwnerID, err)
+		}
+	}
+	if len(assets) > 0 {
+		if err := db.Unscoped().Delete(&assets).Error; err != nil {
+			return fmt.Errorf("failed to delete scene images: %w", err)
+		}
+	}
+	return nil
+}
+
+// MigrateInlineImages moves scene images still stored inline as base64, as
+// they were before assets, into storage. It works through batch scenes at a
+// time until none are left and returns how many it moved. A scene whose
+// image cannot be decoded loses it, so the next image run regenerates it.
+func (s *Store) MigrateInlineImages(ctx context.Context, batch int) (int, error) {
+	db := s.db.WithContext(ctx)
+
+	moved := 0
+	for {
+		var scenes []struct {
+			models.Scene
+			BookID   uint
+			VolumeID uint
+		}
+		err := db.Model(&models.Scene{}).
+			Select("scenes.*, volumes.book_id AS book_id, volumes.id AS volume_id").
+			Joins("JOIN sections ON sections.id = scenes.section_id").
+			Joins("JOIN chapters ON chapters.id = sections.chapter_id").
+			Joins("JOIN volumes ON volumes.id = chapters.volume_id").
+			Where("scenes.image_url <> '' AND scenes.image_url NOT LIKE '/asset%' AND scenes.image_url NOT LIKE 'http%'").
+			Order("scenes.id ASC").
+			Limit(batch).
+			Scan(&scenes).Error
+		if err != nil {
+			return moved, fmt.Errorf("failed to fetch inline images: %w", err)
+		}
+
+		for i := range scenes {
+			if err := ctx.Err(); err != nil {
+				return moved, err
+			}
+			scene := &scenes[i].Scene
+
+			data, err := decodeInline(scene.ImageURL)
+			if err == nil {
+				_, err = inspectImage(data)
+			}
+			if err != nil {
+				log.Printf("Dropping unreadable inline image of Scene %d: %v", scene.ID, err)
+				if err := db.Model(scene).Update("image_url", gorm.Expr("NULL")).Error; err != nil {
+					return moved, fmt.Errorf("failed to clear image of Scene %d: %w", scene.ID, err)
+				}
+				continue
+			}
+
+			if err := s.SaveSceneImage(ctx, scenes[i].BookID, scenes[i].VolumeID, scene, data); err != nil {
+				return moved, fmt.Errorf("failed to move image of Scene %d: %w", scene.ID, err)
+			}
+			moved++
+		}
+
+		if len(scenes) < batch {
+			return moved, nil
+		}
+	}
+}
+
+// decodeInline decodes a base64 image, with or without a data URL prefix.
+func decodeInline(value string) ([]byte, error) {
+	if _, payload, ok := strings.Cut(value, ";base64,"); ok && strings.HasPrefix(value, "data:") {
+		value = payload
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
@@ -0,0 +1,87 @@
+package assets
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/db/dbtest"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveSceneImage(t *testing.T) {
+	conn := dbtest.Open(t)
+	saved := config.AppConfig
+	config.AppConfig.STORAGE_DRIVER = "local"
+	config.AppConfig.STORAGE_PATH = t.TempDir()
+	t.Cleanup(func() { config.AppConfig = saved })
+
+	store := NewStore(storage.NewStorageService())
+	ctx := context.Background()
+
+	volume := models.Volume{BookID: 1}
+	conn.Create(&volume)
+	chapter := models.Chapter{VolumeID: volume.ID, Sections: []models.Section{{SectionNo: 1}}}
+	conn.Create(&chapter)
+	scene := models.Scene{SectionID: chapter.Sections[0].ID}
+	conn.Create(&scene)
+
+	sceneAssets := func() []models.Asset {
+		t.Helper()
+		var assets []models.Asset
+		if err := conn.Where("owner_type = ? AND owner_id = ?", enums.AssetOwnerScene.ToString(), scene.ID).Find(&assets).Error; err != nil {
+			t.Fatalf("load assets: %v", err)
+		}
+		return assets
+	}
+
+	if err := store.SaveSceneImage(ctx, 1, volume.ID, &scene, testPNG(t, 4, 3)); err != nil {
+		t.Fatalf("SaveSceneImage: %v", err)
+	}
+	first := sceneAssets()
+	if len(first) != 1 {
+		t.Fatalf("scene owns %d assets, want 1", len(first))
+	}
+	if a := first[0]; a.MimeType != "image/png" || a.Width != 4 || a.Height != 3 || scene.ImageURL != URL(a.ID) {
+		t.Errorf("asset = %+v, scene image %s", a, scene.ImageURL)
+	}
+
+	// A new image replaces the old one in the same asset
+	if err := store.SaveSceneImage(ctx, 1, volume.ID, &scene, testPNG(t, 8, 8)); err != nil {
+		t.Fatalf("SaveSceneImage again: %v", err)
+	}
+	second := sceneAssets()
+	if len(second) != 1 || second[0].ID != first[0].ID || second[0].Width != 8 || second[0].Checksum == first[0].Checksum {
+		t.Errorf("assets after a new image = %+v, want the first one updated", second)
+	}
+
+	if err := store.DeleteSceneImages(ctx, volume.ID); err != nil {
+		t.Fatalf("DeleteSceneImages: %v", err)
+	}
+	if left := sceneAssets(); len(left) != 0 {
+		t.Errorf("%d assets left after DeleteSceneImages", len(left))
+	}
+	if _, err := os.Stat(second[0].StoragePath); !os.IsNotExist(err) {
+		t.Errorf("image file still exists: %v", err)
+	}
+	var cleared models.Scene
+	conn.First(&cleared, scene.ID)
+	if cleared.ImageURL != "" {
+		t.Errorf("scene image URL = %q after delete", cleared.ImageURL)
+	}
+}
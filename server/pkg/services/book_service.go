@@ -1,8 +1,10 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"mime/multipart"
 	"path/filepath"
 	"strings"
@@ -12,6 +14,7 @@ import (
 	"github.com/Mahaveer86619/bookture/server/pkg/enums"
 	"github.com/Mahaveer86619/bookture/server/pkg/errz"
 	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/events"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/parser"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
 	"github.com/Mahaveer86619/bookture/server/pkg/views"
@@ -24,13 +27,20 @@ type BookService struct {
 	libService *LibraryService
 	processor  *ProcessingService
 	parser     *parser.ParserService
+	jobs       *JobStore // set when PROCESSING_MODE=external
+	history    *TaskHistoryService
+	emitter    events.Emitter
 }
 
+// NewBookService runs jobs on proc, or hands them to cmd/worker through jobs
+// when jobs is not nil.
 func NewBookService(
 	ss storage.StorageService,
 	lib *LibraryService,
 	proc *ProcessingService,
 	parser *parser.ParserService,
+	jobs *JobStore,
+	emitter events.Emitter,
 ) *BookService {
 	return &BookService{
 		db:         db.GetBooktureDB().DB,
@@ -38,6 +48,9 @@ func NewBookService(
 		libService: lib,
 		processor:  proc,
 		parser:     parser,
+		jobs:       jobs,
+		history:    NewTaskHistoryService(),
+		emitter:    emitter,
 	}
 }
 
@@ -62,6 +75,7 @@ func (bs *BookService) CreateDraftBook(userID, libID uint, title string, author
 	}
 
 	v := views.ToBookView(&book)
+	bs.emitter.Emit(userID, enums.WebhookBookCreated, v)
 	return &v, nil
 }
 
@@ -127,20 +141,103 @@ func (bs *BookService) UploadVolume(userID uint, bookID uint, fileHeader *multip
 		bs.db.Save(&book)
 	}
 
-	jobID := fmt.Sprintf("parse-vol-%d", volume.ID)
+	// A full queue is not an error for the upload: the volume stays in
+	// "uploaded" and the reconciler picks it up once there is room. Until
+	// then there is no task to follow.
+	v := views.ToVolumeView(&volume)
+	jobID, err := bs.enqueueParse(userID, volume.ID)
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		log.Printf("Queue full, Volume %d left pending for the reconciler", volume.ID)
+		v.Pending = true
+	case err != nil:
+		return nil, errz.New(errz.InternalServerError, "Failed to schedule volume processing", err)
+	default:
+		v.TaskID = jobID
+	}
+
+	return &v, nil
+}
+
+func (bs *BookService) enqueueParse(userID, volumeID uint) (string, error) {
+	return bs.dispatch(taskKindParse, userID, volumeID)
+}
+
+// dispatch schedules a volume job in-process or, in external mode, writes it
+// to the job table for a worker to claim.
+func (bs *BookService) dispatch(kind string, userID, volumeID uint) (string, error) {
+	taskID := volumeTaskID(kind, volumeID)
+	opts, err := volumeJobOptions(kind, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if bs.jobs != nil {
+		return taskID, bs.jobs.Enqueue(taskID, kind, volumeID, opts)
+	}
 
-	bs.processor.Enqueue(jobID, func(reportProgress func(int)) {
-		wrappedReporter := func(p int) {
-			reportProgress(p)
-			bs.db.Model(&models.Volume{}).Where("id = ?", volume.ID).Update("progress", p)
+	job, err := newVolumeJob(bs.db, bs.parser, kind, volumeID, userID)
+	if err != nil {
+		return "", err
+	}
+	return taskID, bs.processor.Enqueue(taskID, opts, job)
+}
+
+// isTaskActive reports whether a task is queued here or in the job table, or
+// running on any instance going by its heartbeat.
+func (bs *BookService) isTaskActive(taskID string) bool {
+	if bs.jobs != nil {
+		if bs.jobs.IsActive(taskID) {
+			return true
 		}
-		bs.parser.ProcessVolumeComplete(volume.ID, wrappedReporter)
-	})
+	} else if bs.processor.IsActive(taskID) {
+		return true
+	}
+	return bs.history.IsLive(taskID)
+}
 
-	v := views.ToVolumeView(&volume)
-	v.TaskID = jobID
+// ReconcileStuckVolumes re-enqueues volumes that sit in "uploaded" or
+// "parsing" without a live job, e.g. after a full queue or a restart. A run
+// counts as live while its heartbeat is fresh, so volumes running on other
+// instances are left alone, as are volumes touched within grace.
+func (bs *BookService) ReconcileStuckVolumes(ctx context.Context, grace time.Duration) (int, error) {
+	var stuck []struct {
+		VolumeID uint
+		UserID   uint
+	}
 
-	return &v, nil
+	err := bs.db.WithContext(ctx).Model(&models.Volume{}).
+		Select("volumes.id AS volume_id, libraries.user_id AS user_id").
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("volumes.uploaded = ? AND volumes.status IN ?", true,
+			[]string{enums.VolumeUploaded.ToString(), enums.VolumeParsing.ToString()}).
+		Where("volumes.updated_at < ?", time.Now().Add(-grace)).
+		Order("volumes.id ASC").
+		Scan(&stuck).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find stuck volumes: %w", err)
+	}
+
+	requeued := 0
+	for _, v := range stuck {
+		if bs.isTaskActive(volumeTaskID(taskKindParse, v.VolumeID)) {
+			continue
+		}
+
+		_, err := bs.enqueueParse(v.UserID, v.VolumeID)
+		if errors.Is(err, ErrQueueFull) {
+			break
+		}
+		if err != nil {
+			continue
+		}
+
+		log.Printf("Reconciler re-enqueued Volume %d", v.VolumeID)
+		requeued++
+	}
+
+	return requeued, nil
 }
 
 func (bs *BookService) GetBook(userID uint, bookID uint) (*views.BookView, error) {
@@ -160,9 +257,165 @@ func (bs *BookService) GetBook(userID uint, bookID uint) (*views.BookView, error
 	return &v, nil
 }
 
-func (bs *BookService) GetTaskProgress(taskID string) (int, error) {
-	progress := bs.processor.GetProgress(taskID)
-	return progress, nil
+func (bs *BookService) GetTaskProgress(taskID string) (TaskState, error) {
+	var state TaskState
+	var known bool
+	if bs.jobs != nil {
+		var err error
+		state, err = bs.jobs.Progress(taskID)
+		if err != nil && !errors.Is(err, ErrTaskNotFound) {
+			return TaskState{}, errz.New(errz.InternalServerError, "Failed to get task progress", err)
+		}
+		known = err == nil
+	} else {
+		state, known = bs.processor.State(taskID)
+	}
+	if known {
+		return state, nil
+	}
+
+	// Finished tasks expire from memory and old job rows are compacted;
+	// fall back to the history
+	run, err := bs.history.Latest(taskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return TaskState{}, errz.New(errz.NotFound, "Task not found", err)
+		}
+		return TaskState{}, errz.New(errz.InternalServerError, "Failed to get task progress", err)
+	}
+	return TaskState{Progress: run.Progress, Status: enums.TaskStatus(run.Status)}, nil
+}
+
+// EstimateTask returns the remaining time of a running task, based on how
+// long earlier runs on the same provider and model took.
+func (bs *BookService) EstimateTask(taskID string) (time.Duration, bool) {
+	return bs.history.Estimate(taskID)
+}
+
+// GetVolumeHistory lists every task that ran for a volume with its stage timings.
+func (bs *BookService) GetVolumeHistory(userID, volumeID uint) ([]views.TaskRunView, error) {
+	return bs.history.VolumeHistory(userID, volumeID)
+}
+
+// CancelTask stops a volume's processing job. Scenes and images generated
+// before the cancellation are kept.
+func (bs *BookService) CancelTask(userID uint, taskID string) error {
+	if err := bs.authorizeTask(userID, taskID); err != nil {
+		return err
+	}
+
+	cancel := bs.processor.Cancel
+	if bs.jobs != nil {
+		cancel = bs.jobs.RequestCancel
+	}
+
+	if err := cancel(taskID); err != nil {
+		if errors.Is(err, ErrTaskFinished) {
+			return errz.New(errz.Conflict, "Task already finished", err)
+		}
+		if errors.Is(err, ErrTaskNotFound) {
+			return errz.New(errz.NotFound, "Task not found", err)
+		}
+		return errz.New(errz.InternalServerError, "Failed to cancel task", err)
+	}
+
+	// A parse job cancelled before it started never touched the volume; mark
+	// it here so the reconciler does not pick it up again.
+	if strings.HasPrefix(taskID, taskKindParse+"-") {
+		volumeID, _ := volumeIDFromTask(taskID)
+		bs.db.Model(&models.Volume{}).
+			Where("id = ? AND status = ?", volumeID, enums.VolumeUploaded.ToString()).
+			Update("status", enums.VolumeCancelled.ToString())
+	}
+
+	return nil
+}
+
+// SubscribeTask streams progress events of a task owned by the user.
+func (bs *BookService) SubscribeTask(userID uint, taskID string, lastEventID int64) ([]views.TaskEvent, <-chan views.TaskEvent, func(), error) {
+	if err := bs.authorizeTask(userID, taskID); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var replay []views.TaskEvent
+	var events <-chan views.TaskEvent
+	var unsubscribe func()
+	var ok bool
+	if bs.jobs != nil {
+		replay, events, unsubscribe, ok = bs.jobs.Follow(taskID, taskPollInterval)
+	} else {
+		replay, events, unsubscribe, ok = bs.processor.Subscribe(taskID, lastEventID)
+	}
+	if !ok {
+		return nil, nil, nil, errz.New(errz.NotFound, "Task not found", nil)
+	}
+	return replay, events, unsubscribe, nil
+}
+
+// authorizeTask checks that the task's volume belongs to the user.
+func (bs *BookService) authorizeTask(userID uint, taskID string) error {
+	volumeID, ok := volumeIDFromTask(taskID)
+	if !ok {
+		return errz.New(errz.BadRequest, "Invalid task ID", nil)
+	}
+
+	var count int64
+	err := bs.db.Model(&models.Volume{}).
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("volumes.id = ? AND libraries.user_id = ?", volumeID, userID).
+		Count(&count).Error
+	if err != nil {
+		return errz.New(errz.InternalServerError, "Database error", err)
+	}
+	if count == 0 {
+		return errz.New(errz.NotFound, "Task not found", nil)
+	}
+	return nil
+}
+
+// RegenerateVolume re-runs the scene or image stage of a volume. The user is
+// waiting on it, so it jumps ahead of bulk uploads.
+func (bs *BookService) RegenerateVolume(userID uint, volumeID uint, stage enums.TaskStage) (string, error) {
+	var volume models.Volume
+	err := bs.db.
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("volumes.id = ? AND libraries.user_id = ?", volumeID, userID).
+		First(&volume).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", errz.New(errz.NotFound, "Volume not found", err)
+		}
+		return "", errz.New(errz.InternalServerError, "Database error", err)
+	}
+
+	if volume.ChapterCount == 0 {
+		return "", errz.New(errz.Conflict, "Volume has not been parsed yet", nil)
+	}
+
+	var kind string
+	switch stage {
+	case enums.StageScenes:
+		kind = taskKindScenes
+	case enums.StageImages:
+		kind = taskKindImages
+	default:
+		return "", errz.New(errz.BadRequest, "stage must be scenes or images", nil)
+	}
+
+	jobID, err := bs.dispatch(kind, userID, volumeID)
+	if errors.Is(err, ErrQueueFull) {
+		return "", errz.Unavailable("Processing queue is full, try again later", queueRetryAfter, err)
+	}
+	if errors.Is(err, ErrTaskActive) {
+		return "", errz.New(errz.Conflict, "This volume is already being regenerated", err)
+	}
+	if err != nil {
+		return "", errz.New(errz.InternalServerError, "Failed to schedule regeneration", err)
+	}
+
+	return jobID, nil
 }
 
 func (bs *BookService) GetVolumeDetails(userID uint, volumeID uint) (*views.VolumeDetailView, error) {
@@ -233,6 +486,10 @@ func (bs *BookService) GetVolumeDetails(userID uint, volumeID uint) (*views.Volu
 					SceneType:       sc.SceneType,
 					Location:        sc.Location,
 					Mood:            sc.Mood,
+					PromptVersion:   sc.PromptVersion,
+				}
+				if sc.LLMProvider != "" {
+					secView.Scenes[k].GeneratedBy = sc.LLMProvider + ":" + sc.LLMModel
 				}
 			}
 			chView.Sections[j] = secView
@@ -242,3 +499,75 @@ func (bs *BookService) GetVolumeDetails(userID uint, volumeID uint) (*views.Volu
 
 	return view, nil
 }
+
+// EstimateVolume works out the LLM requests, tokens and cost of processing
+// an uploaded volume, so they can be checked before it starts.
+func (bs *BookService) EstimateVolume(ctx context.Context, userID uint, volumeID uint) (*views.VolumeEstimateView, error) {
+	var volume models.Volume
+	err := bs.db.
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("volumes.id = ? AND libraries.user_id = ?", volumeID, userID).
+		Preload("Book").
+		First(&volume).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errz.New(errz.NotFound, "Volume not found", err)
+		}
+		return nil, err
+	}
+
+	if !volume.Uploaded || volume.FilePath == "" {
+		return nil, errz.New(errz.BadRequest, "Volume has no uploaded file", nil)
+	}
+
+	estimate, err := bs.parser.EstimateVolume(ctx, &volume)
+	if err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to estimate volume", err)
+	}
+	return estimate, nil
+}
+
+// GetScenePrompts returns the recorded calls behind a scene, so a bad
+// summary or image can be traced to the exact request.
+func (bs *BookService) GetScenePrompts(userID uint, sceneID uint) (*views.ScenePromptsView, error) {
+	var scene models.Scene
+	err := bs.db.
+		Joins("JOIN sections ON sections.id = scenes.section_id").
+		Joins("JOIN chapters ON chapters.id = sections.chapter_id").
+		Joins("JOIN volumes ON volumes.id = chapters.volume_id").
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("scenes.id = ? AND libraries.user_id = ?", sceneID, userID).
+		First(&scene).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errz.New(errz.NotFound, "Scene not found", err)
+		}
+		return nil, err
+	}
+
+	view := &views.ScenePromptsView{SceneID: scene.ID, Images: []views.AIPromptView{}}
+
+	if scene.SourcePromptID != nil {
+		var source models.AIPrompt
+		err := bs.db.First(&source, *scene.SourcePromptID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errz.New(errz.InternalServerError, "Failed to fetch scene prompt", err)
+		}
+		if err == nil {
+			v := views.ToAIPromptView(&source)
+			view.Source = &v
+		}
+	}
+
+	var images []models.AIPrompt
+	if err := bs.db.Where("scene_id = ?", scene.ID).Order("created_at ASC").Find(&images).Error; err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to fetch image prompts", err)
+	}
+	for i := range images {
+		view.Images = append(view.Images, views.ToAIPromptView(&images[i]))
+	}
+
+	return view, nil
+}
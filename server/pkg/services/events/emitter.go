@@ -0,0 +1,14 @@
+package events
+
+import "github.com/Mahaveer86619/bookture/server/pkg/enums"
+
+// Emitter publishes domain events for a user, e.g. to their webhooks.
+// Emit must not block the caller on delivery.
+type Emitter interface {
+	Emit(userID uint, event enums.WebhookEvent, data any)
+}
+
+// Nop drops every event. Used where no listener is configured.
+type Nop struct{}
+
+func (Nop) Emit(uint, enums.WebhookEvent, any) {}
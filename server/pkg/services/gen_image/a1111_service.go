@@ -0,0 +1,156 @@
+package gen_image
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+)
+
+// A1111Service generates images with the API of Automatic1111's Stable
+// Diffusion WebUI, started with --api, at IMAGE_HOST.
+type A1111Service struct {
+	host    string
+	model   string
+	timeout time.Duration
+	client  *http.Client
+}
+
+type a1111Request struct {
+	Prompt           string         `json:"prompt"`
+	NegativePrompt   string         `json:"negative_prompt,omitempty"`
+	Width            int            `json:"width,omitempty"`
+	Height           int            `json:"height,omitempty"`
+	Seed             int64          `json:"seed"`
+	Steps            int            `json:"steps,omitempty"`
+	CfgScale         float64        `json:"cfg_scale,omitempty"`
+	OverrideSettings map[string]any `json:"override_settings,omitempty"`
+}
+
+type a1111Response struct {
+	Images []string `json:"images"` // base64 PNGs
+	Info   string   `json:"info"`   // JSON of the parameters used
+}
+
+func (s *A1111Service) Init() error {
+	cfg := config.AppConfig
+	s.host = strings.TrimRight(cfg.IMAGE_HOST, "/")
+	s.model = cfg.IMAGE_MODEL
+	s.timeout = time.Duration(cfg.IMAGE_TIMEOUT) * time.Second
+	// A request lasts as long as the image takes, it is bounded by timeout
+	s.client = &http.Client{}
+
+	fmt.Printf("Gen Image Service initialized (Provider: Stable Diffusion WebUI, Host: %s)\n", s.host)
+	return nil
+}
+
+func (s *A1111Service) HealthCheck() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.host+"/sdapi/v1/sd-models", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stable diffusion webui not reachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stable diffusion webui API error (%d), is it started with --api?", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *A1111Service) GenerateImage(ctx context.Context, imgReq ImageRequest) (*ImageResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	imgReq = limitRequest(imgReq)
+	payload := a1111Request{
+		Prompt:         imgReq.Prompt,
+		NegativePrompt: imgReq.NegativePrompt,
+		Width:          imgReq.Width,
+		Height:         imgReq.Height,
+		Seed:           randomSeed(imgReq),
+		Steps:          imgReq.Steps,
+		CfgScale:       imgReq.Guidance,
+	}
+	if s.model != "" {
+		payload.OverrideSettings = map[string]any{"sd_model_checkpoint": s.model}
+	}
+
+	requestBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal failed: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.host+"/sdapi/v1/txt2img", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("request creation failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(msg))
+	}
+
+	// The image comes base64 encoded, a third larger than itself
+	body, err := readLimited(resp.Body, maxImageBytes*4/3+64<<10)
+	if err != nil {
+		return nil, err
+	}
+
+	var result a1111Response
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if len(result.Images) == 0 {
+		return nil, fmt.Errorf("API returned no image")
+	}
+
+	image, err := base64.StdEncoding.DecodeString(result.Images[0])
+	if err != nil {
+		return nil, fmt.Errorf("API returned an invalid image: %w", err)
+	}
+
+	// The info reports what the WebUI really used
+	var info struct {
+		Seed        int64  `json:"seed"`
+		SDModelName string `json:"sd_model_name"`
+		SamplerName string `json:"sampler_name"`
+		Steps       int    `json:"steps"`
+	}
+	_ = json.Unmarshal([]byte(result.Info), &info)
+
+	seed := payload.Seed
+	if info.Seed != 0 {
+		seed = info.Seed
+	}
+	return &ImageResult{
+		Bytes: image,
+		MIME:  http.DetectContentType(image),
+		Seed:  seed,
+		Metadata: map[string]any{
+			"model":   info.SDModelName,
+			"sampler": info.SamplerName,
+			"steps":   info.Steps,
+		},
+	}, nil
+}
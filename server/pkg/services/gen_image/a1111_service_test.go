@@ -0,0 +1,161 @@
+package gen_image
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+)
+
+// fakeA1111 serves /sdapi/v1/txt2img with handle and returns a service
+// initialized against it.
+func fakeA1111(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, req a1111Request)) *A1111Service {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/sdapi/v1/txt2img" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var req a1111Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		handle(w, r, req)
+	}))
+	t.Cleanup(srv.Close)
+
+	useConfig(t, config.Config{
+		IMAGE_HOST:      srv.URL + "/",
+		IMAGE_MODEL:     "sdxl.safetensors",
+		IMAGE_TIMEOUT:   5,
+		IMAGE_MAX_SIDE:  1024,
+		IMAGE_MAX_STEPS: 30,
+	})
+	s := &A1111Service{}
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return s
+}
+
+func writeA1111(w http.ResponseWriter, image []byte, info map[string]any) {
+	infoJSON, _ := json.Marshal(info)
+	_ = json.NewEncoder(w).Encode(a1111Response{
+		Images: []string{base64.StdEncoding.EncodeToString(image)},
+		Info:   string(infoJSON),
+	})
+}
+
+func TestA1111GenerateImage(t *testing.T) {
+	image := testPNG(t)
+
+	var sent a1111Request
+	s := fakeA1111(t, func(w http.ResponseWriter, _ *http.Request, req a1111Request) {
+		sent = req
+		writeA1111(w, image, map[string]any{
+			"seed":          req.Seed,
+			"sd_model_name": "sdxl",
+			"sampler_name":  "Euler a",
+			"steps":         req.Steps,
+		})
+	})
+
+	result, err := s.GenerateImage(context.Background(), ImageRequest{
+		Prompt:   "a lighthouse at dusk",
+		Width:    2048,
+		Height:   1536,
+		Steps:    80,
+		Guidance: 6.5,
+		Seed:     42,
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage: %v", err)
+	}
+
+	if sent.Prompt != "a lighthouse at dusk" || sent.Seed != 42 || sent.CfgScale != 6.5 {
+		t.Errorf("request = %+v", sent)
+	}
+	if sent.Width != 1024 || sent.Height != 768 || sent.Steps != 30 {
+		t.Errorf("request not limited: %dx%d, %d steps", sent.Width, sent.Height, sent.Steps)
+	}
+	if sent.OverrideSettings["sd_model_checkpoint"] != "sdxl.safetensors" {
+		t.Errorf("override_settings = %v", sent.OverrideSettings)
+	}
+
+	if result.MIME != "image/png" || len(result.Bytes) != len(image) {
+		t.Errorf("result is %s, %d bytes", result.MIME, len(result.Bytes))
+	}
+	if result.Seed != 42 || result.Metadata["model"] != "sdxl" || result.Metadata["steps"] != 30 {
+		t.Errorf("result seed %d, metadata %v", result.Seed, result.Metadata)
+	}
+}
+
+func TestA1111ReportsSeedUsed(t *testing.T) {
+	s := fakeA1111(t, func(w http.ResponseWriter, _ *http.Request, req a1111Request) {
+		if req.Seed == 0 {
+			t.Error("random seed was not picked")
+		}
+		writeA1111(w, testPNG(t), map[string]any{"seed": 1234})
+	})
+
+	result, err := s.GenerateImage(context.Background(), ImageRequest{Prompt: "a fox"})
+	if err != nil {
+		t.Fatalf("GenerateImage: %v", err)
+	}
+	if result.Seed != 1234 {
+		t.Errorf("Seed = %d, want the one the WebUI reported", result.Seed)
+	}
+}
+
+func TestA1111Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(http.ResponseWriter, *http.Request, a1111Request)
+		want   string
+	}{
+		{"status", func(w http.ResponseWriter, _ *http.Request, _ a1111Request) {
+			http.Error(w, "CUDA out of memory", http.StatusInternalServerError)
+		}, "API error (500)"},
+		{"no image", func(w http.ResponseWriter, _ *http.Request, _ a1111Request) {
+			_, _ = w.Write([]byte(`{"images":[],"info":"{}"}`))
+		}, "no image"},
+		{"invalid image", func(w http.ResponseWriter, _ *http.Request, _ a1111Request) {
+			_, _ = w.Write([]byte(`{"images":["not base64!"],"info":"{}"}`))
+		}, "invalid image"},
+		{"too large", func(w http.ResponseWriter, _ *http.Request, _ a1111Request) {
+			writeA1111(w, make([]byte, 128<<10), nil)
+		}, "larger than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMaxImageBytes(t, 1024)
+			s := fakeA1111(t, tt.handle)
+
+			_, err := s.GenerateImage(context.Background(), ImageRequest{Prompt: "a fox"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestA1111Timeout(t *testing.T) {
+	s := fakeA1111(t, func(_ http.ResponseWriter, r *http.Request, _ a1111Request) {
+		<-r.Context().Done()
+	})
+	s.timeout = 50 * time.Millisecond
+
+	_, err := s.GenerateImage(context.Background(), ImageRequest{Prompt: "a fox"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the deadline", err)
+	}
+}
@@ -0,0 +1,371 @@
+package gen_image
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+)
+
+const (
+	// comfyPollInterval is how often the history of a queued prompt is read.
+	comfyPollInterval = time.Second
+	// comfyCallTimeout bounds each API call; the image itself is bounded by
+	// IMAGE_TIMEOUT.
+	comfyCallTimeout = 30 * time.Second
+)
+
+// defaultWorkflow is a plain checkpoint text-to-image graph, used when
+// IMAGE_WORKFLOW is not set.
+//
+//go:embed workflows/txt2img.json
+var defaultWorkflow []byte
+
+// ComfyUIService runs a workflow on the ComfyUI server at IMAGE_HOST for
+// each image. The request goes into the workflow through placeholders, see
+// fillWorkflow; the prompt is queued, its history polled until it is done,
+// and the image it saved is downloaded.
+type ComfyUIService struct {
+	host     string
+	model    string
+	timeout  time.Duration
+	clientID string
+	workflow map[string]any
+	client   *http.Client
+}
+
+// comfyImage is an output file as ComfyUI lists it.
+type comfyImage struct {
+	Filename  string `json:"filename"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+type comfyHistory struct {
+	Outputs map[string]struct {
+		Images []comfyImage `json:"images"`
+	} `json:"outputs"`
+	Status struct {
+		StatusStr string            `json:"status_str"`
+		Completed bool              `json:"completed"`
+		Messages  []json.RawMessage `json:"messages"`
+	} `json:"status"`
+}
+
+func (s *ComfyUIService) Init() error {
+	cfg := config.AppConfig
+	s.host = strings.TrimRight(cfg.IMAGE_HOST, "/")
+	s.model = cfg.IMAGE_MODEL
+	s.timeout = time.Duration(cfg.IMAGE_TIMEOUT) * time.Second
+	s.client = &http.Client{Timeout: comfyCallTimeout}
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	s.clientID = "bookture-" + hex.EncodeToString(id)
+
+	raw := defaultWorkflow
+	if cfg.IMAGE_WORKFLOW != "" {
+		var err error
+		if raw, err = os.ReadFile(cfg.IMAGE_WORKFLOW); err != nil {
+			return fmt.Errorf("failed to read ComfyUI workflow: %w", err)
+		}
+	} else if s.model == "" {
+		return fmt.Errorf("IMAGE_MODEL must name a checkpoint for the built-in ComfyUI workflow")
+	}
+
+	if err := json.Unmarshal(raw, &s.workflow); err != nil {
+		return fmt.Errorf("ComfyUI workflow is not valid JSON: %w", err)
+	}
+	if !strings.Contains(string(raw), "{{prompt}}") {
+		return fmt.Errorf("ComfyUI workflow has no {{prompt}} placeholder, export it in API format and add one")
+	}
+
+	fmt.Printf("Gen Image Service initialized (Provider: ComfyUI, Host: %s)\n", s.host)
+	return nil
+}
+
+func (s *ComfyUIService) HealthCheck() error {
+	resp, err := s.client.Get(s.host + "/system_stats")
+	if err != nil {
+		return fmt.Errorf("comfyui not reachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("comfyui API error (%d)", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *ComfyUIService) GenerateImage(ctx context.Context, imgReq ImageRequest) (*ImageResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	imgReq = limitRequest(imgReq)
+	imgReq.Seed = randomSeed(imgReq)
+
+	promptID, err := s.queuePrompt(ctx, fillWorkflow(s.workflow, s.placeholders(imgReq)))
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.waitForPrompt(ctx, promptID)
+	if err != nil {
+		if ctx.Err() != nil {
+			s.dequeue(promptID)
+		}
+		return nil, err
+	}
+
+	var output *comfyImage
+outputs:
+	for _, node := range history.Outputs {
+		for i := range node.Images {
+			if node.Images[i].Type == "output" {
+				output = &node.Images[i]
+				break outputs
+			}
+		}
+	}
+	if output == nil {
+		return nil, fmt.Errorf("workflow saved no image, does it end in a SaveImage node?")
+	}
+
+	image, mimeType, err := s.fetchImage(ctx, *output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImageResult{
+		Bytes: image,
+		MIME:  mimeType,
+		Seed:  imgReq.Seed,
+		Metadata: map[string]any{
+			"model":     s.model,
+			"prompt_id": promptID,
+		},
+	}, nil
+}
+
+// placeholders are what fillWorkflow puts into the workflow. Settings the
+// request leaves to the provider get the usual ComfyUI defaults, since a
+// workflow has no defaults of its own.
+func (s *ComfyUIService) placeholders(req ImageRequest) map[string]any {
+	width, height := req.Width, req.Height
+	if width == 0 || height == 0 {
+		width, height = 1024, 1024
+	}
+	steps := req.Steps
+	if steps == 0 {
+		steps = 20
+	}
+	guidance := req.Guidance
+	if guidance == 0 {
+		guidance = 7
+	}
+
+	return map[string]any{
+		"prompt":          req.Prompt,
+		"negative_prompt": req.NegativePrompt,
+		"model":           s.model,
+		"seed":            req.Seed,
+		"width":           width,
+		"height":          height,
+		"steps":           steps,
+		"guidance":        guidance,
+	}
+}
+
+// fillWorkflow copies a workflow with its placeholders replaced. A string
+// that is exactly one placeholder becomes its value, so "{{seed}}" turns
+// into a number; placeholders inside longer strings are replaced as text.
+func fillWorkflow(node any, values map[string]any) any {
+	switch v := node.(type) {
+	case map[string]any:
+		filled := make(map[string]any, len(v))
+		for key, child := range v {
+			filled[key] = fillWorkflow(child, values)
+		}
+		return filled
+
+	case []any:
+		filled := make([]any, len(v))
+		for i, child := range v {
+			filled[i] = fillWorkflow(child, values)
+		}
+		return filled
+
+	case string:
+		if name, ok := strings.CutPrefix(v, "{{"); ok {
+			if name, ok := strings.CutSuffix(name, "}}"); ok {
+				if value, ok := values[name]; ok {
+					return value
+				}
+			}
+		}
+		for name, value := range values {
+			v = strings.ReplaceAll(v, "{{"+name+"}}", fmt.Sprint(value))
+		}
+		return v
+	}
+	return node
+}
+
+// queuePrompt adds a filled workflow to ComfyUI's queue and returns its ID.
+func (s *ComfyUIService) queuePrompt(ctx context.Context, workflow any) (string, error) {
+	body, err := json.Marshal(map[string]any{"prompt": workflow, "client_id": s.clientID})
+	if err != nil {
+		return "", fmt.Errorf("marshal failed: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.host+"/prompt", bytes.NewBuffer(body))
+	if err != nil {
+		return "", fmt.Errorf("request creation failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("network error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// A workflow that does not validate comes back as 400 with node_errors
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("API error (%d): %s", resp.StatusCode, string(msg))
+	}
+
+	var queued struct {
+		PromptID string `json:"prompt_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&queued); err != nil || queued.PromptID == "" {
+		return "", fmt.Errorf("invalid queue response: %v", err)
+	}
+	return queued.PromptID, nil
+}
+
+// waitForPrompt polls the history until the prompt has run. ComfyUI lists a
+// prompt there only once it finished or failed.
+func (s *ComfyUIService) waitForPrompt(ctx context.Context, promptID string) (*comfyHistory, error) {
+	ticker := time.NewTicker(comfyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		history, err := s.getHistory(ctx, promptID)
+		if err != nil {
+			return nil, err
+		}
+		if history != nil {
+			if history.Status.StatusStr == "error" {
+				return nil, fmt.Errorf("workflow failed: %s", lastMessage(history))
+			}
+			if history.Status.Completed {
+				return history, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ComfyUIService) getHistory(ctx context.Context, promptID string) (*comfyHistory, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.host+"/history/"+url.PathEscape(promptID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request creation failed: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(msg))
+	}
+
+	var histories map[string]comfyHistory
+	if err := json.NewDecoder(resp.Body).Decode(&histories); err != nil {
+		return nil, fmt.Errorf("invalid history response: %w", err)
+	}
+	if history, ok := histories[promptID]; ok {
+		return &history, nil
+	}
+	return nil, nil
+}
+
+func (s *ComfyUIService) fetchImage(ctx context.Context, image comfyImage) ([]byte, string, error) {
+	query := url.Values{}
+	query.Set("filename", image.Filename)
+	query.Set("subfolder", image.Subfolder)
+	query.Set("type", image.Type)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.host+"/view?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("request creation failed: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("network error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := readLimited(resp.Body, maxImageBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+	}
+	return body, http.DetectContentType(body), nil
+}
+
+// dequeue drops a prompt that is no longer wanted from ComfyUI's queue. A
+// prompt already running is left to finish, interrupting it could stop
+// another client's prompt instead.
+func (s *ComfyUIService) dequeue(promptID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), comfyCallTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]any{"delete": []string{promptID}})
+	req, err := http.NewRequestWithContext(ctx, "POST", s.host+"/queue", bytes.NewBuffer(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("Failed to remove ComfyUI prompt %s from the queue: %v", promptID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// lastMessage is the last status message of a failed prompt, which holds
+// the exception.
+func lastMessage(history *comfyHistory) string {
+	if len(history.Status.Messages) == 0 {
+		return "no details"
+	}
+	return string(history.Status.Messages[len(history.Status.Messages)-1])
+}
@@ -0,0 +1,255 @@
+package gen_image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+)
+
+// fakeComfyUI answers the ComfyUI API for one prompt. The prompt is listed
+// in the history after pending polls, with outputs, and /view serves image.
+type fakeComfyUI struct {
+	pending int
+	outputs map[string]any
+	status  map[string]any
+	image   []byte
+
+	mu       sync.Mutex
+	workflow map[string]any
+	polls    int
+	viewed   string
+	deleted  []string
+}
+
+const fakePromptID = "prompt-1"
+
+func (f *fakeComfyUI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/prompt":
+		var body struct {
+			Prompt   map[string]any `json:"prompt"`
+			ClientID string         `json:"client_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.workflow = body.Prompt
+		_ = json.NewEncoder(w).Encode(map[string]any{"prompt_id": fakePromptID})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/history/"+fakePromptID:
+		f.polls++
+		if f.polls <= f.pending {
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			fakePromptID: map[string]any{"outputs": f.outputs, "status": f.status},
+		})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/view":
+		f.viewed = r.URL.RawQuery
+		_, _ = w.Write(f.image)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/queue":
+		var body struct {
+			Delete []string `json:"delete"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.deleted = append(f.deleted, body.Delete...)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newComfyUI returns a service initialized against fake.
+func newComfyUI(t *testing.T, fake *fakeComfyUI) *ComfyUIService {
+	t.Helper()
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	useConfig(t, config.Config{
+		IMAGE_HOST:      srv.URL,
+		IMAGE_MODEL:     "sdxl.safetensors",
+		IMAGE_TIMEOUT:   10,
+		IMAGE_MAX_SIDE:  1024,
+		IMAGE_MAX_STEPS: 30,
+	})
+	s := &ComfyUIService{}
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return s
+}
+
+func savedImage(filename string) map[string]any {
+	return map[string]any{"9": map[string]any{"images": []any{
+		map[string]any{"filename": filename, "subfolder": "books", "type": "output"},
+	}}}
+}
+
+func completed() map[string]any {
+	return map[string]any{"status_str": "success", "completed": true}
+}
+
+func nodeInputs(t *testing.T, workflow map[string]any, node string) map[string]any {
+	t.Helper()
+	n, _ := workflow[node].(map[string]any)
+	inputs, ok := n["inputs"].(map[string]any)
+	if !ok {
+		t.Fatalf("workflow has no node %s: %v", node, workflow)
+	}
+	return inputs
+}
+
+func TestComfyUIGenerateImage(t *testing.T) {
+	image := testPNG(t)
+	fake := &fakeComfyUI{pending: 1, outputs: savedImage("bookture_00001_.png"), status: completed(), image: image}
+	s := newComfyUI(t, fake)
+
+	result, err := s.GenerateImage(context.Background(), ImageRequest{
+		Prompt:         "a lighthouse at dusk",
+		NegativePrompt: "blurry",
+		Width:          2048,
+		Height:         1536,
+		Steps:          80,
+		Seed:           42,
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage: %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.polls != 2 {
+		t.Errorf("history polled %d times, want 2", fake.polls)
+	}
+	if !strings.Contains(fake.viewed, "filename=bookture_00001_.png") || !strings.Contains(fake.viewed, "subfolder=books") {
+		t.Errorf("viewed %q", fake.viewed)
+	}
+	if result.MIME != "image/png" || len(result.Bytes) != len(image) || result.Seed != 42 {
+		t.Errorf("result is %s, %d bytes, seed %d", result.MIME, len(result.Bytes), result.Seed)
+	}
+	if result.Metadata["prompt_id"] != fakePromptID || result.Metadata["model"] != "sdxl.safetensors" {
+		t.Errorf("metadata = %v", result.Metadata)
+	}
+
+	// Placeholders are filled with the limited request, numbers as numbers
+	sampler := nodeInputs(t, fake.workflow, "3")
+	if sampler["seed"] != 42.0 || sampler["steps"] != 30.0 || sampler["cfg"] != 7.0 {
+		t.Errorf("sampler inputs = %v", sampler)
+	}
+	latent := nodeInputs(t, fake.workflow, "5")
+	if latent["width"] != 1024.0 || latent["height"] != 768.0 {
+		t.Errorf("latent inputs = %v", latent)
+	}
+	if got := nodeInputs(t, fake.workflow, "4")["ckpt_name"]; got != "sdxl.safetensors" {
+		t.Errorf("ckpt_name = %v", got)
+	}
+	if got := nodeInputs(t, fake.workflow, "6")["text"]; got != "a lighthouse at dusk" {
+		t.Errorf("prompt = %v", got)
+	}
+	if got := nodeInputs(t, fake.workflow, "7")["text"]; got != "blurry" {
+		t.Errorf("negative prompt = %v", got)
+	}
+}
+
+func TestFillWorkflow(t *testing.T) {
+	workflow := map[string]any{
+		"1": map[string]any{"inputs": map[string]any{
+			"seed":   "{{seed}}",
+			"prefix": "scene-{{seed}}-{{width}}",
+			"text":   "{{prompt}}",
+			"other":  "{{unknown}}",
+			"links":  []any{"{{model}}", 1.0},
+		}},
+	}
+	values := map[string]any{"seed": int64(7), "width": 512, "prompt": "a fox", "model": "sd15"}
+
+	filled := fillWorkflow(workflow, values).(map[string]any)
+	inputs := filled["1"].(map[string]any)["inputs"].(map[string]any)
+
+	if inputs["seed"] != int64(7) {
+		t.Errorf("seed = %#v, want the number", inputs["seed"])
+	}
+	if inputs["prefix"] != "scene-7-512" {
+		t.Errorf("prefix = %v", inputs["prefix"])
+	}
+	if inputs["text"] != "a fox" || inputs["other"] != "{{unknown}}" {
+		t.Errorf("text = %v, other = %v", inputs["text"], inputs["other"])
+	}
+	if links := inputs["links"].([]any); links[0] != "sd15" || links[1] != 1.0 {
+		t.Errorf("links = %v", links)
+	}
+
+	// The workflow itself is left as it was
+	if workflow["1"].(map[string]any)["inputs"].(map[string]any)["seed"] != "{{seed}}" {
+		t.Error("fillWorkflow changed its input")
+	}
+}
+
+func TestComfyUIErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fake *fakeComfyUI
+		want string
+	}{
+		{"workflow failed", &fakeComfyUI{
+			status: map[string]any{"status_str": "error", "messages": []any{[]any{"execution_error", "out of memory"}}},
+		}, "out of memory"},
+		{"no saved image", &fakeComfyUI{outputs: map[string]any{}, status: completed()}, "saved no image"},
+		{"too large", &fakeComfyUI{outputs: savedImage("big.png"), status: completed(), image: make([]byte, 2048)}, "larger than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMaxImageBytes(t, 1024)
+			s := newComfyUI(t, tt.fake)
+
+			_, err := s.GenerateImage(context.Background(), ImageRequest{Prompt: "a fox"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestComfyUITimeout(t *testing.T) {
+	fake := &fakeComfyUI{pending: 1 << 30}
+	s := newComfyUI(t, fake)
+	s.timeout = 50 * time.Millisecond
+
+	_, err := s.GenerateImage(context.Background(), ImageRequest{Prompt: "a fox"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the deadline", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.deleted) != 1 || fake.deleted[0] != fakePromptID {
+		t.Errorf("deleted from the queue: %v, want %s", fake.deleted, fakePromptID)
+	}
+}
+
+func TestComfyUIInitNeedsPromptPlaceholder(t *testing.T) {
+	path := t.TempDir() + "/workflow.json"
+	if err := os.WriteFile(path, []byte(`{"1":{"inputs":{"text":"a fixed prompt"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, config.Config{IMAGE_WORKFLOW: path})
+
+	err := (&ComfyUIService{}).Init()
+	if err == nil || !strings.Contains(err.Error(), "{{prompt}}") {
+		t.Fatalf("error = %v, want the missing placeholder", err)
+	}
+}
@@ -1,5 +1,48 @@
 package gen_image
 
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// placeholderSize is used for a side the request leaves open.
+	placeholderSize = 512
+	// maxPlaceholderSide keeps a bad request from allocating a huge image.
+	maxPlaceholderSide = 4096
+	// captionChars of the prompt are shown when the scene has no caption.
+	captionChars = 120
+)
+
+// moodHues gives moods a recognisable color, matched by keyword. Other
+// moods get a hue from their hash.
+var moodHues = []struct {
+	keywords  []string
+	hue       float64
+	lightness float64
+}{
+	{[]string{"tense", "danger", "angry", "violent", "urgent"}, 0, 0.45},
+	{[]string{"dark", "grim", "ominous", "sinister", "dread"}, 340, 0.25},
+	{[]string{"sad", "melanchol", "somber", "grief", "lonely"}, 220, 0.35},
+	{[]string{"mysterious", "eerie", "strange", "magical"}, 275, 0.35},
+	{[]string{"peaceful", "calm", "serene", "quiet", "gentle"}, 195, 0.55},
+	{[]string{"joyful", "happy", "hopeful", "triumph", "warm"}, 45, 0.55},
+	{[]string{"romantic", "tender", "loving", "intimate"}, 330, 0.55},
+}
+
+// DummyImageService draws placeholders instead of calling a model: a
+// gradient in a color taken from the mood and the hash of the prompt and
+// seed, with the caption, or the start of the prompt, written on it. The
+// same request always gives the same PNG.
 type DummyImageService struct {
 }
 
@@ -11,6 +54,190 @@ func (s *DummyImageService) HealthCheck() error {
 	return nil
 }
 
-func (s *DummyImageService) GenerateImage(prompt string) (string, error) {
-	return "", nil
+func (s *DummyImageService) GenerateImage(ctx context.Context, req ImageRequest) (*ImageResult, error) {
+	width, height := req.Width, req.Height
+	if width <= 0 {
+		width = placeholderSize
+	}
+	if height <= 0 {
+		height = placeholderSize
+	}
+	width, height = min(width, maxPlaceholderSide), min(height, maxPlaceholderSide)
+
+	caption := req.Caption
+	if caption == "" {
+		caption = excerpt(req.Prompt, captionChars)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	base := placeholderColor(req.Prompt, req.Seed, req.Mood)
+	drawGradient(img, base)
+	drawCaption(img, caption)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode placeholder: %w", err)
+	}
+
+	return &ImageResult{
+		Bytes:    buf.Bytes(),
+		MIME:     "image/png",
+		Seed:     req.Seed,
+		Metadata: map[string]any{"placeholder": true},
+	}, nil
+}
+
+// placeholderColor picks the mood's hue and moves it by up to 20 degrees
+// with the hash of prompt and seed, so scenes of one mood differ but stay
+// alike, and a new seed gives a new variant.
+func placeholderColor(prompt string, seed int64, mood string) color.RGBA {
+	h := fnv.New64a()
+	h.Write([]byte(prompt))
+	h.Write(binary.LittleEndian.AppendUint64(nil, uint64(seed)))
+	sum := h.Sum64()
+
+	hue, lightness := -1.0, 0.45
+	lower := strings.ToLower(mood)
+	for _, m := range moodHues {
+		for _, keyword := range m.keywords {
+			if strings.Contains(lower, keyword) {
+				hue, lightness = m.hue, m.lightness
+			}
+		}
+		if hue >= 0 {
+			break
+		}
+	}
+	if hue < 0 {
+		moodHash := fnv.New32a()
+		moodHash.Write([]byte(lower))
+		hue = float64(moodHash.Sum32() % 360)
+	}
+
+	hue = math.Mod(hue+float64(sum%41)-20+360, 360)
+	lightness += (float64((sum>>8)%11) - 5) / 100
+	return hslToRGB(hue, 0.55, lightness)
+}
+
+func hslToRGB(hue, saturation, lightness float64) color.RGBA {
+	c := (1 - math.Abs(2*lightness-1)) * saturation
+	x := c * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := lightness - c/2
+
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g, b = c, x, 0
+	case hue < 120:
+		r, g, b = x, c, 0
+	case hue < 180:
+		r, g, b = 0, c, x
+	case hue < 240:
+		r, g, b = 0, x, c
+	case hue < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
+}
+
+// drawGradient fills img from base at the top to a third of it at the
+// bottom, where the caption goes.
+func drawGradient(img *image.RGBA, base color.RGBA) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		f := 1 - float64(y)/float64(bounds.Dy())*2/3
+		row := color.RGBA{uint8(float64(base.R) * f), uint8(float64(base.G) * f), uint8(float64(base.B) * f), 255}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.SetRGBA(x, y, row)
+		}
+	}
+}
+
+// drawCaption writes text in white across the bottom of img, wrapped to its
+// width and cut to the lines that fit the lower half.
+func drawCaption(img *image.RGBA, text string) {
+	bounds := img.Bounds()
+	// Glyphs are 5x7 with a pixel of spacing, scaled to about 40 per line
+	scale := max(bounds.Dx()/(40*6), 1)
+	margin := 4 * scale
+	perLine := (bounds.Dx() - 2*margin) / (6 * scale)
+	maxLines := (bounds.Dy() / 2) / (9 * scale)
+	if perLine < 1 || maxLines < 1 {
+		return
+	}
+
+	lines := wrap(strings.ToUpper(text), perLine)
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+
+	white := color.RGBA{255, 255, 255, 255}
+	top := bounds.Max.Y - margin - len(lines)*9*scale
+	for i, line := range lines {
+		for j, r := range line {
+			glyph, ok := glyphs[r]
+			if !ok {
+				glyph = glyphs['?']
+			}
+			x0 := bounds.Min.X + margin + j*6*scale
+			y0 := top + i*9*scale
+			for row, bits := range glyph {
+				for col := 0; col < 5; col++ {
+					if bits&(1<<(4-col)) == 0 {
+						continue
+					}
+					for dy := 0; dy < scale; dy++ {
+						for dx := 0; dx < scale; dx++ {
+							img.SetRGBA(x0+col*scale+dx, y0+row*scale+dy, white)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// wrap breaks text into lines of at most width runes, between words where
+// it can.
+func wrap(text string, width int) []string {
+	var lines []string
+	var line []rune
+	for _, word := range strings.FieldsFunc(text, unicode.IsSpace) {
+		runes := []rune(word)
+		for len(runes) > width {
+			if len(line) > 0 {
+				lines = append(lines, string(line))
+				line = nil
+			}
+			lines = append(lines, string(runes[:width]))
+			runes = runes[width:]
+		}
+		if len(line) > 0 && len(line)+1+len(runes) > width {
+			lines = append(lines, string(line))
+			line = nil
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, runes...)
+	}
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// excerpt is the start of text up to n runes, cut between words.
+func excerpt(text string, n int) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "..."
 }
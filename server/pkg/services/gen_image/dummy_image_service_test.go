@@ -0,0 +1,85 @@
+package gen_image
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"testing"
+)
+
+func TestDummyImageIsDeterministic(t *testing.T) {
+	base := ImageRequest{Prompt: "A lighthouse in a storm", Mood: "tense", Seed: 42, Width: 320, Height: 200}
+	withSeed := base
+	withSeed.Seed = 43
+	withPrompt := base
+	withPrompt.Prompt = "A lighthouse at dawn"
+	withMood := base
+	withMood.Mood = "peaceful"
+	withCaption := base
+	withCaption.Caption = "The keeper waits"
+
+	svc := &DummyImageService{}
+	generate := func(req ImageRequest) []byte {
+		t.Helper()
+		res, err := svc.GenerateImage(context.Background(), req)
+		if err != nil {
+			t.Fatalf("GenerateImage(%+v): %v", req, err)
+		}
+		if res.MIME != "image/png" || res.Seed != req.Seed {
+			t.Errorf("result MIME %s, seed %d", res.MIME, res.Seed)
+		}
+		return res.Bytes
+	}
+
+	want := generate(base)
+	if got := generate(base); !bytes.Equal(got, want) {
+		t.Error("the same request gave different bytes")
+	}
+
+	tests := []struct {
+		name string
+		req  ImageRequest
+	}{
+		{"seed", withSeed},
+		{"prompt", withPrompt},
+		{"mood", withMood},
+		{"caption", withCaption},
+	}
+	for _, tt := range tests {
+		if bytes.Equal(generate(tt.req), want) {
+			t.Errorf("a different %s gave the same bytes", tt.name)
+		}
+	}
+}
+
+func TestDummyImageSize(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{"requested", 320, 200, 320, 200},
+		{"tiny", 8, 3, 8, 3},
+		{"unset", 0, 0, placeholderSize, placeholderSize},
+		{"unset height", 640, 0, 640, placeholderSize},
+		{"negative", -5, 100, placeholderSize, 100},
+		{"too large", maxPlaceholderSide + 1000, 16, maxPlaceholderSide, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &DummyImageService{}
+			res, err := svc.GenerateImage(context.Background(), ImageRequest{Prompt: "A quiet harbour", Width: tt.width, Height: tt.height})
+			if err != nil {
+				t.Fatalf("GenerateImage: %v", err)
+			}
+
+			img, err := png.Decode(bytes.NewReader(res.Bytes))
+			if err != nil {
+				t.Fatalf("output is not a PNG: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.wantWidth || b.Dy() != tt.wantHeight {
+				t.Errorf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package gen_image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Fixtures are stored one JSON file per request, named by the SHA-256 of
+// the request, the same way as the LLM fixtures. A request for a random
+// seed replays the seed the recorded image got.
+type imageFixture struct {
+	Request  ImageRequest   `json:"request"`
+	Image    []byte         `json:"image"` // base64 in the file
+	MIME     string         `json:"mime"`
+	Seed     int64          `json:"seed"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+func fixturePath(dir string, req ImageRequest) string {
+	// Fields are encoded in declaration order, so this is stable
+	key, _ := json.Marshal(req)
+	hash := sha256.Sum256(key)
+	return filepath.Join(dir, hex.EncodeToString(hash[:])+".json")
+}
+
+// RecordingImageService passes calls on and writes every image it gets to
+// dir. Failed calls are not recorded.
+type RecordingImageService struct {
+	next ImageService
+	dir  string
+}
+
+func NewRecordingImageService(next ImageService, dir string) *RecordingImageService {
+	return &RecordingImageService{next: next, dir: dir}
+}
+
+func (s *RecordingImageService) Init() error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+
+	fmt.Printf("Gen Image Service recording fixtures to %s\n", s.dir)
+	return s.next.Init()
+}
+
+func (s *RecordingImageService) HealthCheck() error {
+	return s.next.HealthCheck()
+}
+
+func (s *RecordingImageService) GenerateImage(ctx context.Context, req ImageRequest) (*ImageResult, error) {
+	result, err := s.next.GenerateImage(ctx, req)
+	if err != nil {
+		return result, err
+	}
+
+	data, err := json.MarshalIndent(imageFixture{
+		Request:  req,
+		Image:    result.Bytes,
+		MIME:     result.MIME,
+		Seed:     result.Seed,
+		Metadata: result.Metadata,
+	}, "", "  ")
+	if err == nil {
+		path := fixturePath(s.dir, req)
+		if err = os.WriteFile(path+".tmp", data, 0o644); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to record image fixture: %v", err)
+	}
+	return result, nil
+}
+
+// ReplayImageService answers from the fixtures in dir and never calls a
+// provider. A request that was not recorded fails.
+type ReplayImageService struct {
+	dir string
+}
+
+func NewReplayImageService(dir string) *ReplayImageService {
+	return &ReplayImageService{dir: dir}
+}
+
+func (s *ReplayImageService) Init() error {
+	if _, err := os.Stat(s.dir); err != nil {
+		return fmt.Errorf("fixture directory not found: %w", err)
+	}
+
+	fmt.Printf("Gen Image Service initialized (Replay, Fixtures: %s)\n", s.dir)
+	return nil
+}
+
+func (s *ReplayImageService) HealthCheck() error {
+	return nil
+}
+
+func (s *ReplayImageService) GenerateImage(ctx context.Context, req ImageRequest) (*ImageResult, error) {
+	path := fixturePath(s.dir, req)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no recorded image for request %s", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var fixture imageFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("fixture %s is corrupt: %w", filepath.Base(path), err)
+	}
+	return &ImageResult{
+		Bytes:    fixture.Image,
+		MIME:     fixture.MIME,
+		Seed:     fixture.Seed,
+		Metadata: fixture.Metadata,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package gen_image
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+// fakeImages returns bytes derived from the request and picks seed 1234
+// when none is asked for.
+type fakeImages struct {
+	calls int
+}
+
+func (f *fakeImages) Init() error        { return nil }
+func (f *fakeImages) HealthCheck() error { return nil }
+
+func (f *fakeImages) GenerateImage(ctx context.Context, req ImageRequest) (*ImageResult, error) {
+	f.calls++
+	seed := req.Seed
+	if seed == 0 {
+		seed = 1234
+	}
+	hash := sha256.Sum256([]byte(req.Prompt + req.NegativePrompt))
+	return &ImageResult{
+		Bytes:    append([]byte("\x89PNG"), hash[:]...),
+		MIME:     "image/png",
+		Seed:     seed,
+		Metadata: map[string]any{"model": "fake", "steps": float64(req.Steps)},
+	}, nil
+}
+
+func TestRecordAndReplayImages(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ImageRequest
+	}{
+		{"prompt only", ImageRequest{Prompt: "a lighthouse at dusk"}},
+		{"full request", ImageRequest{Prompt: "a fox", NegativePrompt: "blurry", Width: 768, Height: 512, Seed: 42, Steps: 20, Guidance: 6.5}},
+		{"scene details", ImageRequest{Prompt: "a storm", Mood: "tense", Caption: "Chapter 3"}},
+	}
+
+	dir := t.TempDir()
+	provider := &fakeImages{}
+	recorder := NewRecordingImageService(provider, dir)
+	if err := recorder.Init(); err != nil {
+		t.Fatalf("recording Init: %v", err)
+	}
+
+	recorded := make([]*ImageResult, len(tests))
+	for i, tt := range tests {
+		result, err := recorder.GenerateImage(context.Background(), tt.req)
+		if err != nil {
+			t.Fatalf("%s: recording GenerateImage: %v", tt.name, err)
+		}
+		recorded[i] = result
+	}
+
+	replay := NewReplayImageService(dir)
+	if err := replay.Init(); err != nil {
+		t.Fatalf("replay Init: %v", err)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := replay.GenerateImage(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("replay GenerateImage: %v", err)
+			}
+			want := recorded[i]
+			if !bytes.Equal(result.Bytes, want.Bytes) || result.MIME != want.MIME {
+				t.Errorf("replayed %d bytes of %s, recorded %d bytes of %s", len(result.Bytes), result.MIME, len(want.Bytes), want.MIME)
+			}
+			// A request for a random seed replays the one the image got
+			if result.Seed != want.Seed {
+				t.Errorf("replayed seed %d, recorded %d", result.Seed, want.Seed)
+			}
+			if !reflect.DeepEqual(result.Metadata, want.Metadata) {
+				t.Errorf("replayed metadata %v, recorded %v", result.Metadata, want.Metadata)
+			}
+
+			// Any change to the request misses
+			changed := tt.req
+			changed.Prompt += "!"
+			if _, err := replay.GenerateImage(context.Background(), changed); err == nil {
+				t.Error("a changed prompt was answered from the fixtures")
+			}
+			changed = tt.req
+			changed.Seed++
+			if _, err := replay.GenerateImage(context.Background(), changed); err == nil {
+				t.Error("a changed seed was answered from the fixtures")
+			}
+		})
+	}
+
+	if provider.calls != len(tests) {
+		t.Errorf("provider called %d times, want %d: replay must not call it", provider.calls, len(tests))
+	}
+}
@@ -3,7 +3,6 @@ package gen_image
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +13,7 @@ import (
 	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
 	"golang.org/x/time/rate"
 )
 
@@ -42,28 +42,47 @@ func (s *HuggingFaceDiffusersService) HealthCheck() error {
 	return nil
 }
 
-func (s *HuggingFaceDiffusersService) GenerateImage(prompt string) (string, error) {
-	if err := s.limiter.Wait(context.Background()); err != nil {
-		return "", fmt.Errorf("rate limiter error: %w", err)
+// hfParameters are the text-to-image parameters of the Inference API.
+type hfParameters struct {
+	NegativePrompt    string  `json:"negative_prompt,omitempty"`
+	Width             int     `json:"width,omitempty"`
+	Height            int     `json:"height,omitempty"`
+	NumInferenceSteps int     `json:"num_inference_steps,omitempty"`
+	GuidanceScale     float64 `json:"guidance_scale,omitempty"`
+	Seed              int64   `json:"seed"`
+}
+
+func (s *HuggingFaceDiffusersService) GenerateImage(ctx context.Context, imgReq ImageRequest) (*ImageResult, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("rate limiter error: %w", err)
 	}
 
 	maxRetries := 5
+	seed := randomSeed(imgReq)
 
 	for i := 0; i < maxRetries; i++ {
 		// Prepare request payload with 'wait_for_model' to assist the backoff
 		payload := map[string]interface{}{
-			"inputs":  prompt,
+			"inputs": imgReq.Prompt,
+			"parameters": hfParameters{
+				NegativePrompt:    imgReq.NegativePrompt,
+				Width:             imgReq.Width,
+				Height:            imgReq.Height,
+				NumInferenceSteps: imgReq.Steps,
+				GuidanceScale:     imgReq.Guidance,
+				Seed:              seed,
+			},
 			"options": map[string]bool{"wait_for_model": true},
 		}
 
 		requestBody, err := json.Marshal(payload)
 		if err != nil {
-			return "", fmt.Errorf("marshal failed: %w", err)
+			return nil, fmt.Errorf("marshal failed: %w", err)
 		}
 
-		req, err := http.NewRequest("POST", s.apiUrl, bytes.NewBuffer(requestBody))
+		req, err := http.NewRequestWithContext(ctx, "POST", s.apiUrl, bytes.NewBuffer(requestBody))
 		if err != nil {
-			return "", fmt.Errorf("request creation failed: %w", err)
+			return nil, fmt.Errorf("request creation failed: %w", err)
 		}
 
 		req.Header.Set("Authorization", "Bearer "+s.apiKey)
@@ -71,23 +90,28 @@ func (s *HuggingFaceDiffusersService) GenerateImage(prompt string) (string, erro
 
 		resp, err := s.client.Do(req)
 		if err != nil {
-			return "", fmt.Errorf("network error: %w", err)
+			return nil, fmt.Errorf("network error: %w", err)
 		}
 
 		// Immediate read and close to prevent resource leaks in loop
 		body, err := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if err != nil {
-			return "", fmt.Errorf("read response failed: %w", err)
+			return nil, fmt.Errorf("read response failed: %w", err)
 		}
 
 		// Handle Success
 		if resp.StatusCode == http.StatusOK {
 			// Verification: If content-type is JSON, HF sent an error message instead of an image
 			if resp.Header.Get("Content-Type") == "application/json" {
-				return "", fmt.Errorf("API returned JSON instead of image: %s", string(body))
+				return nil, fmt.Errorf("API returned JSON instead of image: %s", string(body))
 			}
-			return base64.StdEncoding.EncodeToString(body), nil
+			return &ImageResult{
+				Bytes:    body,
+				MIME:     resp.Header.Get("Content-Type"),
+				Seed:     seed,
+				Metadata: map[string]any{"model": config.AppConfig.IMAGE_MODEL},
+			}, nil
 		}
 
 		// Handle Retriable Errors (503 Model Loading or 429 Rate Limit)
@@ -95,21 +119,25 @@ func (s *HuggingFaceDiffusersService) GenerateImage(prompt string) (string, erro
 			// HF sends: RateLimit: "...;r=0;t=23"
 			if rl := resp.Header.Get("RateLimit"); rl != "" {
 				if reset := parseResetSeconds(rl); reset > 0 {
-					time.Sleep(time.Duration(reset) * time.Second)
+					if err := utils.SleepCtx(ctx, time.Duration(reset)*time.Second); err != nil {
+						return nil, err
+					}
 					continue
 				}
 			}
 
 			// Fallback
-			time.Sleep(time.Duration(math.Pow(2, float64(i+1))) * time.Second)
+			if err := utils.SleepCtx(ctx, time.Duration(math.Pow(2, float64(i+1)))*time.Second); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
 		// Handle Non-retriable Errors
-		return "", fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
 	}
 
-	return "", fmt.Errorf("failed after %d retries", maxRetries)
+	return nil, fmt.Errorf("failed after %d retries", maxRetries)
 }
 
 func parseResetSeconds(header string) int {
@@ -1,23 +1,148 @@
 package gen_image
 
-import "github.com/Mahaveer86619/bookture/server/pkg/config"
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+)
 
 type ImageService interface {
 	Init() error
 	HealthCheck() error
-	GenerateImage(prompt string) (string, error)
+	GenerateImage(ctx context.Context, req ImageRequest) (*ImageResult, error)
+}
+
+// ImageRequest describes one image. Zero values leave a setting to the
+// provider, except Seed, where 0 asks for a random one.
+type ImageRequest struct {
+	Prompt         string  `json:"prompt"`
+	NegativePrompt string  `json:"negative_prompt,omitempty"`
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	Seed           int64   `json:"seed,omitempty"`
+	Steps          int     `json:"steps,omitempty"`
+	Guidance       float64 `json:"guidance,omitempty"` // classifier free guidance scale
+
+	// About the scene, for providers that draw placeholders
+	Mood    string `json:"mood,omitempty"`
+	Caption string `json:"caption,omitempty"`
+}
+
+// ImageResult is a generated image. Seed is the one actually used, so the
+// image can be made again; 0 when the provider does not report it.
+type ImageResult struct {
+	Bytes    []byte
+	MIME     string
+	Seed     int64
+	Metadata map[string]any // provider specific, e.g. the model
+}
+
+// BookDefaults is the request every image of a book starts from, taken from
+// the IMAGE_* settings. With IMAGE_SEED set each book gets a seed of its own.
+func BookDefaults(bookID uint) ImageRequest {
+	cfg := config.AppConfig
+
+	req := ImageRequest{
+		NegativePrompt: cfg.IMAGE_NEGATIVE_PROMPT,
+		Width:          cfg.IMAGE_WIDTH / 8 * 8,
+		Height:         cfg.IMAGE_HEIGHT / 8 * 8,
+		Steps:          cfg.IMAGE_STEPS,
+		Guidance:       cfg.IMAGE_GUIDANCE,
+	}
+	if cfg.IMAGE_SEED != 0 {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%d/%d", cfg.IMAGE_SEED, bookID)
+		req.Seed = int64(h.Sum32())
+	}
+	return req
+}
+
+// CharacterSeed is the fixed seed of the scenes led by a character, so seed
+// honouring providers draw the character alike. It follows IMAGE_SEED, so
+// changing that gives every character a new look.
+func CharacterSeed(characterID uint) int64 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d/character/%d", config.AppConfig.IMAGE_SEED, characterID)
+	return int64(h.Sum32()) + 1
+}
+
+// randomSeed picks a seed for a request that asked for a random one, so the
+// result can still report it.
+func randomSeed(req ImageRequest) int64 {
+	if req.Seed != 0 {
+		return req.Seed
+	}
+	return rand.Int63n(math.MaxUint32) + 1
 }
 
+// NewImageService returns the IMAGE_PROVIDER. replay answers from the
+// fixtures in FIXTURES_PATH, and record:<provider> records the images of
+// that provider to them.
 func NewImageService() ImageService {
 	cfg := config.AppConfig
+	fixtures := filepath.Join(cfg.FIXTURES_PATH, "image")
+
+	if cfg.IMAGE_PROVIDER == "replay" {
+		return NewReplayImageService(fixtures)
+	}
+	if provider, ok := strings.CutPrefix(cfg.IMAGE_PROVIDER, "record:"); ok {
+		return NewRecordingImageService(newProvider(provider), fixtures)
+	}
+	return newProvider(cfg.IMAGE_PROVIDER)
+}
 
-	switch cfg.IMAGE_PROVIDER {
+func newProvider(provider string) ImageService {
+	switch provider {
 	case "dummy":
 		return &DummyImageService{}
 	case "hugging-face":
 		return &HuggingFaceDiffusersService{}
+	case "a1111":
+		return &A1111Service{}
+	case "comfyui":
+		return &ComfyUIService{}
 	// Case "nano-banana": return &NanoBananaService{...}
 	default:
 		return &DummyImageService{}
 	}
 }
+
+// limitRequest keeps a request for a self-hosted GPU within IMAGE_MAX_SIDE
+// and IMAGE_MAX_STEPS. Oversized images are scaled down, keeping their
+// aspect ratio, to multiples of 8 as Stable Diffusion needs.
+func limitRequest(req ImageRequest) ImageRequest {
+	cfg := config.AppConfig
+
+	if side := max(req.Width, req.Height); cfg.IMAGE_MAX_SIDE > 0 && side > cfg.IMAGE_MAX_SIDE {
+		scale := float64(cfg.IMAGE_MAX_SIDE) / float64(side)
+		req.Width = int(float64(req.Width)*scale) / 8 * 8
+		req.Height = int(float64(req.Height)*scale) / 8 * 8
+	}
+	if cfg.IMAGE_MAX_STEPS > 0 {
+		req.Steps = min(req.Steps, cfg.IMAGE_MAX_STEPS)
+	}
+	return req
+}
+
+// maxImageBytes bounds what is read from a self-hosted provider for one
+// image, so a misbehaving server cannot exhaust memory.
+var maxImageBytes int64 = 32 << 20
+
+// readLimited reads a response body, failing when it is over limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("response is larger than %d bytes", limit)
+	}
+	return body, nil
+}
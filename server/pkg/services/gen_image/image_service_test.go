@@ -0,0 +1,66 @@
+package gen_image
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+)
+
+// useConfig sets the image settings for one test.
+func useConfig(t *testing.T, cfg config.Config) {
+	t.Helper()
+	saved := config.AppConfig
+	config.AppConfig = cfg
+	t.Cleanup(func() { config.AppConfig = saved })
+}
+
+// useMaxImageBytes lowers the response limit for one test.
+func useMaxImageBytes(t *testing.T, limit int64) {
+	t.Helper()
+	saved := maxImageBytes
+	maxImageBytes = limit
+	t.Cleanup(func() { maxImageBytes = saved })
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLimitRequest(t *testing.T) {
+	useConfig(t, config.Config{IMAGE_MAX_SIDE: 1024, IMAGE_MAX_STEPS: 30})
+
+	tests := []struct {
+		name string
+		req  ImageRequest
+		want ImageRequest
+	}{
+		{"within limits", ImageRequest{Width: 768, Height: 512, Steps: 20}, ImageRequest{Width: 768, Height: 512, Steps: 20}},
+		{"scaled down", ImageRequest{Width: 2048, Height: 1536, Steps: 80}, ImageRequest{Width: 1024, Height: 768, Steps: 30}},
+		{"multiple of 8", ImageRequest{Width: 1500, Height: 1000}, ImageRequest{Width: 1024, Height: 680}},
+		{"provider defaults", ImageRequest{}, ImageRequest{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := limitRequest(tt.req); got != tt.want {
+				t.Errorf("limitRequest(%+v) = %+v, want %+v", tt.req, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	if body, err := readLimited(bytes.NewReader(make([]byte, 10)), 10); err != nil || len(body) != 10 {
+		t.Errorf("at the limit: %d bytes, %v", len(body), err)
+	}
+	if _, err := readLimited(bytes.NewReader(make([]byte, 11)), 10); err == nil {
+		t.Error("over the limit: no error")
+	}
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// JobStore is the pipeline_jobs table used when PROCESSING_MODE=external:
+// the API server inserts jobs and cmd/worker processes claim them.
+type JobStore struct {
+	db *gorm.DB
+}
+
+func NewJobStore() *JobStore {
+	return &JobStore{
+		db: db.GetBooktureDB().DB,
+	}
+}
+
+// activeStatuses are the states in which a job still has work to do.
+var activeStatuses = []string{enums.TaskQueued.ToString(), enums.TaskRunning.ToString()}
+
+// activeJobIndex is the unique index allowing one active job per task.
+const activeJobIndex = "idx_pipeline_jobs_active_task"
+
+// Enqueue inserts a queued job. It returns ErrTaskActive when the task has a
+// queued or running job, which the database enforces, so concurrent callers
+// cannot both insert one.
+func (js *JobStore) Enqueue(taskID, kind string, volumeID uint, opts JobOptions) error {
+	job := models.PipelineJob{
+		TaskID:       taskID,
+		Kind:         kind,
+		VolumeID:     volumeID,
+		UserID:       opts.UserID,
+		JobType:      opts.Type.ToString(),
+		Priority:     opts.Priority.ToString(),
+		PriorityRank: opts.Priority.Rank(),
+		Status:       enums.TaskQueued.ToString(),
+	}
+	err := js.db.Create(&job).Error
+	if isUniqueViolation(err, activeJobIndex) {
+		return ErrTaskActive
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a row that
+// duplicates another in the unique index named index.
+func isUniqueViolation(err error, index string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == index
+}
+
+// latest returns the most recent job for a task ID.
+func (js *JobStore) latest(taskID string) (*models.PipelineJob, error) {
+	var job models.PipelineJob
+	if err := js.db.Where("task_id = ?", taskID).Order("id DESC").First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (js *JobStore) IsActive(taskID string) bool {
+	var count int64
+	js.db.Model(&models.PipelineJob{}).
+		Where("task_id = ? AND status IN ?", taskID, activeStatuses).
+		Count(&count)
+	return count > 0
+}
+
+func (js *JobStore) Progress(taskID string) (TaskState, error) {
+	job, err := js.latest(taskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return TaskState{}, ErrTaskNotFound
+		}
+		return TaskState{}, err
+	}
+	return TaskState{Progress: job.Progress, Status: enums.TaskStatus(job.Status)}, nil
+}
+
+// RequestCancel cancels a pending job outright and flags a running one; its
+// worker notices on the next heartbeat.
+func (js *JobStore) RequestCancel(taskID string) error {
+	job, err := js.latest(taskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTaskNotFound
+		}
+		return err
+	}
+
+	switch enums.TaskStatus(job.Status) {
+	case enums.TaskQueued:
+		return js.Finish(job.ID, enums.TaskCancelled, nil)
+	case enums.TaskRunning:
+		return js.db.Model(job).Update("cancel_requested", true).Error
+	default:
+		return ErrTaskFinished
+	}
+}
+
+// Reorder changes the priority of a waiting job. Workers claim by priority,
+// then fairness, then age, so there is no position to move a job to.
+func (js *JobStore) Reorder(taskID string, priority enums.JobPriority) error {
+	job, err := js.latest(taskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTaskNotFound
+		}
+		return err
+	}
+
+	switch enums.TaskStatus(job.Status) {
+	case enums.TaskQueued:
+	case enums.TaskRunning:
+		return ErrTaskRunning
+	default:
+		return ErrTaskFinished
+	}
+
+	res := js.db.Model(job).Where("status = ?", enums.TaskQueued.ToString()).
+		Updates(map[string]interface{}{"priority": priority.ToString(), "priority_rank": priority.Rank()})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// Claimed in the meantime
+		return ErrTaskRunning
+	}
+	return nil
+}
+
+// Claim locks the next job for workerID. Jobs whose worker stopped sending
+// heartbeats for staleAfter are claimed again. Ordering mirrors
+// ProcessingService: priority first, then users with the fewest running jobs.
+// Returns nil when there is nothing to do.
+func (js *JobStore) Claim(ctx context.Context, workerID string, staleAfter time.Duration, skipTypes []enums.JobType) (*models.PipelineJob, error) {
+	skip := []string{""}
+	for _, t := range skipTypes {
+		skip = append(skip, t.ToString())
+	}
+
+	var jobs []models.PipelineJob
+	err := js.db.WithContext(ctx).Raw(`
+		UPDATE pipeline_jobs
+		SET status = @running, worker_id = @worker, claimed_at = NOW(), heartbeat_at = NOW(),
+			attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT j.id FROM pipeline_jobs j
+			WHERE j.deleted_at IS NULL
+				AND j.cancel_requested = FALSE
+				AND j.job_type NOT IN @skip
+				AND (j.status = @queued OR (j.status = @running AND j.heartbeat_at < @stale))
+			ORDER BY j.priority_rank DESC,
+				(SELECT COUNT(*) FROM pipeline_jobs r
+					WHERE r.user_id = j.user_id AND r.status = @running AND r.deleted_at IS NULL) ASC,
+				j.id ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		map[string]interface{}{
+			"running": enums.TaskRunning.ToString(),
+			"queued":  enums.TaskQueued.ToString(),
+			"worker":  workerID,
+			"skip":    skip,
+			"stale":   time.Now().Add(-staleAfter),
+		}).Scan(&jobs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return &jobs[0], nil
+}
+
+// Heartbeat records liveness and progress and reports whether a cancel was
+// requested for the job.
+func (js *JobStore) Heartbeat(ctx context.Context, id uint, progress int) (bool, error) {
+	now := time.Now()
+	err := js.db.WithContext(ctx).Model(&models.PipelineJob{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"heartbeat_at": &now, "progress": progress}).Error
+	if err != nil {
+		return false, err
+	}
+
+	var job models.PipelineJob
+	if err := js.db.WithContext(ctx).Select("cancel_requested").First(&job, id).Error; err != nil {
+		return false, err
+	}
+	return job.CancelRequested, nil
+}
+
+func (js *JobStore) Finish(id uint, status enums.TaskStatus, jobErr error) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":      status.ToString(),
+		"finished_at": &now,
+	}
+	switch status {
+	case enums.TaskCompleted:
+		updates["progress"] = 100
+	case enums.TaskError:
+		updates["progress"] = -1
+	}
+	if jobErr != nil {
+		updates["error"] = jobErr.Error()
+	}
+
+	return js.db.Model(&models.PipelineJob{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// Release hands a claimed job back to the queue, e.g. when its worker drains.
+func (js *JobStore) Release(id uint) error {
+	return js.db.Model(&models.PipelineJob{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":       enums.TaskQueued.ToString(),
+			"worker_id":    "",
+			"claimed_at":   nil,
+			"heartbeat_at": nil,
+		}).Error
+}
+
+// Snapshot describes the jobs claimed by workers and those waiting, in
+// priority order, for the admin API. Workers counts the workers with a live
+// job; limits and pauses belong to each worker's own settings.
+func (js *JobStore) Snapshot() (views.QueueView, error) {
+	var jobs []models.PipelineJob
+	err := js.db.Where("status IN ?", activeStatuses).
+		Order("priority_rank DESC, id ASC").
+		Find(&jobs).Error
+	if err != nil {
+		return views.QueueView{}, err
+	}
+
+	view := views.QueueView{
+		Limits:  map[string]int{},
+		Running: []views.QueuedJobView{},
+		Queued:  []views.QueuedJobView{},
+	}
+	workers := make(map[string]bool)
+	for _, job := range jobs {
+		v := views.QueuedJobView{
+			TaskID:     job.TaskID,
+			UserID:     utils.MaskID(job.UserID),
+			JobType:    job.JobType,
+			Priority:   job.Priority,
+			Status:     job.Status,
+			Progress:   job.Progress,
+			EnqueuedAt: job.CreatedAt,
+			StartedAt:  job.ClaimedAt,
+		}
+		if job.Status == enums.TaskRunning.ToString() {
+			workers[job.WorkerID] = true
+			view.Running = append(view.Running, v)
+			continue
+		}
+		position := len(view.Queued)
+		v.Position = &position
+		view.Queued = append(view.Queued, v)
+	}
+	view.Workers = len(workers)
+	return view, nil
+}
+
+// Follow turns the job row into a progress stream by polling it. Events are
+// not persisted, so there is nothing to replay in this mode.
+func (js *JobStore) Follow(taskID string, interval time.Duration) ([]views.TaskEvent, <-chan views.TaskEvent, func(), bool) {
+	if _, err := js.latest(taskID); err != nil {
+		return nil, nil, func() {}, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan views.TaskEvent, 16)
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var id int64
+		var last TaskState
+		for {
+			state, err := js.Progress(taskID)
+			if errors.Is(err, ErrTaskNotFound) {
+				return
+			}
+			if err == nil && (state != last || id == 0) {
+				last = state
+				id++
+
+				event := views.TaskEvent{
+					ID:       id,
+					TaskID:   taskID,
+					Type:     enums.EventProgress.ToString(),
+					Progress: state.Progress,
+					Time:     time.Now(),
+				}
+				switch state.Status {
+				case enums.TaskCompleted:
+					event.Type = enums.EventCompleted.ToString()
+				case enums.TaskError:
+					event.Type = enums.EventError.ToString()
+				case enums.TaskCancelled:
+					event.Type = enums.EventCancelled.ToString()
+				}
+
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+				if state.Status.IsFinal() {
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil, events, cancel, true
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db/dbtest"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"active job index", &pgconn.PgError{Code: "23505", ConstraintName: activeJobIndex}, true},
+		{"wrapped", fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23505", ConstraintName: activeJobIndex}), true},
+		{"other index", &pgconn.PgError{Code: "23505", ConstraintName: "pipeline_jobs_pkey"}, false},
+		{"other error", &pgconn.PgError{Code: "23503", ConstraintName: activeJobIndex}, false},
+		{"not postgres", errors.New("duplicate key"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := isUniqueViolation(tt.err, activeJobIndex); got != tt.want {
+			t.Errorf("%s: isUniqueViolation = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnqueueOneActiveJobPerTask(t *testing.T) {
+	dbtest.Open(t)
+	js := NewJobStore()
+	opts := JobOptions{UserID: 1, Type: enums.JobTypeParse, Priority: enums.PriorityNormal}
+
+	// Callers racing to queue the same task, as the API and the reconciler may
+	const callers = 8
+	errs := make(chan error, callers)
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- js.Enqueue("parse-1", "parse", 1, opts)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	queued := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			queued++
+		case !errors.Is(err, ErrTaskActive):
+			t.Errorf("Enqueue: %v, want ErrTaskActive", err)
+		}
+	}
+	if queued != 1 {
+		t.Fatalf("%d jobs queued for one task, want 1", queued)
+	}
+
+	// Once the job is done the task can be queued again
+	job, err := js.latest("parse-1")
+	if err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	if err := js.Finish(job.ID, enums.TaskCompleted, nil); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if err := js.Enqueue("parse-1", "parse", 1, opts); err != nil {
+		t.Errorf("Enqueue after the job finished: %v", err)
+	}
+	if err := js.Enqueue("parse-2", "parse", 2, opts); err != nil {
+		t.Errorf("Enqueue of another task: %v", err)
+	}
+}
@@ -4,19 +4,24 @@ import (
 	"errors"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
 	"github.com/Mahaveer86619/bookture/server/pkg/errz"
 	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/events"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
 	"github.com/Mahaveer86619/bookture/server/pkg/views"
 	"gorm.io/gorm"
 )
 
 type LibraryService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	emitter events.Emitter
 }
 
-func NewLibraryService() *LibraryService {
+func NewLibraryService(emitter events.Emitter) *LibraryService {
 	return &LibraryService{
-		db: db.GetBooktureDB().DB,
+		db:      db.GetBooktureDB().DB,
+		emitter: emitter,
 	}
 }
 
@@ -87,5 +92,6 @@ func (s *LibraryService) DeleteLibrary(id uint, userID uint) error {
 		return errz.New(errz.NotFound, "Library not found or access denied", errors.New("no rows affected"))
 	}
 
+	s.emitter.Emit(userID, enums.WebhookLibraryDeleted, views.LibraryEventData{LibraryID: utils.MaskID(id)})
 	return nil
 }
@@ -0,0 +1,221 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type bookKey struct{}
+
+// WithBook marks LLM calls made with ctx as belonging to a book, so their
+// cached replies can be invalidated together.
+func WithBook(ctx context.Context, bookID uint) context.Context {
+	return context.WithValue(ctx, bookKey{}, bookID)
+}
+
+func bookFromContext(ctx context.Context) uint {
+	bookID, _ := ctx.Value(bookKey{}).(uint)
+	return bookID
+}
+
+type checkKey struct{}
+
+// WithCheck has the cache test replies with check, as well as the schema,
+// before storing or serving them, so a reply the caller rejects is never
+// handed back to it.
+func WithCheck(ctx context.Context, check func(string) []string) context.Context {
+	if check == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, checkKey{}, check)
+}
+
+type freshKey struct{}
+
+// WithoutCachedReply makes calls with ctx skip the cache lookup, for retries
+// and repairs that need a new reply. A valid reply is still stored.
+func WithoutCachedReply(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshKey{}, true)
+}
+
+// primaryRouter is implemented by services that route a task's calls down
+// a chain of providers.
+type primaryRouter interface {
+	Primary(task Task) ProviderSpec
+}
+
+// CachedLLMService stores replies in Postgres so identical requests, such as
+// re-parsing an unchanged chapter, do not spend provider quota. Only replies
+// that pass Validate and the caller's check, see WithCheck, are stored, and
+// only when the task's primary provider gave them: a fallback's reply would
+// otherwise be served in place of the primary's long after it recovered.
+type CachedLLMService struct {
+	next     LLMService
+	db       *gorm.DB
+	provider string
+	model    string
+	ttl      time.Duration
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func NewCachedLLMService(next LLMService, ttl time.Duration) *CachedLLMService {
+	return &CachedLLMService{
+		next:     next,
+		db:       db.GetBooktureDB().DB,
+		provider: config.AppConfig.LLM_PROVIDER,
+		model:    config.AppConfig.LLM_MODEL,
+		ttl:      ttl,
+	}
+}
+
+func (s *CachedLLMService) Init() error {
+	return s.next.Init()
+}
+
+func (s *CachedLLMService) HealthCheck() error {
+	return s.next.HealthCheck()
+}
+
+func (s *CachedLLMService) Limits(task Task) ModelInfo {
+	return LimitsOf(s.next, task)
+}
+
+func (s *CachedLLMService) CountTokens(ctx context.Context, text string) (int, error) {
+	if counter, ok := s.next.(TokenCounter); ok {
+		return counter.CountTokens(ctx, text)
+	}
+	return 0, errNoTokenizer
+}
+
+func (s *CachedLLMService) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *Schema) (string, error) {
+	primary := s.primary(taskFromContext(ctx))
+	key := s.key(primary, sysPrompt, userPrompt, schema)
+
+	var entry models.LLMCacheEntry
+	if fresh, _ := ctx.Value(freshKey{}).(bool); !fresh {
+		err := s.db.WithContext(ctx).Where("key = ? AND expires_at > ?", key, time.Now()).First(&entry).Error
+		switch {
+		case err == nil && !acceptable(ctx, entry.Response, schema):
+			// Stored before the caller's check rejected such replies
+			s.db.Delete(&entry)
+		case err == nil:
+			s.hits.Add(1)
+			s.db.Model(&entry).UpdateColumn("hits", gorm.Expr("hits + 1"))
+			recordCall(ctx, entry.Provider, entry.Model, true)
+			return entry.Response, nil
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			log.Printf("LLM cache lookup failed: %v", err)
+		}
+	}
+	s.misses.Add(1)
+
+	callCtx, info := WithCallInfo(ctx)
+	resp, err := s.next.GenerateJSON(callCtx, sysPrompt, userPrompt, schema)
+	if outer := callInfoFrom(ctx); outer != nil {
+		*outer = *info
+	}
+	if err != nil {
+		return resp, err
+	}
+	if info.String() != primary || !acceptable(ctx, resp, schema) {
+		return resp, nil
+	}
+
+	now := time.Now()
+	entry = models.LLMCacheEntry{
+		Key:       key,
+		Provider:  info.Provider,
+		Model:     info.Model,
+		BookID:    bookFromContext(ctx),
+		Response:  resp,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"provider", "model", "book_id", "response", "hits", "created_at", "expires_at"}),
+	}).Create(&entry).Error
+	if err != nil {
+		log.Printf("LLM cache store failed: %v", err)
+	}
+
+	return resp, nil
+}
+
+// acceptable reports whether a reply passes the schema and the check of
+// ctx, if any.
+func acceptable(ctx context.Context, resp string, schema *Schema) bool {
+	reply, problems := Validate(resp, schema)
+	if len(problems) > 0 {
+		return false
+	}
+	if check, ok := ctx.Value(checkKey{}).(func(string) []string); ok {
+		return len(check(reply)) == 0
+	}
+	return true
+}
+
+// primary returns the provider:model meant to answer task, the configured
+// one when the wrapped service does not route.
+func (s *CachedLLMService) primary(task Task) string {
+	if router, ok := s.next.(primaryRouter); ok {
+		return router.Primary(task).String()
+	}
+	return s.provider + ":" + s.model
+}
+
+func (s *CachedLLMService) key(primary, sysPrompt, userPrompt string, schema *Schema) string {
+	var schemaJSON []byte
+	if schema != nil {
+		// Map keys are sorted by encoding/json, so this is stable
+		schemaJSON, _ = json.Marshal(schema.JSONSchema())
+	}
+	schemaHash := sha256.Sum256(schemaJSON)
+
+	h := sha256.New()
+	for _, part := range []string{primary, sysPrompt, userPrompt, hex.EncodeToString(schemaHash[:])} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// InvalidateBook drops the cached replies of a book and returns how many
+// were removed.
+func (s *CachedLLMService) InvalidateBook(bookID uint) (int64, error) {
+	res := s.db.Where("book_id = ?", bookID).Delete(&models.LLMCacheEntry{})
+	return res.RowsAffected, res.Error
+}
+
+// Stats returns the hit and miss counts since start and the stored entries.
+func (s *CachedLLMService) Stats() (views.LLMCacheStatsView, error) {
+	hits, misses := s.hits.Load(), s.misses.Load()
+	stats := views.LLMCacheStatsView{
+		Hits:       hits,
+		Misses:     misses,
+		TTLSeconds: int64(s.ttl.Seconds()),
+	}
+	if total := hits + misses; total > 0 {
+		stats.HitRate = float64(hits) / float64(total)
+	}
+
+	err := s.db.Model(&models.LLMCacheEntry{}).
+		Select("COUNT(*) AS entries, COUNT(*) FILTER (WHERE expires_at <= ?) AS expired, COALESCE(SUM(hits), 0) AS stored_hits", time.Now()).
+		Scan(&stats).Error
+	return stats, err
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db/dbtest"
+)
+
+// testRouter routes TaskDefault down services, in order, without starting
+// them.
+func testRouter(t *testing.T, services ...*OpenAIService) *Router {
+	t.Helper()
+
+	specs := make([]ProviderSpec, len(services))
+	for i, svc := range services {
+		specs[i] = ProviderSpec{Provider: "openai", Model: svc.model, Host: svc.baseURL}
+	}
+	r := NewRouter(map[Task][]ProviderSpec{TaskDefault: specs}, time.Second)
+	for i, svc := range services {
+		r.routes[specs[i].String()+"@"+specs[i].Host].svc = svc
+	}
+	return r
+}
+
+// flakyOpenAI answers with content while up, and with a 503 otherwise.
+func flakyOpenAI(t *testing.T, model, content string, up *atomic.Bool, calls *atomic.Int32) *OpenAIService {
+	t.Helper()
+
+	svc := fakeOpenAI(t, func(w http.ResponseWriter, body map[string]any) {
+		calls.Add(1)
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		reply(content)(w, body)
+	})
+	svc.model = model
+	return svc
+}
+
+func TestCacheSkipsFallbackReplies(t *testing.T) {
+	dbtest.Open(t)
+
+	var primaryUp, fallbackUp atomic.Bool
+	var primaryCalls, fallbackCalls atomic.Int32
+	fallbackUp.Store(true)
+	router := testRouter(t,
+		flakyOpenAI(t, "primary", `{"from":"primary"}`, &primaryUp, &primaryCalls),
+		flakyOpenAI(t, "fallback", `{"from":"fallback"}`, &fallbackUp, &fallbackCalls),
+	)
+	cache := NewCachedLLMService(router, time.Hour)
+
+	generate := func() string {
+		t.Helper()
+		resp, err := cache.GenerateJSON(context.Background(), "system", "user", nil)
+		if err != nil {
+			t.Fatalf("GenerateJSON: %v", err)
+		}
+		return resp
+	}
+
+	// The primary is down, so the fallback answers, but is not cached
+	if got := generate(); got != `{"from":"fallback"}` {
+		t.Fatalf("reply while the primary is down = %s", got)
+	}
+
+	primaryUp.Store(true)
+	if got := generate(); got != `{"from":"primary"}` {
+		t.Fatalf("reply once the primary recovered = %s, want the primary's", got)
+	}
+
+	// The primary's reply is cached
+	if got := generate(); got != `{"from":"primary"}` {
+		t.Fatalf("cached reply = %s", got)
+	}
+	if n := primaryCalls.Load(); n != 2 {
+		t.Errorf("primary called %d times, want 2", n)
+	}
+	if n := fallbackCalls.Load(); n != 1 {
+		t.Errorf("fallback called %d times, want 1", n)
+	}
+}
+
+func TestCacheKeyFollowsThePrimary(t *testing.T) {
+	var up atomic.Bool
+	var calls atomic.Int32
+	first := flakyOpenAI(t, "first", `{}`, &up, &calls)
+	second := flakyOpenAI(t, "second", `{}`, &up, &calls)
+
+	a := &CachedLLMService{next: testRouter(t, first, second)}
+	b := &CachedLLMService{next: testRouter(t, second, first)}
+
+	if got := a.primary(TaskScenes); got != "openai:first" {
+		t.Errorf("primary = %s, want openai:first", got)
+	}
+	keyA := a.key(a.primary(TaskDefault), "system", "user", nil)
+	keyB := b.key(b.primary(TaskDefault), "system", "user", nil)
+	if keyA == keyB {
+		t.Error("chains with different primaries share cache keys")
+	}
+}
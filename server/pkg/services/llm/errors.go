@@ -0,0 +1,42 @@
+package llm
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultRetryAfter is used when a 429 response carries no usable Retry-After.
+const defaultRetryAfter = 60 * time.Second
+
+// RateLimitError is returned when the provider answers 429. Callers should
+// wait RetryAfter before trying again.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited (429), retry after %v: %s", e.RetryAfter, e.Message)
+}
+
+// QuotaError is returned when a provider's daily request budget is spent.
+type QuotaError struct {
+	Provider string
+	Used     int
+	Max      int
+	ResetAt  time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("daily %s quota exceeded (%d/%d)", e.Provider, e.Used, e.Max)
+}
+
+// StatusError is a non-success HTTP status from a provider.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API error (%d): %s", e.Code, e.Message)
+}
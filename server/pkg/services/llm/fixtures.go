@@ -0,0 +1,223 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Fixtures are stored one JSON file per request, named by RequestHash, so a
+// run can be recorded once against real providers and replayed offline.
+// The provider is left out of the hash: a recording answers the same
+// requests whichever provider made it.
+
+// fixtureLimitsFile keeps the model limits seen while recording. Replay
+// needs them, and the recorded token counts, to split chapters exactly as
+// the recorded run did.
+const fixtureLimitsFile = "model_limits.json"
+
+type llmFixture struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	System   string `json:"system"`
+	User     string `json:"user"`
+	Response string `json:"response"`
+}
+
+type tokenFixture struct {
+	Tokens int `json:"tokens"`
+}
+
+func tokenFixturePath(dir, text string) string {
+	hash := sha256.Sum256([]byte(text))
+	return filepath.Join(dir, "tokens-"+hex.EncodeToString(hash[:])+".json")
+}
+
+// RequestHash identifies a request by its prompts and schema.
+func RequestHash(sysPrompt, userPrompt string, schema *Schema) string {
+	var schemaJSON []byte
+	if schema != nil {
+		// Map keys are sorted by encoding/json, so this is stable
+		schemaJSON, _ = json.Marshal(schema.JSONSchema())
+	}
+
+	h := sha256.New()
+	for _, part := range []string{sysPrompt, userPrompt, string(schemaJSON)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RecordingLLMService passes calls on and writes every reply it gets to
+// dir. Failed calls are not recorded.
+type RecordingLLMService struct {
+	next LLMService
+	dir  string
+}
+
+func NewRecordingLLMService(next LLMService, dir string) *RecordingLLMService {
+	return &RecordingLLMService{next: next, dir: dir}
+}
+
+func (s *RecordingLLMService) Init() error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+
+	limits := make(map[Task]ModelInfo)
+	for _, task := range []Task{TaskDefault, TaskMetadata, TaskScenes} {
+		limits[task] = LimitsOf(s.next, task)
+	}
+	if err := writeJSONFile(filepath.Join(s.dir, fixtureLimitsFile), limits); err != nil {
+		return fmt.Errorf("failed to write model limits: %w", err)
+	}
+
+	fmt.Printf("LLM Service recording fixtures to %s\n", s.dir)
+	return s.next.Init()
+}
+
+func (s *RecordingLLMService) HealthCheck() error {
+	return s.next.HealthCheck()
+}
+
+func (s *RecordingLLMService) Limits(task Task) ModelInfo {
+	return LimitsOf(s.next, task)
+}
+
+func (s *RecordingLLMService) CountTokens(ctx context.Context, text string) (int, error) {
+	counter, ok := s.next.(TokenCounter)
+	if !ok {
+		return 0, errNoTokenizer
+	}
+	tokens, err := counter.CountTokens(ctx, text)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := writeJSONFile(tokenFixturePath(s.dir, text), tokenFixture{Tokens: tokens}); err != nil {
+		log.Printf("Failed to record token count fixture: %v", err)
+	}
+	return tokens, nil
+}
+
+func (s *RecordingLLMService) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *Schema) (string, error) {
+	callCtx, info := WithCallInfo(ctx)
+	resp, err := s.next.GenerateJSON(callCtx, sysPrompt, userPrompt, schema)
+	if outer := callInfoFrom(ctx); outer != nil {
+		*outer = *info
+	}
+	if err != nil {
+		return resp, err
+	}
+
+	fixture := llmFixture{
+		Provider: info.Provider,
+		Model:    info.Model,
+		System:   sysPrompt,
+		User:     userPrompt,
+		Response: resp,
+	}
+	path := filepath.Join(s.dir, RequestHash(sysPrompt, userPrompt, schema)+".json")
+	if err := writeJSONFile(path, fixture); err != nil {
+		log.Printf("Failed to record LLM fixture: %v", err)
+	}
+	return resp, nil
+}
+
+// ReplayLLMService answers from the fixtures in dir and never calls a
+// provider. A request that was not recorded fails.
+type ReplayLLMService struct {
+	dir    string
+	limits map[Task]ModelInfo
+}
+
+func NewReplayLLMService(dir string) *ReplayLLMService {
+	return &ReplayLLMService{dir: dir}
+}
+
+func (s *ReplayLLMService) Init() error {
+	if _, err := os.Stat(s.dir); err != nil {
+		return fmt.Errorf("fixture directory not found: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, fixtureLimitsFile))
+	if err == nil {
+		err = json.Unmarshal(data, &s.limits)
+	}
+	if err != nil {
+		log.Printf("No recorded model limits, using the fallbacks: %v", err)
+	}
+
+	fmt.Printf("LLM Service initialized (Replay, Fixtures: %s)\n", s.dir)
+	return nil
+}
+
+func (s *ReplayLLMService) HealthCheck() error {
+	return nil
+}
+
+func (s *ReplayLLMService) Limits(task Task) ModelInfo {
+	if limits, ok := s.limits[task]; ok {
+		return limits
+	}
+	return s.limits[TaskDefault]
+}
+
+// CountTokens replays recorded counts. Without one the caller estimates, as
+// the recorded run did when its provider could not count.
+func (s *ReplayLLMService) CountTokens(ctx context.Context, text string) (int, error) {
+	data, err := os.ReadFile(tokenFixturePath(s.dir, text))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, errNoTokenizer
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var fixture tokenFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return 0, fmt.Errorf("token count fixture is corrupt: %w", err)
+	}
+	return fixture.Tokens, nil
+}
+
+func (s *ReplayLLMService) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *Schema) (string, error) {
+	hash := RequestHash(sysPrompt, userPrompt, schema)
+	data, err := os.ReadFile(filepath.Join(s.dir, hash+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("no recorded reply for request %s", hash)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var fixture llmFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return "", fmt.Errorf("fixture %s is corrupt: %w", hash, err)
+	}
+
+	recordCall(ctx, "replay", fixture.Model, false)
+	return fixture.Response, nil
+}
+
+// writeJSONFile writes through a temporary file so a crash never leaves a
+// half written fixture.
+func writeJSONFile(path string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeLLM answers every request with its prompts and counts a token per
+// word, so recorded fixtures can be told apart.
+type fakeLLM struct {
+	calls int
+}
+
+func (f *fakeLLM) Init() error        { return nil }
+func (f *fakeLLM) HealthCheck() error { return nil }
+
+func (f *fakeLLM) Limits(task Task) ModelInfo {
+	if task == TaskScenes {
+		return ModelInfo{Name: "fake:scenes", ContextWindow: 8_192, OutputLimit: 1_024}
+	}
+	return ModelInfo{Name: "fake:default", ContextWindow: 32_768, OutputLimit: 4_096, InputPrice: 0.5}
+}
+
+func (f *fakeLLM) CountTokens(ctx context.Context, text string) (int, error) {
+	return len(strings.Fields(text)), nil
+}
+
+func (f *fakeLLM) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *Schema) (string, error) {
+	f.calls++
+	recordCall(ctx, "fake", "fake-model", false)
+	return fmt.Sprintf(`{"system":%q,"user":%q,"schema":%v}`, sysPrompt, userPrompt, schema != nil), nil
+}
+
+func TestRecordAndReplayLLM(t *testing.T) {
+	type answer struct {
+		Title string `json:"title"`
+	}
+
+	tests := []struct {
+		name   string
+		system string
+		user   string
+		schema *Schema
+		text   string // counted for tokens
+	}{
+		{"free form", "Reply in JSON.", "Name the author.", nil, "one two three"},
+		{"with schema", "Reply in JSON.", "Name the book.", SchemaFor(answer{}), "a chapter of five words"},
+		{"unicode", "Réponds en JSON.", "Quel est le titre ? 📖", SchemaFor(answer{}), "çà et là"},
+	}
+
+	dir := t.TempDir()
+	provider := &fakeLLM{}
+	recorder := NewRecordingLLMService(provider, dir)
+	if err := recorder.Init(); err != nil {
+		t.Fatalf("recording Init: %v", err)
+	}
+
+	recorded := make([]string, len(tests))
+	tokens := make([]int, len(tests))
+	for i, tt := range tests {
+		resp, err := recorder.GenerateJSON(context.Background(), tt.system, tt.user, tt.schema)
+		if err != nil {
+			t.Fatalf("%s: recording GenerateJSON: %v", tt.name, err)
+		}
+		recorded[i] = resp
+		if tokens[i], err = recorder.CountTokens(context.Background(), tt.text); err != nil {
+			t.Fatalf("%s: recording CountTokens: %v", tt.name, err)
+		}
+	}
+
+	replay := NewReplayLLMService(dir)
+	if err := replay.Init(); err != nil {
+		t.Fatalf("replay Init: %v", err)
+	}
+	for _, task := range []Task{TaskDefault, TaskMetadata, TaskScenes} {
+		if got, want := replay.Limits(task), LimitsOf(provider, task); got != want {
+			t.Errorf("replayed limits of %s = %+v, want %+v", task, got, want)
+		}
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, info := WithCallInfo(context.Background())
+			resp, err := replay.GenerateJSON(ctx, tt.system, tt.user, tt.schema)
+			if err != nil {
+				t.Fatalf("replay GenerateJSON: %v", err)
+			}
+			if resp != recorded[i] {
+				t.Errorf("replayed %s, recorded %s", resp, recorded[i])
+			}
+			if info.Provider != "replay" || info.Model != "fake-model" {
+				t.Errorf("replayed call info = %s", info)
+			}
+
+			got, err := replay.CountTokens(context.Background(), tt.text)
+			if err != nil || got != tokens[i] {
+				t.Errorf("replayed token count = %d, %v, want %d", got, err, tokens[i])
+			}
+
+			// Any change to the request misses
+			if _, err := replay.GenerateJSON(context.Background(), tt.system, tt.user+" ", tt.schema); err == nil {
+				t.Error("a changed prompt was answered from the fixtures")
+			}
+			if _, err := replay.GenerateJSON(context.Background(), tt.system, tt.user, nil); tt.schema != nil && err == nil {
+				t.Error("a request without its schema was answered from the fixtures")
+			}
+		})
+	}
+
+	if _, err := replay.CountTokens(context.Background(), "never counted"); !errors.Is(err, errNoTokenizer) {
+		t.Errorf("CountTokens of unrecorded text = %v, want errNoTokenizer", err)
+	}
+	if provider.calls != len(tests) {
+		t.Errorf("provider called %d times, want %d: replay must not call it", provider.calls, len(tests))
+	}
+}
@@ -4,30 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"sync"
-	"time"
+	"net/http"
 
-	"github.com/Mahaveer86619/bookture/server/pkg/config"
-	"golang.org/x/time/rate"
 	"google.golang.org/genai"
 )
 
 type GeminiService struct {
 	client *genai.Client
+	apiKey string
 	model  string
+	quota  *Quota
+}
 
-	rpmLimiter *rate.Limiter
-
-	mu        sync.Mutex
-	dailyUsed int
-	dailyMax  int
-	resetAt   time.Time
+func NewGeminiService(spec ProviderSpec) *GeminiService {
+	return &GeminiService{apiKey: spec.Key, model: spec.Model}
 }
 
 func (s *GeminiService) Init() error {
 	ctx := context.Background()
-	apiKey := config.AppConfig.LLM_KEY
+	apiKey := s.apiKey
 	if apiKey == "" {
 		return errors.New("LLM_KEY is not set in configuration")
 	}
@@ -40,32 +35,10 @@ func (s *GeminiService) Init() error {
 	}
 
 	s.client = client
-	s.model = config.AppConfig.LLM_MODEL
-
-	// Configure limits per model
-	switch s.model {
-	case "gemini-2.5-flash-lite":
-		s.rpmLimiter = rate.NewLimiter(rate.Every(time.Minute/15), 3)
-		s.dailyMax = 1000
-
-	case "gemini-2.5-flash":
-		s.rpmLimiter = rate.NewLimiter(rate.Every(time.Minute/10), 2)
-		s.dailyMax = 200
-
-	case "gemini-2.5-pro":
-		s.rpmLimiter = rate.NewLimiter(rate.Every(time.Minute/5), 1)
-		s.dailyMax = 50
-
-	case "gemini-2.0-flash":
-		s.rpmLimiter = rate.NewLimiter(rate.Every(time.Minute/10), 2)
-		s.dailyMax = 0 // unlimited daily
 
-	default:
-		return fmt.Errorf("unsupported Gemini model: %s", s.model)
-	}
-
-	// Daily reset at midnight UTC
-	s.resetAt = time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	// Limits per model come from LLM_MODEL_LIMITS and are counted in the
+	// database, shared with every other instance
+	s.quota = NewQuota("gemini-api", s.model)
 
 	fmt.Printf("LLM Service initialized (Gemini, Model: %s)\n", s.model)
 	return nil
@@ -79,46 +52,18 @@ func (s *GeminiService) HealthCheck() error {
 	return nil
 }
 
-func (s *GeminiService) checkDailyLimit() error {
-	if s.dailyMax == 0 {
-		return nil
-	}
-
-	now := time.Now().UTC()
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if now.After(s.resetAt) {
-		s.dailyUsed = 0
-		s.resetAt = now.Truncate(24 * time.Hour).Add(24 * time.Hour)
-	}
-
-	if s.dailyUsed >= s.dailyMax {
-		return fmt.Errorf("daily Gemini quota exceeded (%d/%d)", s.dailyUsed, s.dailyMax)
-	}
-
-	s.dailyUsed++
-	return nil
-}
-
 func (s *GeminiService) GenerateJSON(
 	ctx context.Context,
 	sysPrompt, userPrompt string,
-	schema any,
+	schema *Schema,
 ) (string, error) {
 
 	if s.client == nil {
 		return "", errors.New("gemini client is not initialized")
 	}
 
-	// RPM limiter
-	if err := s.rpmLimiter.Wait(ctx); err != nil {
-		return "", fmt.Errorf("gemini RPM limit exceeded: %w", err)
-	}
-
-	// RPD limiter
-	if err := s.checkDailyLimit(); err != nil {
+	// Waits for a free slot in the minute, fails when the day is used up
+	if err := s.quota.Reserve(ctx); err != nil {
 		return "", err
 	}
 
@@ -132,21 +77,34 @@ func (s *GeminiService) GenerateJSON(
 		},
 	}
 
-	// Apply Schema if provided (Must be of type *genai.Schema)
 	if schema != nil {
-		if googleSchema, ok := schema.(*genai.Schema); ok {
-			genConfig.ResponseSchema = googleSchema
-		} else {
-			log.Println("Warning: Invalid schema type passed to GeminiService, expected *genai.Schema")
-		}
+		genConfig.ResponseSchema = toGenaiSchema(schema)
 	}
 
 	// Execute Request
 	resp, err := s.client.Models.GenerateContent(ctx, s.model, genai.Text(userPrompt), genConfig)
 	if err != nil {
+		var apiErr genai.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests {
+			return "", &RateLimitError{RetryAfter: defaultRetryAfter, Message: apiErr.Message}
+		}
+		if errors.As(err, &apiErr) && apiErr.Code >= http.StatusInternalServerError {
+			return "", &StatusError{Code: apiErr.Code, Message: apiErr.Message}
+		}
 		return "", fmt.Errorf("gemini generation error: %w", err)
 	}
 
+	if resp != nil && resp.UsageMetadata != nil {
+		prompt, output := int(resp.UsageMetadata.PromptTokenCount), int(resp.UsageMetadata.CandidatesTokenCount)
+		s.quota.AddTokens(prompt, output)
+
+		var finishReason string
+		if len(resp.Candidates) > 0 {
+			finishReason = string(resp.Candidates[0].FinishReason)
+		}
+		recordUsage(ctx, prompt, output, finishReason)
+	}
+
 	// Extract Text
 	if resp != nil && len(resp.Candidates) > 0 {
 		for _, part := range resp.Candidates[0].Content.Parts {
@@ -158,3 +116,54 @@ func (s *GeminiService) GenerateJSON(
 
 	return "", errors.New("empty response from llm")
 }
+
+// CountTokens uses the countTokens endpoint, which does not count against
+// the generation quota.
+func (s *GeminiService) CountTokens(ctx context.Context, text string) (int, error) {
+	if s.client == nil {
+		return 0, errors.New("gemini client is not initialized")
+	}
+
+	resp, err := s.client.Models.CountTokens(ctx, s.model, genai.Text(text), nil)
+	if err != nil {
+		return 0, fmt.Errorf("gemini token count error: %w", err)
+	}
+	return int(resp.TotalTokens), nil
+}
+
+func toGenaiSchema(s *Schema) *genai.Schema {
+	out := &genai.Schema{
+		Description:      s.Description,
+		Enum:             s.Enum,
+		Minimum:          s.Minimum,
+		Maximum:          s.Maximum,
+		Required:         s.Required,
+		PropertyOrdering: s.Order,
+	}
+
+	switch s.Type {
+	case TypeObject:
+		out.Type = genai.TypeObject
+	case TypeArray:
+		out.Type = genai.TypeArray
+	case TypeInteger:
+		out.Type = genai.TypeInteger
+	case TypeNumber:
+		out.Type = genai.TypeNumber
+	case TypeBoolean:
+		out.Type = genai.TypeBoolean
+	default:
+		out.Type = genai.TypeString
+	}
+
+	if s.Items != nil {
+		out.Items = toGenaiSchema(s.Items)
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			out.Properties[name] = toGenaiSchema(prop)
+		}
+	}
+	return out
+}
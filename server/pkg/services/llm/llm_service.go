@@ -2,6 +2,11 @@ package llm
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/config"
 )
@@ -9,19 +14,165 @@ import (
 type LLMService interface {
 	Init() error
 	HealthCheck() error
-	GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema any) (string, error)
+	// GenerateJSON asks for a JSON reply. schema may be nil for free-form JSON.
+	GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *Schema) (string, error)
 }
 
+// ProviderSpec selects one provider and model. Entries of LLM_PROVIDER fall
+// back to LLM_HOST and LLM_KEY; other providers take their key from LLM_KEYS.
+type ProviderSpec struct {
+	Provider string
+	Model    string
+	Host     string
+	Key      string
+}
+
+func (p ProviderSpec) String() string {
+	return p.Provider + ":" + p.Model
+}
+
+// NewLLMService returns a Router over LLM_CHAIN, with LLM_CHAIN_METADATA and
+// LLM_CHAIN_SCENES overriding it per task. Without a chain it routes to the
+// single LLM_PROVIDER and LLM_MODEL.
+//
+// LLM_PROVIDER=replay answers from the fixtures in FIXTURES_PATH instead, and
+// record:<provider> records the replies of the router to them.
 func NewLLMService() LLMService {
 	cfg := config.AppConfig
+	fixtures := filepath.Join(cfg.FIXTURES_PATH, "llm")
+
+	provider := cfg.LLM_PROVIDER
+	if provider == "replay" {
+		return NewReplayLLMService(fixtures)
+	}
+	recording := Recording()
+	if recording {
+		_, provider, _ = strings.Cut(provider, ":")
+		if provider == "" {
+			provider = "gemini-api"
+		}
+	}
+
+	chains := make(map[Task][]ProviderSpec)
+	for task, raw := range map[Task]string{
+		TaskDefault:  cfg.LLM_CHAIN,
+		TaskMetadata: cfg.LLM_CHAIN_METADATA,
+		TaskScenes:   cfg.LLM_CHAIN_SCENES,
+	} {
+		chain, err := ParseChain(raw, provider)
+		if err != nil {
+			fmt.Printf("Warning: ignoring invalid LLM chain for %s: %v\n", task, err)
+			continue
+		}
+		if len(chain) > 0 {
+			chains[task] = chain
+		}
+	}
+
+	if _, ok := chains[TaskDefault]; !ok {
+		spec, _ := withDefaults(ProviderSpec{Provider: provider, Model: cfg.LLM_MODEL}, provider)
+		chains[TaskDefault] = []ProviderSpec{spec}
+	}
+
+	router := NewRouter(chains, time.Duration(cfg.LLM_ATTEMPT_TIMEOUT)*time.Second)
+	if recording {
+		return NewRecordingLLMService(router, fixtures)
+	}
+	return router
+}
+
+// Recording reports whether LLM_PROVIDER records fixtures. The reply cache
+// should be skipped then, as cached replies would not be recorded.
+func Recording() bool {
+	provider := config.AppConfig.LLM_PROVIDER
+	return provider == "record" || strings.HasPrefix(provider, "record:")
+}
 
-	switch cfg.LLM_PROVIDER {
+// providers are the names NewProvider knows.
+var providers = []string{"gemini-api", "ollama", "openai"}
+
+// NewProvider builds the service for a single provider.
+func NewProvider(spec ProviderSpec) (LLMService, error) {
+	switch spec.Provider {
 	case "gemini-api":
-		return &GeminiService{}
+		return NewGeminiService(spec), nil
 	case "ollama":
-		return &OllamaService{}
-	// Case "s3": return &S3Storage{...}
+		return NewOllamaService(spec), nil
+	case "openai":
+		return NewOpenAIService(spec), nil
 	default:
-		return &GeminiService{}
+		return nil, fmt.Errorf("unknown LLM provider %q, want one of %s", spec.Provider, strings.Join(providers, ", "))
+	}
+}
+
+// ParseChain reads a comma separated list of provider:model entries, each
+// optionally followed by @host, e.g.
+//
+//	gemini-api:gemini-2.5-flash,gemini-api:gemini-2.0-flash,ollama:llama3.1@http://localhost:11434
+//
+// primary is LLM_PROVIDER, the provider LLM_HOST and LLM_KEY belong to.
+func ParseChain(raw, primary string) ([]ProviderSpec, error) {
+	var chain []ProviderSpec
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		entry, host, _ := strings.Cut(entry, "@")
+		provider, model, ok := strings.Cut(entry, ":")
+		if !ok || provider == "" || model == "" {
+			return nil, fmt.Errorf("entry %q must be provider:model", entry)
+		}
+		if !slices.Contains(providers, provider) {
+			return nil, fmt.Errorf("entry %q has unknown provider %q, want one of %s", entry, provider, strings.Join(providers, ", "))
+		}
+
+		spec, err := withDefaults(ProviderSpec{Provider: provider, Model: model, Host: host}, primary)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, spec)
+	}
+	return chain, nil
+}
+
+// withDefaults fills in the host and key of spec. LLM_HOST and LLM_KEY are
+// those of primary, so only its entries fall back to them. Other providers
+// take their key from LLM_KEYS and need an @host of their own, except
+// Gemini, which has a fixed host but cannot run without a key.
+func withDefaults(spec ProviderSpec, primary string) (ProviderSpec, error) {
+	cfg := config.AppConfig
+	if spec.Provider == primary {
+		if spec.Host == "" {
+			spec.Host = cfg.LLM_HOST
+		}
+		if spec.Key == "" {
+			spec.Key = cfg.LLM_KEY
+		}
+		return spec, nil
+	}
+
+	if spec.Key == "" {
+		spec.Key = parseKeys(cfg.LLM_KEYS)[spec.Provider]
+	}
+	switch {
+	case spec.Provider == "gemini-api" && spec.Key == "":
+		return spec, fmt.Errorf("%s needs a key in LLM_KEYS, LLM_KEY is for %s", spec, primary)
+	case spec.Provider != "gemini-api" && spec.Host == "":
+		return spec, fmt.Errorf("%s needs @host, LLM_HOST is for %s", spec, primary)
+	}
+	return spec, nil
+}
+
+// parseKeys reads provider=key entries separated by commas.
+func parseKeys(raw string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		provider, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && provider != "" && key != "" {
+			keys[strings.TrimSpace(provider)] = strings.TrimSpace(key)
+		}
 	}
+	return keys
 }
@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+)
+
+func TestParseChain(t *testing.T) {
+	saved := config.AppConfig
+	config.AppConfig = config.Config{
+		LLM_KEY:  "gemini-key",
+		LLM_HOST: "http://localhost:11434",
+		LLM_KEYS: "openai = sk-test, broken",
+	}
+	t.Cleanup(func() { config.AppConfig = saved })
+
+	tests := []struct {
+		name    string
+		raw     string
+		primary string
+		want    []ProviderSpec
+		err     string
+	}{
+		{name: "empty", raw: " , ", primary: "gemini-api"},
+		{
+			name:    "primary gets the defaults",
+			raw:     "gemini-api:gemini-2.5-flash, gemini-api:gemini-2.0-flash",
+			primary: "gemini-api",
+			want: []ProviderSpec{
+				{Provider: "gemini-api", Model: "gemini-2.5-flash", Host: "http://localhost:11434", Key: "gemini-key"},
+				{Provider: "gemini-api", Model: "gemini-2.0-flash", Host: "http://localhost:11434", Key: "gemini-key"},
+			},
+		},
+		{
+			name:    "other providers do not",
+			raw:     "gemini-api:gemini-2.5-flash,ollama:llama3.1@http://gpu:11434,openai:gpt-4o-mini@https://api.openai.com/v1",
+			primary: "gemini-api",
+			want: []ProviderSpec{
+				{Provider: "gemini-api", Model: "gemini-2.5-flash", Host: "http://localhost:11434", Key: "gemini-key"},
+				{Provider: "ollama", Model: "llama3.1", Host: "http://gpu:11434"},
+				{Provider: "openai", Model: "gpt-4o-mini", Host: "https://api.openai.com/v1", Key: "sk-test"},
+			},
+		},
+		{
+			name:    "host of the primary",
+			raw:     "ollama:llama3.1,ollama:mistral@http://other:11434",
+			primary: "ollama",
+			want: []ProviderSpec{
+				{Provider: "ollama", Model: "llama3.1", Host: "http://localhost:11434", Key: "gemini-key"},
+				{Provider: "ollama", Model: "mistral", Host: "http://other:11434", Key: "gemini-key"},
+			},
+		},
+		{name: "unknown provider", raw: "gemini-api:gemini-2.5-flash,gemni-api:gemini-2.0-flash", primary: "gemini-api", err: `unknown provider "gemni-api"`},
+		{name: "no model", raw: "ollama", primary: "ollama", err: "must be provider:model"},
+		{name: "missing host", raw: "gemini-api:gemini-2.5-flash,ollama:llama3.1", primary: "gemini-api", err: "ollama:llama3.1 needs @host"},
+		{name: "missing key", raw: "ollama:llama3.1,gemini-api:gemini-2.5-flash", primary: "ollama", err: "gemini-api:gemini-2.5-flash needs a key in LLM_KEYS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChain(tt.raw, tt.primary)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseChain: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseChain() = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewProviderRejectsUnknownNames(t *testing.T) {
+	if _, err := NewProvider(ProviderSpec{Provider: "gemini", Model: "gemini-2.5-flash"}); err == nil {
+		t.Error("NewProvider accepted an unknown provider")
+	}
+	for _, name := range providers {
+		if svc, err := NewProvider(ProviderSpec{Provider: name, Model: "m"}); err != nil || svc == nil {
+			t.Errorf("NewProvider(%s) = %v, %v", name, svc, err)
+		}
+	}
+}
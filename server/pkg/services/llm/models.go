@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+)
+
+// Limits assumed for models missing from the registry. They are small on
+// purpose: a long prompt cut off by the server loses text silently.
+const (
+	fallbackContextWindow = 8192
+	fallbackOutputLimit   = 4096
+)
+
+// ModelInfo is what request budgeting knows about a model.
+type ModelInfo struct {
+	Name          string  // provider:model, set by LimitsOf
+	ContextWindow int     // prompt and reply tokens together
+	OutputLimit   int     // most tokens in one reply
+	InputPrice    float64 // USD per million prompt tokens, 0 when free or unknown
+	OutputPrice   float64 // USD per million reply tokens
+}
+
+// defaultModels are the limits and paid tier prices of known models.
+// LLM_CONTEXT_WINDOWS, LLM_OUTPUT_LIMITS and LLM_MODEL_PRICES add models or
+// override these.
+var defaultModels = map[string]ModelInfo{
+	"gemini-2.5-flash-lite": {ContextWindow: 1_048_576, OutputLimit: 65_536, InputPrice: 0.10, OutputPrice: 0.40},
+	"gemini-2.5-flash":      {ContextWindow: 1_048_576, OutputLimit: 65_536, InputPrice: 0.30, OutputPrice: 2.50},
+	"gemini-2.5-pro":        {ContextWindow: 1_048_576, OutputLimit: 65_536, InputPrice: 1.25, OutputPrice: 10.00},
+	"gemini-2.0-flash":      {ContextWindow: 1_048_576, OutputLimit: 8_192, InputPrice: 0.10, OutputPrice: 0.40},
+	"gemini-1.5-flash":      {ContextWindow: 1_048_576, OutputLimit: 8_192, InputPrice: 0.075, OutputPrice: 0.30},
+}
+
+// ContextSizer is implemented by services that know the models behind a
+// task.
+type ContextSizer interface {
+	Limits(task Task) ModelInfo
+}
+
+// LimitsOf returns the limits svc has for task, with the fallbacks filled in
+// for whatever it does not know.
+func LimitsOf(svc LLMService, task Task) ModelInfo {
+	var info ModelInfo
+	if sizer, ok := svc.(ContextSizer); ok {
+		info = sizer.Limits(task)
+	}
+	if info.ContextWindow <= 0 {
+		info.ContextWindow = fallbackContextWindow
+	}
+	if info.OutputLimit <= 0 {
+		info.OutputLimit = fallbackOutputLimit
+	}
+	return info
+}
+
+// LookupModel returns the registered limits and prices of model.
+func LookupModel(model string) ModelInfo {
+	cfg := config.AppConfig
+
+	info, ok := defaultModels[model]
+	if !ok {
+		info = ModelInfo{ContextWindow: fallbackContextWindow, OutputLimit: fallbackOutputLimit}
+	}
+	if values, ok := parseModelValues(cfg.LLM_CONTEXT_WINDOWS, "LLM_CONTEXT_WINDOWS", 1)[model]; ok && values[0] >= 1 {
+		info.ContextWindow = int(values[0])
+	}
+	if values, ok := parseModelValues(cfg.LLM_OUTPUT_LIMITS, "LLM_OUTPUT_LIMITS", 1)[model]; ok && values[0] >= 1 {
+		info.OutputLimit = int(values[0])
+	}
+	if values, ok := parseModelValues(cfg.LLM_MODEL_PRICES, "LLM_MODEL_PRICES", 2)[model]; ok {
+		info.InputPrice, info.OutputPrice = values[0], values[1]
+	}
+	return info
+}
+
+// ContextWindow returns the context window of model in tokens.
+func ContextWindow(model string) int {
+	return LookupModel(model).ContextWindow
+}
+
+// parseModelValues reads model=value entries separated by commas, with n
+// values separated by slashes, e.g. "llama3.1=32768" or "qwen2.5=0.1/0.4".
+// Bad entries are logged under setting and skipped.
+func parseModelValues(raw, setting string, n int) map[string][]float64 {
+	entries := make(map[string][]float64)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		model, rawValues, ok := strings.Cut(entry, "=")
+		parts := strings.Split(rawValues, "/")
+		values := make([]float64, 0, n)
+		for _, part := range parts {
+			value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || value < 0 {
+				ok = false
+				break
+			}
+			values = append(values, value)
+		}
+		if !ok || len(values) != n {
+			log.Printf("Invalid %s entry %q, expected model=%s", setting, entry, strings.Repeat("/value", n)[1:])
+			continue
+		}
+		entries[strings.TrimSpace(model)] = values
+	}
+	return entries
+}
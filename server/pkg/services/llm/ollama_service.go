@@ -5,24 +5,31 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
-
-	"github.com/Mahaveer86619/bookture/server/pkg/config"
 )
 
 type OllamaService struct {
 	client *http.Client
+	host   string
+	model  string
+	quota  *Quota
+}
+
+func NewOllamaService(spec ProviderSpec) *OllamaService {
+	return &OllamaService{host: spec.Host, model: spec.Model}
 }
 
 func (s *OllamaService) Init() error {
 	s.client = &http.Client{Timeout: 120 * time.Second}
-	fmt.Printf("Local LLM Service initialized (Provider: Ollama, Model: %s)\n", config.AppConfig.LLM_MODEL)
+	s.quota = NewQuota("ollama", s.model)
+	fmt.Printf("Local LLM Service initialized (Provider: Ollama, Model: %s)\n", s.model)
 	return nil
 }
 
 func (s *OllamaService) HealthCheck() error {
-	resp, err := s.client.Get(config.AppConfig.LLM_HOST + "/api/tags")
+	resp, err := s.client.Get(s.host + "/api/tags")
 	if err != nil {
 		return fmt.Errorf("ollama not reachable: %w", err)
 	}
@@ -30,19 +37,32 @@ func (s *OllamaService) HealthCheck() error {
 	return nil
 }
 
-func (s *OllamaService) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema any) (string, error) {
+func (s *OllamaService) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *Schema) (string, error) {
 	// Ollama works best when instructions are part of the prompt
 	fullPrompt := fmt.Sprintf("System: %s\n\nUser: %s", sysPrompt, userPrompt)
 
+	// Ollama takes a JSON schema as format and constrains decoding to it
+	var format any = "json"
+	if schema != nil {
+		format = schema.JSONSchema()
+	}
+
 	payload := map[string]interface{}{
-		"model":  config.AppConfig.LLM_MODEL,
+		"model":  s.model,
 		"prompt": fullPrompt,
 		"stream": false,
-		"format": "json",
+		"format": format,
+		// Ollama's default context is a few thousand tokens and it cuts
+		// longer prompts silently, so ask for the window chunking assumes
+		"options": map[string]any{"num_ctx": ContextWindow(s.model)},
+	}
+
+	if err := s.quota.Reserve(ctx); err != nil {
+		return "", err
 	}
 
 	body, _ := json.Marshal(payload)
-	req, err := http.NewRequestWithContext(ctx, "POST", config.AppConfig.LLM_HOST+"/api/generate", bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.host+"/api/generate", bytes.NewBuffer(body))
 	if err != nil {
 		return "", err
 	}
@@ -53,12 +73,54 @@ func (s *OllamaService) GenerateJSON(ctx context.Context, sysPrompt, userPrompt
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return "", &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), Message: string(msg)}
+		}
+		return "", &StatusError{Code: resp.StatusCode, Message: string(msg)}
+	}
+
 	var result struct {
-		Response string `json:"response"`
+		Response        string `json:"response"`
+		PromptEvalCount int    `json:"prompt_eval_count"`
+		EvalCount       int    `json:"eval_count"`
+		DoneReason      string `json:"done_reason"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", err
 	}
+	s.quota.AddTokens(result.PromptEvalCount, result.EvalCount)
+	recordUsage(ctx, result.PromptEvalCount, result.EvalCount, result.DoneReason)
 
 	return result.Response, nil
 }
+
+// CountTokens uses the tokenize endpoint of Ollama, which older versions do
+// not have.
+func (s *OllamaService) CountTokens(ctx context.Context, text string) (int, error) {
+	body, _ := json.Marshal(map[string]any{"model": s.model, "content": text})
+	req, err := http.NewRequestWithContext(ctx, "POST", s.host+"/api/tokenize", bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return 0, &StatusError{Code: resp.StatusCode, Message: string(msg)}
+	}
+
+	var result struct {
+		Tokens []int `json:"tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return len(result.Tokens), nil
+}
@@ -0,0 +1,197 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAIService talks to any server exposing the OpenAI chat completions
+// API, such as llama.cpp server, vLLM or LM Studio. The host is the base
+// URL including the version, e.g. http://localhost:8080/v1.
+type OpenAIService struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	model   string
+	quota   *Quota
+}
+
+func NewOpenAIService(spec ProviderSpec) *OpenAIService {
+	return &OpenAIService{
+		baseURL: strings.TrimSuffix(spec.Host, "/"),
+		apiKey:  spec.Key,
+		model:   spec.Model,
+	}
+}
+
+func (s *OpenAIService) Init() error {
+	if s.baseURL == "" {
+		return errors.New("openai compatible host is not set")
+	}
+
+	s.client = &http.Client{Timeout: 120 * time.Second}
+	s.quota = NewQuota("openai", s.model)
+
+	fmt.Printf("LLM Service initialized (OpenAI compatible, Host: %s, Model: %s)\n", s.baseURL, s.model)
+	return nil
+}
+
+func (s *OpenAIService) HealthCheck() error {
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	s.authorize(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("openai compatible server not reachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("openai compatible server returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model          string         `json:"model"`
+	Messages       []chatMessage  `json:"messages"`
+	ResponseFormat map[string]any `json:"response_format"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message      chatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (s *OpenAIService) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *Schema) (string, error) {
+	if s.client == nil {
+		return "", errors.New("openai compatible client is not initialized")
+	}
+
+	// Structured output when there is a schema, plain JSON mode otherwise
+	format := map[string]any{"type": "json_object"}
+	if schema != nil {
+		format = map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   "response",
+				"schema": schema.JSONSchema(),
+			},
+		}
+	}
+
+	body, err := json.Marshal(chatRequest{
+		Model: s.model,
+		Messages: []chatMessage{
+			{Role: "system", Content: sysPrompt},
+			{Role: "user", Content: userPrompt},
+		},
+		ResponseFormat: format,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal failed: %w", err)
+	}
+
+	if err := s.quota.Reserve(ctx); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("request creation failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.authorize(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("openai compatible request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read response failed: %w", err)
+	}
+
+	var result chatResponse
+	_ = json.Unmarshal(raw, &result)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", &RateLimitError{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Message:    errorMessage(&result, raw),
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Code: resp.StatusCode, Message: errorMessage(&result, raw)}
+	}
+
+	s.quota.AddTokens(result.Usage.PromptTokens, result.Usage.CompletionTokens)
+	if len(result.Choices) > 0 {
+		recordUsage(ctx, result.Usage.PromptTokens, result.Usage.CompletionTokens, result.Choices[0].FinishReason)
+	}
+
+	if len(result.Choices) == 0 || result.Choices[0].Message.Content == "" {
+		return "", errors.New("empty response from llm")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+// authorize adds the API key when one is set. Local servers usually run
+// without one.
+func (s *OpenAIService) authorize(req *http.Request) {
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+}
+
+func errorMessage(result *chatResponse, raw []byte) string {
+	if result.Error != nil && result.Error.Message != "" {
+		return result.Error.Message
+	}
+	return string(raw)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return defaultRetryAfter
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,200 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeOpenAI serves /chat/completions with handle, which gets the decoded
+// request body.
+func fakeOpenAI(t *testing.T, handle func(w http.ResponseWriter, body map[string]any)) *OpenAIService {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		handle(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	svc := NewOpenAIService(ProviderSpec{Host: srv.URL + "/v1/", Model: "test-model"})
+	svc.client = srv.Client()
+	return svc
+}
+
+func reply(content string) func(http.ResponseWriter, map[string]any) {
+	return func(w http.ResponseWriter, _ map[string]any) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{
+				"message":       map[string]any{"role": "assistant", "content": content},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]any{"prompt_tokens": 12, "completion_tokens": 3},
+		})
+	}
+}
+
+func TestOpenAIResponseFormat(t *testing.T) {
+	type answer struct {
+		Title string `json:"title"`
+	}
+
+	tests := []struct {
+		name   string
+		schema *Schema
+		want   string
+	}{
+		{"schema", SchemaFor(answer{}), "json_schema"},
+		{"no schema", nil, "json_object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var format map[string]any
+			svc := fakeOpenAI(t, func(w http.ResponseWriter, body map[string]any) {
+				format, _ = body["response_format"].(map[string]any)
+				reply(`{"title":"Dune"}`)(w, body)
+			})
+
+			got, err := svc.GenerateJSON(context.Background(), "system", "user", tt.schema)
+			if err != nil {
+				t.Fatalf("GenerateJSON: %v", err)
+			}
+			if got != `{"title":"Dune"}` {
+				t.Errorf("content = %q", got)
+			}
+			if format["type"] != tt.want {
+				t.Errorf("response_format type = %v, want %s", format["type"], tt.want)
+			}
+
+			jsonSchema, hasSchema := format["json_schema"].(map[string]any)
+			if hasSchema != (tt.schema != nil) {
+				t.Fatalf("json_schema sent = %v, want %v", hasSchema, tt.schema != nil)
+			}
+			if hasSchema && jsonSchema["schema"] == nil {
+				t.Error("json_schema has no schema")
+			}
+		})
+	}
+}
+
+func TestOpenAIRecordsUsage(t *testing.T) {
+	svc := fakeOpenAI(t, reply(`{}`))
+
+	ctx, info := WithCallInfo(context.Background())
+	if _, err := svc.GenerateJSON(ctx, "system", "user", nil); err != nil {
+		t.Fatalf("GenerateJSON: %v", err)
+	}
+	if info.PromptTokens != 12 || info.OutputTokens != 3 || info.FinishReason != "stop" {
+		t.Errorf("call info = %+v", info)
+	}
+}
+
+func TestOpenAIRateLimited(t *testing.T) {
+	at := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		header   string
+		min, max time.Duration
+	}{
+		{"seconds", "7", 7 * time.Second, 7 * time.Second},
+		{"http date", at, 80 * time.Second, 90 * time.Second},
+		{"missing", "", defaultRetryAfter, defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := fakeOpenAI(t, func(w http.ResponseWriter, _ map[string]any) {
+				if tt.header != "" {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte(`{"error":{"message":"slow down"}}`))
+			})
+
+			_, err := svc.GenerateJSON(context.Background(), "system", "user", nil)
+			var rateErr *RateLimitError
+			if !errors.As(err, &rateErr) {
+				t.Fatalf("error = %v, want a RateLimitError", err)
+			}
+			if rateErr.RetryAfter < tt.min || rateErr.RetryAfter > tt.max {
+				t.Errorf("RetryAfter = %v, want between %v and %v", rateErr.RetryAfter, tt.min, tt.max)
+			}
+			if rateErr.Message != "slow down" {
+				t.Errorf("Message = %q", rateErr.Message)
+			}
+		})
+	}
+}
+
+func TestOpenAIServerError(t *testing.T) {
+	svc := fakeOpenAI(t, func(w http.ResponseWriter, _ map[string]any) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("model is loading"))
+	})
+
+	_, err := svc.GenerateJSON(context.Background(), "system", "user", nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("error = %v, want a StatusError", err)
+	}
+	if statusErr.Code != http.StatusServiceUnavailable || statusErr.Message != "model is loading" {
+		t.Errorf("StatusError = %+v", statusErr)
+	}
+}
+
+func TestOpenAIEmptyResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(http.ResponseWriter, map[string]any)
+	}{
+		{"no choices", func(w http.ResponseWriter, _ map[string]any) {
+			_, _ = w.Write([]byte(`{"choices":[]}`))
+		}},
+		{"no content", reply("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := fakeOpenAI(t, tt.handle)
+
+			_, err := svc.GenerateJSON(context.Background(), "system", "user", nil)
+			if err == nil || !strings.Contains(err.Error(), "empty response") {
+				t.Fatalf("error = %v, want an empty response error", err)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", defaultRetryAfter},
+		{"30", 30 * time.Second},
+		{" 5 ", 5 * time.Second},
+		{"-1", defaultRetryAfter},
+		{"soon", defaultRetryAfter},
+		{now.Add(2 * time.Minute).Format(http.TimeFormat), 2 * time.Minute},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+const (
+	periodDay    = "day"
+	periodMinute = "minute"
+)
+
+// Limits caps requests per minute and per day. Zero means no limit.
+type Limits struct {
+	RPM int
+	RPD int
+}
+
+// defaultLimits are the free tier limits of the Gemini API. LLM_MODEL_LIMITS
+// adds models or overrides these.
+var defaultLimits = map[string]Limits{
+	"gemini-2.5-flash-lite": {RPM: 15, RPD: 1000},
+	"gemini-2.5-flash":      {RPM: 10, RPD: 200},
+	"gemini-2.5-pro":        {RPM: 5, RPD: 50},
+	"gemini-2.0-flash":      {RPM: 10, RPD: 0},
+}
+
+// unknownGeminiLimits apply to Gemini models missing from the limits, the
+// strictest of the free tier, so a new model cannot burn through the quota.
+var unknownGeminiLimits = Limits{RPM: 5, RPD: 50}
+
+// ParseLimits reads model=rpm/rpd entries separated by commas, e.g.
+// "gemini-2.5-flash=10/250,llama3.1=0/0", on top of the defaults.
+func ParseLimits(raw string) map[string]Limits {
+	limits := make(map[string]Limits, len(defaultLimits))
+	for model, l := range defaultLimits {
+		limits[model] = l
+	}
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		model, values, ok := strings.Cut(entry, "=")
+		rpmRaw, rpdRaw, ok2 := strings.Cut(values, "/")
+		rpm, err1 := strconv.Atoi(strings.TrimSpace(rpmRaw))
+		rpd, err2 := strconv.Atoi(strings.TrimSpace(rpdRaw))
+		if !ok || !ok2 || err1 != nil || err2 != nil || rpm < 0 || rpd < 0 {
+			log.Printf("Invalid LLM_MODEL_LIMITS entry %q, expected model=rpm/rpd", entry)
+			continue
+		}
+		limits[strings.TrimSpace(model)] = Limits{RPM: rpm, RPD: rpd}
+	}
+	return limits
+}
+
+// limitsFor looks up a model's limits, falling back to unknownGeminiLimits
+// for Gemini models. known reports whether the model was listed.
+func limitsFor(limits map[string]Limits, provider, model string) (l Limits, known bool) {
+	l, known = limits[model]
+	if !known && provider == "gemini-api" {
+		l = unknownGeminiLimits
+	}
+	return l, known
+}
+
+// Quota counts a provider model's usage in the database and enforces its
+// limits, so restarts and other instances see the same numbers. Days are
+// UTC days. A nil Quota counts nothing.
+type Quota struct {
+	db       *gorm.DB
+	provider string
+	model    string
+	limits   Limits
+
+	// limiter spreads this process's requests over the minute, as the
+	// counted minutes alone let a burst at the end of one minute run into
+	// another at the start of the next
+	limiter *rate.Limiter
+}
+
+func NewQuota(provider, model string) *Quota {
+	limits, known := limitsFor(ParseLimits(config.AppConfig.LLM_MODEL_LIMITS), provider, model)
+	if !known && provider == "gemini-api" {
+		log.Printf("Warning: no limits known for Gemini model %s, using %d/min and %d/day; set them in LLM_MODEL_LIMITS",
+			model, limits.RPM, limits.RPD)
+	}
+
+	q := &Quota{
+		db:       db.GetBooktureDB().DB,
+		provider: provider,
+		model:    model,
+		limits:   limits,
+	}
+	if limits.RPM > 0 {
+		q.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(limits.RPM)), max(limits.RPM/5, 1))
+	}
+	return q
+}
+
+// Reserve counts one request. It waits for a slot in the minute and then
+// returns a QuotaError when the daily limit is used up. The day is only
+// counted once the request may go out, so waiting requests that get
+// cancelled do not use it up.
+func (q *Quota) Reserve(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+
+	minute, err := q.reserveMinute(ctx)
+	if err != nil {
+		return err
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	used, ok, err := q.take(ctx, periodDay, day, q.limits.RPD)
+	if err != nil {
+		return fmt.Errorf("failed to count %s usage: %w", q.model, err)
+	}
+	if !ok {
+		// The request is not made, so it should not hold the minute's slot
+		q.giveBack(minute)
+		return &QuotaError{Provider: q.provider, Used: used, Max: q.limits.RPD, ResetAt: day.Add(24 * time.Hour)}
+	}
+	return nil
+}
+
+// reserveMinute waits until the request fits the minute limit and returns
+// the minute it was counted in, or the zero time without a limit.
+func (q *Quota) reserveMinute(ctx context.Context) (time.Time, error) {
+	if q.limits.RPM == 0 {
+		return time.Time{}, nil
+	}
+	if err := q.limiter.Wait(ctx); err != nil {
+		return time.Time{}, err
+	}
+
+	for {
+		minute := time.Now().UTC().Truncate(time.Minute)
+		_, ok, err := q.take(ctx, periodMinute, minute, q.limits.RPM)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to count %s usage: %w", q.model, err)
+		}
+		if ok {
+			return minute, nil
+		}
+
+		timer := time.NewTimer(time.Until(minute.Add(time.Minute)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Time{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// giveBack returns a request taken in minute.
+func (q *Quota) giveBack(minute time.Time) {
+	if minute.IsZero() {
+		return
+	}
+
+	err := q.db.Model(&models.LLMUsage{}).
+		Where("provider = ? AND model = ? AND period = ? AND period_start = ? AND requests > 0", q.provider, q.model, periodMinute, minute).
+		UpdateColumn("requests", gorm.Expr("requests - 1")).Error
+	if err != nil {
+		log.Printf("Failed to give back a %s request: %v", q.model, err)
+	}
+}
+
+// takeSQL adds a request to a period's row. When the row is already at the
+// limit the WHERE skips the update, and then no row is returned at all.
+const takeSQL = `
+	INSERT INTO llm_usages (provider, model, period, period_start, requests, updated_at)
+	VALUES (?, ?, ?, ?, 1, NOW())
+	ON CONFLICT (provider, model, period, period_start) DO UPDATE
+	SET requests = llm_usages.requests + 1, updated_at = NOW()
+	WHERE ? = 0 OR llm_usages.requests < ?
+	RETURNING requests`
+
+type takenRow struct{ Requests int }
+
+// take adds a request to a period unless limit is reached, in one statement
+// so concurrent processes cannot both take the last slot.
+func (q *Quota) take(ctx context.Context, period string, start time.Time, limit int) (int, bool, error) {
+	var rows []takenRow
+	err := q.db.WithContext(ctx).Raw(takeSQL, q.provider, q.model, period, start, limit, limit).Scan(&rows).Error
+	if err != nil {
+		return 0, false, err
+	}
+	used, ok := taken(rows, limit)
+	return used, ok, nil
+}
+
+// taken reads what takeSQL returned: the new count when the request was
+// taken, and none when the period is full.
+func taken(rows []takenRow, limit int) (int, bool) {
+	if len(rows) == 0 {
+		return limit, false
+	}
+	return rows[0].Requests, true
+}
+
+// AddTokens records the tokens of a finished request on today's row.
+func (q *Quota) AddTokens(prompt, output int) {
+	if q == nil || (prompt == 0 && output == 0) {
+		return
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	err := q.db.Model(&models.LLMUsage{}).
+		Where("provider = ? AND model = ? AND period = ? AND period_start = ?", q.provider, q.model, periodDay, day).
+		Updates(map[string]any{
+			"prompt_tokens": gorm.Expr("prompt_tokens + ?", prompt),
+			"output_tokens": gorm.Expr("output_tokens + ?", output),
+		}).Error
+	if err != nil {
+		log.Printf("Failed to record %s token usage: %v", q.model, err)
+	}
+}
+
+// RecordInvalidReply counts a reply of a model that failed validation.
+func RecordInvalidReply(provider, model string) {
+	addToToday(provider, model, "invalid_replies")
+}
+
+// RecordRepairedReply counts an invalid reply that a repair request fixed.
+func RecordRepairedReply(provider, model string) {
+	addToToday(provider, model, "repaired_replies")
+}
+
+// addToToday bumps a counter on the model's row for today, which Reserve
+// created when the request was made.
+func addToToday(provider, model, column string) {
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	err := db.GetBooktureDB().DB.Model(&models.LLMUsage{}).
+		Where("provider = ? AND model = ? AND period = ? AND period_start = ?", provider, model, periodDay, day).
+		UpdateColumn(column, gorm.Expr(column+" + 1")).Error
+	if err != nil {
+		log.Printf("Failed to record %s %s: %v", model, column, err)
+	}
+}
+
+// UsageReport returns today's usage of every model that has been called,
+// next to its limits.
+func UsageReport() ([]views.LLMUsageView, error) {
+	now := time.Now().UTC()
+	day := now.Truncate(24 * time.Hour)
+	minute := now.Truncate(time.Minute)
+
+	var rows []models.LLMUsage
+	err := db.GetBooktureDB().DB.
+		Where("(period = ? AND period_start = ?) OR (period = ? AND period_start = ?)", periodDay, day, periodMinute, minute).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	limits := ParseLimits(config.AppConfig.LLM_MODEL_LIMITS)
+	byModel := make(map[string]*views.LLMUsageView)
+	for _, row := range rows {
+		key := row.Provider + ":" + row.Model
+		v, ok := byModel[key]
+		if !ok {
+			l, _ := limitsFor(limits, row.Provider, row.Model)
+			v = &views.LLMUsageView{
+				Provider: row.Provider,
+				Model:    row.Model,
+				RPM:      l.RPM,
+				RPD:      l.RPD,
+				ResetAt:  day.Add(24 * time.Hour),
+			}
+			byModel[key] = v
+		}
+
+		switch row.Period {
+		case periodDay:
+			v.RequestsToday = row.Requests
+			v.PromptTokensToday = row.PromptTokens
+			v.OutputTokensToday = row.OutputTokens
+			v.InvalidToday = row.InvalidReplies
+			v.RepairedToday = row.RepairedReplies
+		case periodMinute:
+			v.RequestsThisMinute = row.Requests
+		}
+	}
+
+	result := make([]views.LLMUsageView, 0, len(byModel))
+	for _, v := range byModel {
+		if v.RPD > 0 {
+			remaining := max(v.RPD-v.RequestsToday, 0)
+			v.RemainingToday = &remaining
+		}
+		result = append(result, *v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Provider+result[i].Model < result[j].Provider+result[j].Model
+	})
+	return result, nil
+}
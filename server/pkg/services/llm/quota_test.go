@@ -0,0 +1,120 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/db/dbtest"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+)
+
+func TestLimitsFor(t *testing.T) {
+	limits := ParseLimits("gemini-2.5-flash=20/500, llama3.1=0/0, broken=5, bad=-1/3")
+
+	tests := []struct {
+		provider, model string
+		want            Limits
+		known           bool
+	}{
+		{"gemini-api", "gemini-2.5-flash", Limits{RPM: 20, RPD: 500}, true},
+		{"gemini-api", "gemini-2.5-pro", Limits{RPM: 5, RPD: 50}, true},
+		{"gemini-api", "gemini-9-ultra", unknownGeminiLimits, false},
+		{"ollama", "llama3.1", Limits{}, true},
+		{"ollama", "mistral", Limits{}, false},
+		{"openai", "broken", Limits{}, false},
+		{"openai", "bad", Limits{}, false},
+	}
+	for _, tt := range tests {
+		got, known := limitsFor(limits, tt.provider, tt.model)
+		if got != tt.want || known != tt.known {
+			t.Errorf("limitsFor(%s, %s) = %+v, %v, want %+v, %v", tt.provider, tt.model, got, known, tt.want, tt.known)
+		}
+	}
+}
+
+func TestTaken(t *testing.T) {
+	tests := []struct {
+		name  string
+		rows  []takenRow
+		limit int
+		used  int
+		ok    bool
+	}{
+		{"update refused", nil, 5, 5, false},
+		{"update refused, empty result", []takenRow{}, 5, 5, false},
+		{"taken", []takenRow{{Requests: 3}}, 5, 3, true},
+		{"last slot", []takenRow{{Requests: 5}}, 5, 5, true},
+		{"no limit", []takenRow{{Requests: 900}}, 0, 900, true},
+	}
+	for _, tt := range tests {
+		used, ok := taken(tt.rows, tt.limit)
+		if used != tt.used || ok != tt.ok {
+			t.Errorf("%s: taken() = %d, %v, want %d, %v", tt.name, used, ok, tt.used, tt.ok)
+		}
+	}
+}
+
+func TestTakeStopsAtTheLimit(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+	minute := time.Now().UTC().Truncate(time.Minute)
+
+	q := &Quota{db: db.GetBooktureDB().DB, provider: "openai", model: "test-model"}
+	for want := 1; want <= 3; want++ {
+		used, ok, err := q.take(ctx, periodMinute, minute, 3)
+		if err != nil || !ok || used != want {
+			t.Fatalf("take %d = %d, %v, %v, want %d, true", want, used, ok, err, want)
+		}
+	}
+
+	// The row is full: the upsert's WHERE rejects the update and returns
+	// no row, which must read as the limit being reached
+	used, ok, err := q.take(ctx, periodMinute, minute, 3)
+	if err != nil || ok || used != 3 {
+		t.Fatalf("take over the limit = %d, %v, %v, want 3, false", used, ok, err)
+	}
+	var row models.LLMUsage
+	if err := q.db.Where("period = ? AND period_start = ?", periodMinute, minute).First(&row).Error; err != nil {
+		t.Fatalf("load usage: %v", err)
+	}
+	if row.Requests != 3 {
+		t.Errorf("requests = %d after a refused take, want 3", row.Requests)
+	}
+
+	// Other periods and unlimited models are counted separately
+	if _, ok, _ := q.take(ctx, periodMinute, minute.Add(time.Minute), 3); !ok {
+		t.Error("the next minute is full too")
+	}
+	for range 5 {
+		if _, ok, err := q.take(ctx, periodDay, minute, 0); !ok || err != nil {
+			t.Fatalf("take without a limit = %v, %v", ok, err)
+		}
+	}
+}
+
+func TestReserveDailyLimit(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+
+	q := &Quota{db: db.GetBooktureDB().DB, provider: "openai", model: "test-model", limits: Limits{RPD: 2}}
+	for i := range 2 {
+		if err := q.Reserve(ctx); err != nil {
+			t.Fatalf("Reserve %d: %v", i+1, err)
+		}
+	}
+
+	err := q.Reserve(ctx)
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("Reserve over the daily limit = %v, want a QuotaError", err)
+	}
+	if quotaErr.Used != 2 || quotaErr.Max != 2 {
+		t.Errorf("QuotaError = %+v, want 2 of 2 used", quotaErr)
+	}
+	if want := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour); !quotaErr.ResetAt.Equal(want) {
+		t.Errorf("ResetAt = %v, want %v", quotaErr.ResetAt, want)
+	}
+}
@@ -0,0 +1,368 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Task is the kind of work an LLM call does. Each task may use its own
+// provider chain.
+type Task string
+
+const (
+	TaskDefault  Task = "default"  // Any call without a more specific chain
+	TaskMetadata Task = "metadata" // Book title, author and description
+	TaskScenes   Task = "scenes"   // Scene breakdown of a chapter
+)
+
+const (
+	// breakerThreshold is how many failures in a row open a provider's breaker.
+	breakerThreshold = 3
+	// breakerCooldown is how long an open breaker skips the provider.
+	breakerCooldown = time.Minute
+)
+
+type taskKey struct{}
+
+// WithTask selects the provider chain for calls made with ctx.
+func WithTask(ctx context.Context, task Task) context.Context {
+	return context.WithValue(ctx, taskKey{}, task)
+}
+
+func taskFromContext(ctx context.Context) Task {
+	if task, ok := ctx.Value(taskKey{}).(Task); ok {
+		return task
+	}
+	return TaskDefault
+}
+
+// CallInfo records which provider and model answered a call, and what the
+// provider reported about it.
+type CallInfo struct {
+	Provider     string
+	Model        string
+	Cached       bool
+	PromptTokens int
+	OutputTokens int
+	FinishReason string
+}
+
+func (c *CallInfo) String() string {
+	if c.Provider == "" {
+		return ""
+	}
+	return c.Provider + ":" + c.Model
+}
+
+type callInfoKey struct{}
+
+// WithCallInfo returns a context whose calls report who answered into the
+// returned CallInfo.
+func WithCallInfo(ctx context.Context) (context.Context, *CallInfo) {
+	info := &CallInfo{}
+	return context.WithValue(ctx, callInfoKey{}, info), info
+}
+
+func callInfoFrom(ctx context.Context) *CallInfo {
+	info, _ := ctx.Value(callInfoKey{}).(*CallInfo)
+	return info
+}
+
+type answerHookKey struct{}
+
+// OnAnswer has fn called with the provider and model that answered each
+// call made with ctx, and whether the answer came from the cache.
+func OnAnswer(ctx context.Context, fn func(CallInfo)) context.Context {
+	return context.WithValue(ctx, answerHookKey{}, fn)
+}
+
+func recordCall(ctx context.Context, provider, model string, cached bool) {
+	if info := callInfoFrom(ctx); info != nil {
+		info.Provider = provider
+		info.Model = model
+		info.Cached = cached
+	}
+	if fn, ok := ctx.Value(answerHookKey{}).(func(CallInfo)); ok {
+		fn(CallInfo{Provider: provider, Model: model, Cached: cached})
+	}
+}
+
+// recordUsage is called by providers with the token counts and finish
+// reason of a reply.
+func recordUsage(ctx context.Context, prompt, output int, finishReason string) {
+	if info := callInfoFrom(ctx); info != nil {
+		info.PromptTokens = prompt
+		info.OutputTokens = output
+		info.FinishReason = finishReason
+	}
+}
+
+type route struct {
+	spec    ProviderSpec
+	svc     LLMService
+	breaker breaker
+	initErr error
+}
+
+// Router sends each call down its task's provider chain, moving to the next
+// provider on quota, rate limit, 5xx, network errors and timeouts. A circuit
+// breaker skips a provider for a while after repeated failures.
+type Router struct {
+	routes         map[string]*route // One per provider:model, shared by chains
+	chains         map[Task][]*route
+	attemptTimeout time.Duration
+}
+
+func NewRouter(chains map[Task][]ProviderSpec, attemptTimeout time.Duration) *Router {
+	r := &Router{
+		routes:         make(map[string]*route),
+		chains:         make(map[Task][]*route),
+		attemptTimeout: attemptTimeout,
+	}
+
+	for task, specs := range chains {
+		for _, spec := range specs {
+			key := spec.String() + "@" + spec.Host
+			rt, ok := r.routes[key]
+			if !ok {
+				svc, err := NewProvider(spec)
+				rt = &route{spec: spec, svc: svc, initErr: err}
+				r.routes[key] = rt
+			}
+			r.chains[task] = append(r.chains[task], rt)
+		}
+	}
+	return r
+}
+
+// chain returns task's providers, or the default chain when task has none.
+func (r *Router) chain(task Task) []*route {
+	if chain, ok := r.chains[task]; ok {
+		return chain
+	}
+	return r.chains[TaskDefault]
+}
+
+// Primary returns the provider that answers task's calls while it is
+// healthy. Answers from the ones after it are fallbacks.
+func (r *Router) Primary(task Task) ProviderSpec {
+	chain := r.chain(task)
+	if len(chain) == 0 {
+		return ProviderSpec{}
+	}
+	return chain[0].spec
+}
+
+// Init starts every provider. It fails only when none of them starts.
+func (r *Router) Init() error {
+	var lastErr error
+	started := 0
+	for _, rt := range r.routes {
+		if rt.initErr != nil {
+			log.Printf("Warning: LLM provider %s failed to init: %v", rt.spec, rt.initErr)
+			lastErr = rt.initErr
+			continue
+		}
+		if err := rt.svc.Init(); err != nil {
+			log.Printf("Warning: LLM provider %s failed to init: %v", rt.spec, err)
+			rt.initErr = err
+			lastErr = err
+			continue
+		}
+		started++
+	}
+
+	if started == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+// HealthCheck passes when any provider is healthy.
+func (r *Router) HealthCheck() error {
+	var lastErr error
+	for _, rt := range r.routes {
+		if rt.initErr != nil {
+			lastErr = rt.initErr
+			continue
+		}
+		if err := rt.svc.HealthCheck(); err != nil {
+			lastErr = fmt.Errorf("%s: %w", rt.spec, err)
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// Limits returns the smallest context window and output limit in task's
+// chain, so a request that fits can go to any of its providers, and the
+// prices of the first provider, which answers when all is well.
+func (r *Router) Limits(task Task) ModelInfo {
+	chain := r.chain(task)
+
+	var limits ModelInfo
+	for i, rt := range chain {
+		info := LookupModel(rt.spec.Model)
+		if i == 0 {
+			limits = info
+			limits.Name = rt.spec.String()
+			continue
+		}
+		limits.ContextWindow = min(limits.ContextWindow, info.ContextWindow)
+		limits.OutputLimit = min(limits.OutputLimit, info.OutputLimit)
+	}
+	return limits
+}
+
+// CountTokens counts with the first available provider of the task's chain
+// that has a tokenizer. Tokenizers of one chain rarely differ by much.
+func (r *Router) CountTokens(ctx context.Context, text string) (int, error) {
+	chain := r.chain(taskFromContext(ctx))
+
+	now := time.Now()
+	for _, rt := range chain {
+		counter, ok := rt.svc.(TokenCounter)
+		if !ok || rt.initErr != nil {
+			continue
+		}
+		if _, open := rt.breaker.openUntil(now); open {
+			continue
+		}
+		return counter.CountTokens(ctx, text)
+	}
+	return 0, errNoTokenizer
+}
+
+func (r *Router) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *Schema) (string, error) {
+	chain := r.chain(taskFromContext(ctx))
+
+	var lastErr error
+	var reopenAt time.Time
+	for _, rt := range chain {
+		if rt.initErr != nil {
+			continue
+		}
+
+		now := time.Now()
+		if until, open := rt.breaker.openUntil(now); open {
+			if reopenAt.IsZero() || until.Before(reopenAt) {
+				reopenAt = until
+			}
+			continue
+		}
+
+		resp, err := r.attempt(ctx, rt, sysPrompt, userPrompt, schema)
+		if err == nil {
+			rt.breaker.succeed()
+			recordCall(ctx, rt.spec.Provider, rt.spec.Model, false)
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if !shouldFailover(err) {
+			return "", err
+		}
+
+		rt.breaker.fail(now, cooldownFor(err, now))
+		log.Printf("LLM provider %s failed, trying next: %v", rt.spec, err)
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return "", fmt.Errorf("all LLM providers failed: %w", lastErr)
+	}
+	if !reopenAt.IsZero() {
+		// Every provider is resting; tell the caller when to come back
+		return "", &RateLimitError{RetryAfter: time.Until(reopenAt), Message: "all LLM providers are temporarily unavailable"}
+	}
+	return "", errors.New("no LLM provider is available")
+}
+
+func (r *Router) attempt(ctx context.Context, rt *route, sysPrompt, userPrompt string, schema *Schema) (string, error) {
+	if r.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.attemptTimeout)
+		defer cancel()
+	}
+	return rt.svc.GenerateJSON(ctx, sysPrompt, userPrompt, schema)
+}
+
+// shouldFailover reports whether another provider might succeed where this
+// one failed. Bad requests and unparseable replies would fail everywhere.
+func shouldFailover(err error) bool {
+	var rateLimited *RateLimitError
+	var quota *QuotaError
+	var status *StatusError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &rateLimited), errors.As(err, &quota):
+		return true
+	case errors.As(err, &status):
+		return status.Code == http.StatusTooManyRequests || status.Code >= http.StatusInternalServerError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return true
+	default:
+		return false
+	}
+}
+
+// cooldownFor returns how long the breaker should stay open right away, or
+// zero to just count the failure.
+func cooldownFor(err error, now time.Time) time.Duration {
+	var rateLimited *RateLimitError
+	var quota *QuotaError
+
+	switch {
+	case errors.As(err, &quota):
+		return quota.ResetAt.Sub(now)
+	case errors.As(err, &rateLimited):
+		return rateLimited.RetryAfter
+	default:
+		return 0
+	}
+}
+
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	until    time.Time
+}
+
+func (b *breaker) openUntil(now time.Time) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.until, now.Before(b.until)
+}
+
+// fail counts a failure. A provider that says when to come back is skipped
+// until then; otherwise the breaker opens after breakerThreshold failures
+// in a row. Once the cooldown passes one call is let through, and another
+// failure opens it again.
+func (b *breaker) fail(now time.Time, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch {
+	case cooldown > 0:
+		b.until = now.Add(cooldown)
+	case b.failures >= breakerThreshold:
+		b.until = now.Add(breakerCooldown)
+	}
+}
+
+func (b *breaker) succeed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.until = time.Time{}
+}
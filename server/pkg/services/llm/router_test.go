@@ -0,0 +1,173 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingOpenAI counts the calls it gets and passes them to handle.
+func countingOpenAI(t *testing.T, model string, calls *atomic.Int32, handle func(http.ResponseWriter, map[string]any)) *OpenAIService {
+	t.Helper()
+
+	svc := fakeOpenAI(t, func(w http.ResponseWriter, body map[string]any) {
+		calls.Add(1)
+		handle(w, body)
+	})
+	svc.model = model
+	return svc
+}
+
+func status(code int) func(http.ResponseWriter, map[string]any) {
+	return func(w http.ResponseWriter, _ map[string]any) {
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(`{"error":{"message":"nope"}}`))
+	}
+}
+
+func TestRouterFailover(t *testing.T) {
+	tests := []struct {
+		name     string
+		primary  func(http.ResponseWriter, map[string]any)
+		failover bool
+	}{
+		{"rate limited", status(http.StatusTooManyRequests), true},
+		{"server error", status(http.StatusInternalServerError), true},
+		{"unavailable", status(http.StatusServiceUnavailable), true},
+		{"timeout", func(w http.ResponseWriter, body map[string]any) {
+			time.Sleep(300 * time.Millisecond)
+			reply(`{"from":"primary"}`)(w, body)
+		}, true},
+		{"bad request", status(http.StatusBadRequest), false},
+		{"unauthorized", status(http.StatusUnauthorized), false},
+		{"not found", status(http.StatusNotFound), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var primaryCalls, fallbackCalls atomic.Int32
+			primary := countingOpenAI(t, "primary", &primaryCalls, tt.primary)
+			fallback := countingOpenAI(t, "fallback", &fallbackCalls, reply(`{"from":"fallback"}`))
+			r := testRouter(t, primary, fallback)
+			r.attemptTimeout = 50 * time.Millisecond
+
+			ctx, info := WithCallInfo(context.Background())
+			got, err := r.GenerateJSON(ctx, "system", "user", nil)
+
+			if primaryCalls.Load() != 1 {
+				t.Errorf("primary called %d times, want 1", primaryCalls.Load())
+			}
+			if !tt.failover {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) {
+					t.Fatalf("error = %v, want the primary's StatusError", err)
+				}
+				if fallbackCalls.Load() != 0 {
+					t.Errorf("fallback called %d times, want 0", fallbackCalls.Load())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateJSON: %v", err)
+			}
+			if got != `{"from":"fallback"}` || info.String() != "openai:fallback" {
+				t.Errorf("answer = %s from %s, want the fallback's", got, info)
+			}
+		})
+	}
+}
+
+func TestRouterAllProvidersFail(t *testing.T) {
+	var calls atomic.Int32
+	r := testRouter(t,
+		countingOpenAI(t, "a", &calls, status(http.StatusBadGateway)),
+		countingOpenAI(t, "b", &calls, status(http.StatusServiceUnavailable)),
+	)
+
+	_, err := r.GenerateJSON(context.Background(), "system", "user", nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("error = %v, want the last provider's 503", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("providers called %d times, want 2", calls.Load())
+	}
+}
+
+func TestRouterBreaker(t *testing.T) {
+	var up atomic.Bool
+	var primaryCalls, fallbackCalls atomic.Int32
+	primary := flakyOpenAI(t, "primary", `{"from":"primary"}`, &up, &primaryCalls)
+	fallback := countingOpenAI(t, "fallback", &fallbackCalls, reply(`{"from":"fallback"}`))
+	r := testRouter(t, primary, fallback)
+	rt := r.routes["openai:primary@"+primary.baseURL]
+
+	answer := func() string {
+		t.Helper()
+		ctx, info := WithCallInfo(context.Background())
+		if _, err := r.GenerateJSON(ctx, "system", "user", nil); err != nil {
+			t.Fatalf("GenerateJSON: %v", err)
+		}
+		return info.Model
+	}
+
+	// Each failure below the threshold still tries the primary first
+	for i := 1; i <= breakerThreshold; i++ {
+		if got := answer(); got != "fallback" {
+			t.Fatalf("call %d answered by %s, want fallback", i, got)
+		}
+		if int(primaryCalls.Load()) != i {
+			t.Fatalf("after call %d the primary was called %d times", i, primaryCalls.Load())
+		}
+	}
+
+	until, open := rt.breaker.openUntil(time.Now())
+	if !open {
+		t.Fatalf("breaker is closed after %d failures", breakerThreshold)
+	}
+	if left := time.Until(until); left < breakerCooldown-5*time.Second || left > breakerCooldown {
+		t.Errorf("breaker open for %v, want %v", left, breakerCooldown)
+	}
+
+	// While open the primary is skipped
+	answer()
+	if primaryCalls.Load() != breakerThreshold {
+		t.Errorf("primary called while its breaker is open")
+	}
+
+	// Once the cooldown passes one call goes through, and a failure opens
+	// the breaker again right away
+	rt.breaker.until = rt.breaker.until.Add(-breakerCooldown)
+	if got := answer(); got != "fallback" || primaryCalls.Load() != breakerThreshold+1 {
+		t.Fatalf("after the cooldown answered by %s with %d primary calls", got, primaryCalls.Load())
+	}
+	if _, open := rt.breaker.openUntil(time.Now()); !open {
+		t.Fatal("breaker did not reopen after the trial call failed")
+	}
+
+	// A successful trial call closes it
+	rt.breaker.until = rt.breaker.until.Add(-breakerCooldown)
+	up.Store(true)
+	if got := answer(); got != "primary" {
+		t.Fatalf("healthy primary did not answer, %s did", got)
+	}
+	if rt.breaker.failures != 0 {
+		t.Errorf("failures = %d after a success, want 0", rt.breaker.failures)
+	}
+	if got := answer(); got != "primary" {
+		t.Errorf("next call answered by %s, want primary", got)
+	}
+}
+
+func TestRouterSkipsUnknownProvider(t *testing.T) {
+	r := NewRouter(map[Task][]ProviderSpec{TaskDefault: {{Provider: "gemni-api", Model: "gemini-2.5-flash"}}}, time.Second)
+
+	if err := r.Init(); err == nil {
+		t.Fatal("Init succeeded with only an unknown provider")
+	}
+	if _, err := r.GenerateJSON(context.Background(), "system", "user", nil); err == nil {
+		t.Fatal("GenerateJSON succeeded with only an unknown provider")
+	}
+}
@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SchemaType string
+
+const (
+	TypeObject  SchemaType = "object"
+	TypeArray   SchemaType = "array"
+	TypeString  SchemaType = "string"
+	TypeInteger SchemaType = "integer"
+	TypeNumber  SchemaType = "number"
+	TypeBoolean SchemaType = "boolean"
+)
+
+// Schema describes the JSON a caller expects back from GenerateJSON. Each
+// provider translates it into its own structured output format.
+type Schema struct {
+	Type        SchemaType
+	Description string
+	Enum        []string
+	Minimum     *float64           // For numbers
+	Maximum     *float64           // For numbers
+	Items       *Schema            // For arrays
+	Properties  map[string]*Schema // For objects
+	Order       []string           // Property names in declaration order
+	Required    []string
+}
+
+// SchemaFor derives a schema from a struct value or type using its json
+// tags. Fields without omitempty are required. A `desc` tag sets the
+// description, an `enum` tag a comma separated list of allowed values and
+// `min` and `max` tags the range of a number:
+//
+//	SceneType string  `json:"scene_type" desc:"Type of scene" enum:"action,dialogue"`
+//	Score     float64 `json:"score" min:"0" max:"1"`
+func SchemaFor(v any) *Schema {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	return schemaOf(t)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &Schema{Type: TypeString, Description: "RFC 3339 timestamp"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: TypeString}
+	case reflect.Bool:
+		return &Schema{Type: TypeBoolean}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: TypeInteger}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: TypeNumber}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: TypeArray, Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: TypeObject}
+	case reflect.Struct:
+		s := &Schema{Type: TypeObject, Properties: make(map[string]*Schema)}
+		addFields(s, t)
+		return s
+	default:
+		return &Schema{Type: TypeString}
+	}
+}
+
+func addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Embedded structs without a name are flattened, as encoding/json does
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(s, f.Type)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := schemaOf(f.Type)
+		if desc := f.Tag.Get("desc"); desc != "" {
+			prop.Description = desc
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			prop.Enum = strings.Split(enum, ",")
+		}
+		prop.Minimum = floatTag(f.Tag.Get("min"))
+		prop.Maximum = floatTag(f.Tag.Get("max"))
+
+		s.Properties[name] = prop
+		s.Order = append(s.Order, name)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func floatTag(tag string) *float64 {
+	if tag == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(tag, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// JSONSchema renders the schema as standard JSON Schema, the format taken
+// by Ollama and OpenAI compatible APIs.
+func (s *Schema) JSONSchema() map[string]any {
+	out := map[string]any{"type": string(s.Type)}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Minimum != nil {
+		out["minimum"] = *s.Minimum
+	}
+	if s.Maximum != nil {
+		out["maximum"] = *s.Maximum
+	}
+	if s.Items != nil {
+		out["items"] = s.Items.JSONSchema()
+	}
+	if s.Type == TypeObject && s.Properties != nil {
+		props := make(map[string]any, len(s.Properties))
+		for name, prop := range s.Properties {
+			props[name] = prop.JSONSchema()
+		}
+		out["properties"] = props
+		out["required"] = append([]string{}, s.Required...)
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSchemaFor(t *testing.T) {
+	type Base struct {
+		ID int `json:"id"`
+	}
+	type character struct {
+		Name string `json:"name" desc:"Full name"`
+	}
+	type scene struct {
+		Base
+		Type       string            `json:"type" enum:"action,dialogue"`
+		Score      float64           `json:"score" min:"0" max:"1"`
+		Weight     float32           `json:"weight" min:"oops"`
+		Count      uint8             `json:"count"`
+		Final      bool              `json:"final,omitempty"`
+		At         time.Time         `json:"at"`
+		Lead       *character        `json:"lead,omitempty"`
+		Cast       []character       `json:"cast"`
+		Tags       map[string]string `json:"tags,omitempty"`
+		Untagged   string
+		Skipped    string `json:"-"`
+		unexported string
+	}
+
+	for _, v := range []any{scene{}, &scene{}, reflect.TypeOf(scene{})} {
+		s := SchemaFor(v)
+		if s.Type != TypeObject {
+			t.Fatalf("SchemaFor(%T).Type = %s, want object", v, s.Type)
+		}
+
+		wantOrder := []string{"id", "type", "score", "weight", "count", "final", "at", "lead", "cast", "tags", "Untagged"}
+		if !slices.Equal(s.Order, wantOrder) {
+			t.Errorf("Order = %q, want %q", s.Order, wantOrder)
+		}
+		wantRequired := []string{"id", "type", "score", "weight", "count", "at", "cast", "Untagged"}
+		if !slices.Equal(s.Required, wantRequired) {
+			t.Errorf("Required = %q, want %q", s.Required, wantRequired)
+		}
+
+		types := map[string]SchemaType{
+			"id": TypeInteger, "type": TypeString, "score": TypeNumber, "weight": TypeNumber,
+			"count": TypeInteger, "final": TypeBoolean, "at": TypeString, "lead": TypeObject,
+			"cast": TypeArray, "tags": TypeObject, "Untagged": TypeString,
+		}
+		for name, want := range types {
+			if got := s.Properties[name]; got == nil || got.Type != want {
+				t.Errorf("property %s = %+v, want type %s", name, got, want)
+			}
+		}
+
+		if got := s.Properties["type"].Enum; !slices.Equal(got, []string{"action", "dialogue"}) {
+			t.Errorf("type enum = %q", got)
+		}
+		score := s.Properties["score"]
+		if score.Minimum == nil || *score.Minimum != 0 || score.Maximum == nil || *score.Maximum != 1 {
+			t.Errorf("score range = %v..%v, want 0..1", score.Minimum, score.Maximum)
+		}
+		if s.Properties["weight"].Minimum != nil {
+			t.Error("an unparsable min tag should be ignored")
+		}
+		if got := s.Properties["at"].Description; got != "RFC 3339 timestamp" {
+			t.Errorf("time description = %q", got)
+		}
+
+		lead := s.Properties["lead"]
+		if lead.Properties["name"] == nil || lead.Properties["name"].Description != "Full name" {
+			t.Errorf("lead = %+v, want a name property described as Full name", lead)
+		}
+		cast := s.Properties["cast"]
+		if cast.Items == nil || cast.Items.Type != TypeObject || !slices.Equal(cast.Items.Required, []string{"name"}) {
+			t.Errorf("cast items = %+v, want character objects", cast.Items)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	type item struct {
+		Kind  string `json:"kind" enum:"a,b" desc:"Kind"`
+		Score int    `json:"score,omitempty" min:"1"`
+	}
+	got := SchemaFor(struct {
+		Items []item `json:"items"`
+	}{}).JSONSchema()
+
+	want := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"kind":  map[string]any{"type": "string", "description": "Kind", "enum": []string{"a", "b"}},
+						"score": map[string]any{"type": "integer", "minimum": 1.0},
+					},
+					"required": []string{"kind"},
+				},
+			},
+		},
+		"required": []string{"items"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSONSchema() = %#v\nwant %#v", got, want)
+	}
+}
@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// errNoTokenizer is returned by wrappers whose providers cannot count.
+var errNoTokenizer = errors.New("no tokenizer for this model")
+
+// TokenCounter is implemented by services that can count tokens with the
+// tokenizer of the model behind the task in ctx.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, text string) (int, error)
+}
+
+// CountTokens counts text with svc's tokenizer, or estimates it when svc
+// cannot count or the count fails. counted reports which it was.
+func CountTokens(ctx context.Context, svc LLMService, text string) (tokens int, counted bool) {
+	if counter, ok := svc.(TokenCounter); ok {
+		tokens, err := counter.CountTokens(ctx, text)
+		if err == nil {
+			return tokens, true
+		}
+		if !errors.Is(err, errNoTokenizer) {
+			log.Printf("Token count failed, estimating instead: %v", err)
+		}
+	}
+	return EstimateTokens(text), false
+}
+
+// EstimateTokens guesses the token count of text at four characters a
+// token, which is close for English prose with common tokenizers.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
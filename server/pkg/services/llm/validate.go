@@ -0,0 +1,179 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// maxProblems caps how many problems Validate lists, so a reply that is wrong
+// everywhere does not turn into a huge repair prompt.
+const maxProblems = 20
+
+// ExtractJSON returns the JSON object or array in a reply, dropping code
+// fences and any prose before or after it.
+func ExtractJSON(raw string) string {
+	text := strings.TrimSpace(raw)
+
+	// ```json ... ``` and ``` ... ```
+	if strings.HasPrefix(text, "```") {
+		if _, rest, ok := strings.Cut(text, "\n"); ok {
+			text = rest
+		}
+		if end := strings.LastIndex(text, "```"); end >= 0 {
+			text = text[:end]
+		}
+		text = strings.TrimSpace(text)
+	}
+
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return text
+	}
+	if end := closingIndex(text, start); end >= 0 {
+		return text[start : end+1]
+	}
+	return text[start:]
+}
+
+// closingIndex finds the bracket closing the one at start, skipping strings.
+func closingIndex(text string, start int) int {
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Validate extracts the JSON from a reply and checks it against schema. It
+// returns the JSON and what is wrong with it, nothing when it is valid.
+func Validate(raw string, schema *Schema) (string, []string) {
+	text := ExtractJSON(raw)
+
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return text, []string{fmt.Sprintf("reply is not valid JSON: %v", err)}
+	}
+	if schema == nil {
+		return text, nil
+	}
+
+	var problems []string
+	schema.check("", value, &problems)
+	if len(problems) > maxProblems {
+		problems = append(problems[:maxProblems], fmt.Sprintf("and %d more problems", len(problems)-maxProblems))
+	}
+	return text, problems
+}
+
+func (s *Schema) check(path string, value any, problems *[]string) {
+	at := path
+	if at == "" {
+		at = "reply"
+	}
+	fail := func(format string, args ...any) {
+		*problems = append(*problems, at+" "+fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case TypeObject:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, name := range s.Required {
+			if v, ok := obj[name]; !ok || v == nil {
+				*problems = append(*problems, join(path, name)+" is missing")
+			}
+		}
+		for _, name := range s.propertyNames() {
+			if v, ok := obj[name]; ok && v != nil {
+				s.Properties[name].check(join(path, name), v, problems)
+			}
+		}
+
+	case TypeArray:
+		items, ok := value.([]any)
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				s.Items.check(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+
+	case TypeString:
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			fail("must be one of %s, got %q", strings.Join(s.Enum, ", "), str)
+		}
+
+	case TypeInteger, TypeNumber:
+		num, ok := value.(float64)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		if s.Type == TypeInteger && num != math.Trunc(num) {
+			fail("must be a whole number, got %v", num)
+		}
+		if s.Minimum != nil && num < *s.Minimum {
+			fail("must be at least %v, got %v", *s.Minimum, num)
+		}
+		if s.Maximum != nil && num > *s.Maximum {
+			fail("must be at most %v, got %v", *s.Maximum, num)
+		}
+
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			fail("must be true or false")
+		}
+	}
+}
+
+// propertyNames returns the properties in declaration order when known, so
+// problems are listed in a stable order.
+func (s *Schema) propertyNames() []string {
+	if len(s.Order) == len(s.Properties) {
+		return s.Order
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
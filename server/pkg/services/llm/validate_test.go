@@ -0,0 +1,150 @@
+package llm
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name, raw, want string
+	}{
+		{"bare object", `{"a":1}`, `{"a":1}`},
+		{"bare array", ` [1, 2] `, `[1, 2]`},
+		{"json fence", "```json\n{\"a\":1}\n```", `{"a":1}`},
+		{"plain fence", "```\n[{\"a\":1}]\n```", `[{"a":1}]`},
+		{"prose around", "Here you go:\n{\"a\":1}\nHope that helps!", `{"a":1}`},
+		{"prose in fence", "```json\nSure: {\"a\":1} done\n```", `{"a":1}`},
+		{"nested", `x {"a":{"b":[1,{"c":2}]}} y`, `{"a":{"b":[1,{"c":2}]}}`},
+		{"braces in strings", `{"a":"}{][","b":1} trailing }`, `{"a":"}{][","b":1}`},
+		{"escaped quote", `{"a":"say \"}\" now"} and }`, `{"a":"say \"}\" now"}`},
+		{"escaped backslash", `{"a":"c:\\"} }`, `{"a":"c:\\"}`},
+		{"unclosed", `{"a":{"b":1}`, `{"a":{"b":1}`},
+		{"no json", "no idea", "no idea"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractJSON(tt.raw); got != tt.want {
+				t.Errorf("ExtractJSON(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type scene struct {
+		Title string  `json:"title"`
+		Type  string  `json:"type" enum:"action,dialogue"`
+		Score float64 `json:"score" min:"0" max:"1"`
+		Order int     `json:"order"`
+		Note  string  `json:"note,omitempty"`
+	}
+	type reply struct {
+		Scenes []scene `json:"scenes"`
+		Done   bool    `json:"done"`
+	}
+	schema := SchemaFor(reply{})
+
+	tests := []struct {
+		name     string
+		raw      string
+		noSchema bool
+		problems []string
+	}{
+		{
+			name: "valid",
+			raw:  "```json\n{\"scenes\":[{\"title\":\"A {b}\",\"type\":\"action\",\"score\":0.5,\"order\":1}],\"done\":true}\n```",
+		},
+		{
+			name: "optional field present",
+			raw:  `{"scenes":[{"title":"A","type":"dialogue","score":1,"order":2,"note":"x"}],"done":false}`,
+		},
+		{
+			name:     "not json",
+			raw:      `{"scenes": [}`,
+			problems: []string{"reply is not valid JSON"},
+		},
+		{
+			name:     "missing required",
+			raw:      `{"scenes":[{"title":"A","type":"action","score":0.5}]}`,
+			problems: []string{"done is missing", "scenes[0].order is missing"},
+		},
+		{
+			name:     "null counts as missing",
+			raw:      `{"scenes":[],"done":null}`,
+			problems: []string{"done is missing"},
+		},
+		{
+			name: "wrong types",
+			raw:  `{"scenes":[{"title":3,"type":"action","score":"high","order":1}],"done":"yes"}`,
+			problems: []string{
+				"scenes[0].title must be a string",
+				"scenes[0].score must be a number",
+				"done must be true or false",
+			},
+		},
+		{
+			name:     "array expected",
+			raw:      `{"scenes":{"title":"A"},"done":true}`,
+			problems: []string{"scenes must be an array"},
+		},
+		{
+			name:     "object expected",
+			raw:      `[1, 2]`,
+			problems: []string{"reply must be an object"},
+		},
+		{
+			name:     "enum violation",
+			raw:      `{"scenes":[{"title":"A","type":"montage","score":0.5,"order":1}],"done":true}`,
+			problems: []string{`scenes[0].type must be one of action, dialogue, got "montage"`},
+		},
+		{
+			name: "range and whole number",
+			raw:  `{"scenes":[{"title":"A","type":"action","score":1.5,"order":1.5},{"title":"B","type":"action","score":-1,"order":2}],"done":true}`,
+			problems: []string{
+				"scenes[0].score must be at most 1, got 1.5",
+				"scenes[0].order must be a whole number, got 1.5",
+				"scenes[1].score must be at least 0, got -1",
+			},
+		},
+		{
+			name:     "no schema",
+			raw:      `Sure! {"anything": [1, "two"]}`,
+			noSchema: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := schema
+			if tt.noSchema {
+				s = nil
+			}
+			_, problems := Validate(tt.raw, s)
+			if len(problems) != len(tt.problems) {
+				t.Fatalf("Validate() problems = %q, want %q", problems, tt.problems)
+			}
+			for i, want := range tt.problems {
+				if !strings.HasPrefix(problems[i], want) {
+					t.Errorf("problem %d = %q, want %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCapsProblems(t *testing.T) {
+	schema := &Schema{Type: TypeArray, Items: &Schema{Type: TypeString}}
+	raw := "[" + strings.TrimSuffix(strings.Repeat("1,", maxProblems+5), ",") + "]"
+
+	_, problems := Validate(raw, schema)
+	if len(problems) != maxProblems+1 {
+		t.Fatalf("got %d problems, want %d", len(problems), maxProblems+1)
+	}
+	if last := problems[maxProblems]; last != "and 5 more problems" {
+		t.Errorf("last problem = %q", last)
+	}
+	if !slices.Contains(problems, "[0] must be a string") {
+		t.Errorf("problems = %q, want one for the first item", problems)
+	}
+}
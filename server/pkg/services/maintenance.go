@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/scheduler"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
+	"gorm.io/gorm"
+)
+
+const (
+	// orphanGrace keeps upload directories younger than this, so an upload
+	// still being written is never mistaken for an orphan.
+	orphanGrace = 24 * time.Hour
+	// reconcileGrace leaves volumes touched this recently to their own job.
+	reconcileGrace = time.Minute
+	// maxErrorRetries is how many failed runs a volume may have in
+	// errorRetryWindow before the nightly retry leaves it alone.
+	maxErrorRetries  = 3
+	errorRetryWindow = 7 * 24 * time.Hour
+)
+
+// softDeletable lists the models purged after SOFT_DELETE_RETENTION_DAYS,
+// parents first. Their children go with them through ON DELETE CASCADE.
+var softDeletable = []any{
+	&models.Library{},
+	&models.Book{},
+	&models.Volume{},
+	&models.Chapter{},
+	&models.Section{},
+	&models.Scene{},
+	&models.Character{},
+	&models.Summary{},
+	&models.Bookmark{},
+	&models.Annotation{},
+	&models.Webhook{},
+}
+
+// MaintenanceService holds the recurring jobs run by the scheduler.
+type MaintenanceService struct {
+	db    *gorm.DB
+	ss    storage.StorageService
+	books *BookService
+	cfg   config.Config
+}
+
+func NewMaintenanceService(ss storage.StorageService, books *BookService) *MaintenanceService {
+	return &MaintenanceService{
+		db:    db.GetBooktureDB().DB,
+		ss:    ss,
+		books: books,
+		cfg:   config.AppConfig,
+	}
+}
+
+// Register adds the maintenance jobs to s. Specs are in UTC.
+func (ms *MaintenanceService) Register(s *scheduler.Scheduler) error {
+	jobs := []struct {
+		name, spec, description string
+		timeout                 time.Duration
+		run                     scheduler.RunFunc
+	}{
+		{"reconcile-stuck-volumes", "* * * * *", "Re-enqueue uploaded volumes that have no live job", time.Minute, ms.ReconcileStuckVolumes},
+		{"purge-orphaned-uploads", "0 3 * * *", "Delete upload directories whose volume no longer exists", 10 * time.Minute, ms.PurgeOrphanedUploads},
+		{"purge-soft-deleted", "30 3 * * *", "Hard-delete rows soft-deleted past the retention period", 30 * time.Minute, ms.PurgeSoftDeleted},
+		{"compact-task-history", "0 4 * * *", "Delete task runs, worker jobs, webhook deliveries and LLM usage past retention", 30 * time.Minute, ms.CompactTaskHistory},
+		{"purge-llm-cache", "15 4 * * *", "Delete expired LLM cache entries", 10 * time.Minute, ms.PurgeLLMCache},
+		{"retry-errored-volumes", ms.cfg.ERROR_RETRY_SCHEDULE, "Re-run volumes that ended in error, off-peak after the LLM quota resets", 10 * time.Minute, ms.RetryErroredVolumes},
+	}
+
+	for _, j := range jobs {
+		if err := s.Register(j.name, j.spec, j.description, j.timeout, j.run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *MaintenanceService) ReconcileStuckVolumes(ctx context.Context) (string, error) {
+	n, err := ms.books.ReconcileStuckVolumes(ctx, reconcileGrace)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("re-enqueued %d volumes", n), nil
+}
+
+// PurgeOrphanedUploads removes volume directories under STORAGE_PATH whose
+// volume row is gone, including soft-deleted rows that have been purged.
+func (ms *MaintenanceService) PurgeOrphanedUploads(ctx context.Context) (string, error) {
+	stored, err := ms.ss.ListVolumes()
+	if err != nil {
+		return "", err
+	}
+
+	cutoff := time.Now().Add(-orphanGrace)
+	deleted := 0
+	for _, v := range stored {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if v.ModifiedAt.After(cutoff) {
+			continue
+		}
+
+		volumeID, err := strconv.ParseUint(v.VolumeID, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		var count int64
+		if err := ms.db.WithContext(ctx).Unscoped().Model(&models.Volume{}).Where("id = ?", volumeID).Count(&count).Error; err != nil {
+			return "", fmt.Errorf("failed to look up volume %d: %w", volumeID, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		if err := ms.ss.DeleteVolume(v.BookID, v.VolumeID); err != nil {
+			log.Printf("Failed to purge orphaned upload of Volume %d: %v", volumeID, err)
+			continue
+		}
+		deleted++
+	}
+
+	return fmt.Sprintf("deleted %d of %d upload directories", deleted, len(stored)), nil
+}
+
+// PurgeSoftDeleted hard-deletes rows soft-deleted more than
+// SOFT_DELETE_RETENTION_DAYS ago.
+func (ms *MaintenanceService) PurgeSoftDeleted(ctx context.Context) (string, error) {
+	cutoff := time.Now().AddDate(0, 0, -ms.cfg.SOFT_DELETE_RETENTION_DAYS)
+
+	var total int64
+	for _, model := range softDeletable {
+		res := ms.db.WithContext(ctx).Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Delete(model)
+		if res.Error != nil {
+			return "", fmt.Errorf("failed to purge %T: %w", model, res.Error)
+		}
+		total += res.RowsAffected
+	}
+
+	return fmt.Sprintf("purged %d rows", total), nil
+}
+
+// CompactTaskHistory drops task runs, finished worker jobs, webhook
+// deliveries and LLM usage counters older than TASK_HISTORY_RETENTION_DAYS.
+func (ms *MaintenanceService) CompactTaskHistory(ctx context.Context) (string, error) {
+	cutoff := time.Now().AddDate(0, 0, -ms.cfg.TASK_HISTORY_RETENTION_DAYS)
+	db := ms.db.WithContext(ctx).Unscoped()
+
+	runs := db.Where("finished_at IS NOT NULL AND finished_at < ?", cutoff).Delete(&models.TaskRun{})
+	if runs.Error != nil {
+		return "", fmt.Errorf("failed to compact task runs: %w", runs.Error)
+	}
+
+	jobs := db.Where("finished_at IS NOT NULL AND finished_at < ?", cutoff).Delete(&models.PipelineJob{})
+	if jobs.Error != nil {
+		return "", fmt.Errorf("failed to compact worker jobs: %w", jobs.Error)
+	}
+
+	deliveries := db.Where("status <> ? AND created_at < ?", enums.DeliveryPending.ToString(), cutoff).
+		Delete(&models.WebhookDelivery{})
+	if deliveries.Error != nil {
+		return "", fmt.Errorf("failed to compact webhook deliveries: %w", deliveries.Error)
+	}
+
+	// Minute counters only matter while their minute lasts
+	usage := db.Where("(period = ? AND period_start < ?) OR period_start < ?", "minute", time.Now().Add(-time.Hour), cutoff).
+		Delete(&models.LLMUsage{})
+	if usage.Error != nil {
+		return "", fmt.Errorf("failed to compact llm usage: %w", usage.Error)
+	}
+
+	return fmt.Sprintf("deleted %d task runs, %d worker jobs, %d deliveries, %d usage rows",
+		runs.RowsAffected, jobs.RowsAffected, deliveries.RowsAffected, usage.RowsAffected), nil
+}
+
+func (ms *MaintenanceService) PurgeLLMCache(ctx context.Context) (string, error) {
+	res := ms.db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&models.LLMCacheEntry{})
+	if res.Error != nil {
+		return "", fmt.Errorf("failed to purge llm cache: %w", res.Error)
+	}
+	return fmt.Sprintf("deleted %d expired entries", res.RowsAffected), nil
+}
+
+// RetryErroredVolumes re-runs volumes that ended in error from the stage
+// that failed: volumes that got as far as scenes or images are resumed,
+// keeping what was generated, and the rest are parsed again. It is meant to
+// run off-peak once the provider's daily quota has reset. Volumes that keep
+// failing are left for the user.
+func (ms *MaintenanceService) RetryErroredVolumes(ctx context.Context) (string, error) {
+	var errored []struct {
+		VolumeID uint
+		UserID   uint
+	}
+
+	recentFailures := ms.db.Model(&models.TaskRun{}).
+		Select("volume_id").
+		Where("status = ? AND started_at > ?", enums.TaskError.ToString(), time.Now().Add(-errorRetryWindow)).
+		Group("volume_id").
+		Having("COUNT(*) >= ?", maxErrorRetries)
+
+	err := ms.db.WithContext(ctx).Model(&models.Volume{}).
+		Select("volumes.id AS volume_id, libraries.user_id AS user_id").
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("volumes.uploaded = ? AND volumes.status = ?", true, enums.VolumeError.ToString()).
+		Where("volumes.id NOT IN (?)", recentFailures).
+		Order("volumes.id ASC").
+		Scan(&errored).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to find errored volumes: %w", err)
+	}
+
+	retried := 0
+	for _, v := range errored {
+		kind := taskKindParse
+		if ms.failedAfterStructure(ctx, v.VolumeID) {
+			kind = taskKindResume
+		}
+
+		_, err := ms.books.dispatch(kind, v.UserID, v.VolumeID)
+		if errors.Is(err, ErrQueueFull) {
+			break
+		}
+		if err != nil {
+			continue
+		}
+
+		log.Printf("Retrying errored Volume %d (%s)", v.VolumeID, kind)
+		retried++
+	}
+
+	return fmt.Sprintf("retried %d of %d errored volumes", retried, len(errored)), nil
+}
+
+// failedAfterStructure reports whether the last run of a volume failed in
+// the scene or image stage, when its chapters and sections were complete.
+func (ms *MaintenanceService) failedAfterStructure(ctx context.Context, volumeID uint) bool {
+	var stage models.TaskStageRun
+	err := ms.db.WithContext(ctx).
+		Joins("JOIN task_runs ON task_runs.id = task_stage_runs.task_run_id").
+		Where("task_runs.volume_id = ? AND task_runs.deleted_at IS NULL", volumeID).
+		Order("task_runs.id DESC, task_stage_runs.started_at DESC").
+		First(&stage).Error
+	if err != nil {
+		return false
+	}
+	return stage.Stage == enums.StageScenes.ToString() || stage.Stage == enums.StageImages.ToString()
+}
@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/gen_image"
+)
+
+// maxAppearanceChars caps how much of one character's description goes into
+// an image prompt, so a long profile does not drown the scene.
+const maxAppearanceChars = 300
+
+// storyPosition orders sections through a book.
+type storyPosition struct {
+	volume, chapter, section int
+}
+
+func (p storyPosition) after(q storyPosition) bool {
+	if p.volume != q.volume {
+		return p.volume > q.volume
+	}
+	if p.chapter != q.chapter {
+		return p.chapter > q.chapter
+	}
+	return p.section > q.section
+}
+
+// bookCast is what the image step knows about a book's characters, so each
+// is drawn the same way in every scene.
+type bookCast struct {
+	byName    map[string]*models.Character
+	positions map[uint]storyPosition // of the sections of versions and scenes
+}
+
+// loadCast reads the characters of a book, their versions and where in the
+// story each version and each of scenes is.
+func (s *ParserService) loadCast(ctx context.Context, bookID uint, scenes []models.Scene) (*bookCast, error) {
+	db := s.db.WithContext(ctx)
+
+	var characters []models.Character
+	if err := db.Preload("Versions").Where("book_id = ?", bookID).Find(&characters).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch characters: %w", err)
+	}
+
+	cast := &bookCast{
+		byName:    make(map[string]*models.Character),
+		positions: make(map[uint]storyPosition),
+	}
+	if len(characters) == 0 {
+		return cast, nil
+	}
+
+	var sectionIDs []uint
+	for i := range characters {
+		character := &characters[i]
+		for _, name := range append([]string{character.Name}, strings.Split(character.Alias, ",")...) {
+			if key := nameKey(name); key != "" {
+				cast.byName[key] = character
+			}
+		}
+		for _, version := range character.Versions {
+			if version.SectionID != nil {
+				sectionIDs = append(sectionIDs, *version.SectionID)
+			}
+		}
+	}
+	for _, scene := range scenes {
+		sectionIDs = append(sectionIDs, scene.SectionID)
+	}
+
+	var rows []struct {
+		ID        uint
+		Volume    int
+		ChapterNo int
+		SectionNo int
+	}
+	err := db.Model(&models.Section{}).
+		Select(`sections.id, volumes."index" AS volume, chapters.chapter_no, sections.section_no`).
+		Joins("JOIN chapters ON chapters.id = sections.chapter_id").
+		Joins("JOIN volumes ON volumes.id = chapters.volume_id").
+		Where("sections.id IN ?", sectionIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to place character versions: %w", err)
+	}
+	for _, row := range rows {
+		cast.positions[row.ID] = storyPosition{row.Volume, row.ChapterNo, row.SectionNo}
+	}
+
+	return cast, nil
+}
+
+// dress adds the look of the scene's characters to req, as they are at that
+// point of the story, and seeds it from the first of them so a character
+// keeps a face across scenes on providers that honour seeds.
+func (c *bookCast) dress(req gen_image.ImageRequest, scene models.Scene) gen_image.ImageRequest {
+	var looks []string
+	var lead *models.Character
+
+	seen := make(map[uint]bool)
+	for _, name := range strings.Split(scene.Characters, ",") {
+		character, ok := c.byName[nameKey(name)]
+		if !ok || seen[character.ID] {
+			continue
+		}
+		seen[character.ID] = true
+
+		look := c.appearance(character, scene.SectionID)
+		if look == "" {
+			continue
+		}
+		looks = append(looks, character.Name+": "+look)
+		if lead == nil {
+			lead = character
+		}
+	}
+
+	if lead == nil {
+		return req
+	}
+	req.Prompt += "\n\nCharacters: " + strings.Join(looks, "; ")
+	req.Seed = gen_image.CharacterSeed(lead.ID)
+	return req
+}
+
+// appearance is how a character looks at a section: the initial description
+// and the appearance notes of the latest version before it. Versions without
+// a section apply from the start.
+func (c *bookCast) appearance(character *models.Character, sectionID uint) string {
+	at, placed := c.positions[sectionID]
+
+	var current *models.CharacterVersion
+	var currentPos storyPosition
+	for i := range character.Versions {
+		version := &character.Versions[i]
+		if version.AppearanceNotes == "" {
+			continue
+		}
+
+		var pos storyPosition
+		if version.SectionID != nil {
+			var ok bool
+			if pos, ok = c.positions[*version.SectionID]; !ok || !placed || pos.after(at) {
+				continue
+			}
+		}
+		if current == nil || pos.after(currentPos) ||
+			(pos == currentPos && version.VersionNumber > current.VersionNumber) {
+			current, currentPos = version, pos
+		}
+	}
+
+	descriptions := []string{character.InitialDescription}
+	if current != nil {
+		descriptions = append(descriptions, current.AppearanceNotes)
+	}
+	var parts []string
+	for _, d := range descriptions {
+		if d = strings.TrimRight(strings.TrimSpace(d), "."); d != "" {
+			parts = append(parts, d)
+		}
+	}
+	look := strings.Join(parts, ". ")
+
+	if runes := []rune(look); len(runes) > maxAppearanceChars {
+		look = string(runes[:maxAppearanceChars])
+		if i := strings.LastIndexByte(look, ' '); i > 0 {
+			look = look[:i]
+		}
+	}
+	return look
+}
+
+// nameKey matches character names however the LLM cased or spaced them.
+func nameKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
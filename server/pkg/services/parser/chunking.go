@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+)
+
+const (
+	// chunkOverheadTokens is kept free in each scene request for the
+	// instructions and the rolling summary.
+	chunkOverheadTokens = 1024
+	// minChunkTokens keeps tiny context windows from splitting chapters
+	// into sentence sized parts.
+	minChunkTokens = 512
+	// sceneReplyTokens is about what one scene takes in a reply, so the
+	// output limit caps how many sections one request may carry.
+	sceneReplyTokens = 250
+	// calibrationChars of chapter text are counted with the provider's
+	// tokenizer to correct EstimateTokens for the book's language.
+	calibrationChars = 40_000
+)
+
+// chunkBudget bounds one scene request. tokens is in EstimateTokens units,
+// already corrected by scale, the provider's tokens per estimated token.
+type chunkBudget struct {
+	tokens   int
+	sections int
+	scale    float64
+	counted  bool // scale comes from the provider's tokenizer
+}
+
+// chapterChunk is a run of whole sections that fits one scene request. A
+// section too long on its own is split into several chunks.
+type chapterChunk struct {
+	sections []int
+	text     string
+}
+
+// sceneBudget sizes scene requests for the models of the scene chain. The
+// chapter text gets what is left of the smallest context window after the
+// reply, which takes at most half of it. Part of chapters is counted with
+// the provider's tokenizer, when it has one, to scale the estimates.
+func (eps *ParserService) sceneBudget(ctx context.Context, chapters []models.Chapter) chunkBudget {
+	limits := llm.LimitsOf(eps.llm, llm.TaskScenes)
+	input := limits.ContextWindow - min(limits.OutputLimit, limits.ContextWindow/2) - chunkOverheadTokens
+
+	budget := chunkBudget{
+		tokens:   max(input, minChunkTokens),
+		sections: max(limits.OutputLimit/sceneReplyTokens, 1),
+		scale:    1,
+	}
+
+	sample := calibrationSample(chapters)
+	if sample == "" {
+		return budget
+	}
+	counted, ok := llm.CountTokens(llm.WithTask(ctx, llm.TaskScenes), eps.llm, sample)
+	if estimated := llm.EstimateTokens(sample); ok && counted > 0 {
+		// Kept within reason, a tokenizer may count a short sample oddly
+		budget.scale = min(max(float64(counted)/float64(estimated), 0.5), 4)
+		budget.counted = true
+		budget.tokens = max(int(float64(budget.tokens)/budget.scale), minChunkTokens)
+	}
+	return budget
+}
+
+// calibrationSample is the start of the chapters' text, up to
+// calibrationChars.
+func calibrationSample(chapters []models.Chapter) string {
+	var b strings.Builder
+	for _, chapter := range chapters {
+		for _, section := range chapter.Sections {
+			if b.Len() >= calibrationChars {
+				return b.String()
+			}
+			text := section.CleanText
+			if len(text) > calibrationChars-b.Len() {
+				// Cut between words, the tokenizer would split a cut word
+				text = text[:calibrationChars-b.Len()]
+				if i := strings.LastIndexByte(text, ' '); i > 0 {
+					text = text[:i]
+				}
+			}
+			b.WriteString(text)
+			b.WriteString("\n\n")
+		}
+	}
+	return b.String()
+}
+
+// chunkChapter groups the sections of chapter into chunks that fit budget,
+// each starting with the chapter heading. A chapter that fits comes back as
+// a single chunk.
+func chunkChapter(chapter models.Chapter, budget chunkBudget) []chapterChunk {
+	header := fmt.Sprintf("Chapter %d: %s\n\n", chapter.ChapterNo, chapter.Title)
+
+	var chunks []chapterChunk
+	current := chapterChunk{text: header}
+	flush := func() {
+		if len(current.sections) > 0 {
+			chunks = append(chunks, current)
+		}
+		current = chapterChunk{text: header}
+	}
+
+	for _, section := range chapter.Sections {
+		block := fmt.Sprintf("Section %d:\n%s\n\n", section.SectionNo, section.CleanText)
+
+		if llm.EstimateTokens(header+block) > budget.tokens {
+			flush()
+			parts := splitWords(section.CleanText, budget.tokens-llm.EstimateTokens(header)-16)
+			for i, part := range parts {
+				chunks = append(chunks, chapterChunk{
+					sections: []int{section.SectionNo},
+					text:     header + fmt.Sprintf("Section %d (part %d of %d):\n%s\n\n", section.SectionNo, i+1, len(parts), part),
+				})
+			}
+			continue
+		}
+
+		if len(current.sections) > 0 && (llm.EstimateTokens(current.text+block) > budget.tokens || len(current.sections) == budget.sections) {
+			flush()
+		}
+		current.text += block
+		current.sections = append(current.sections, section.SectionNo)
+	}
+	flush()
+
+	return chunks
+}
+
+// splitWords cuts text into pieces of at most budget tokens, between words.
+func splitWords(text string, budget int) []string {
+	budget = max(budget, 1)
+
+	var parts []string
+	var part strings.Builder
+	tokens := 0
+	for _, word := range strings.Fields(text) {
+		// Counted word by word, which rounds up and so stays under budget
+		wordTokens := llm.EstimateTokens(" " + word)
+		if part.Len() > 0 && tokens+wordTokens > budget {
+			parts = append(parts, part.String())
+			part.Reset()
+			tokens = 0
+		}
+		if part.Len() > 0 {
+			part.WriteByte(' ')
+		}
+		part.WriteString(word)
+		tokens += wordTokens
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	return parts
+}
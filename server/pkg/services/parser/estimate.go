@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+// Rough reply sizes of the calls whose replies do not grow with sections.
+const (
+	metadataReplyTokens = 200
+	summaryReplyTokens  = 150 // Rolling summary of a chapter part
+	rankingSceneTokens  = 60  // One scene in a ranking request
+	rankingReplyTokens  = 30  // One ranking in its reply
+)
+
+// EstimateVolume parses the volume's file, without saving anything, and
+// works out the LLM requests, tokens and cost of its metadata and scene
+// stages the way processing would split them. Retries and repairs are not
+// counted.
+func (s *ParserService) EstimateVolume(ctx context.Context, volume *models.Volume) (*views.VolumeEstimateView, error) {
+	parsed, err := s.parseFileStructure(volume)
+	if err != nil {
+		return nil, err
+	}
+
+	chapters := make([]models.Chapter, len(parsed.Chapters))
+	sections := 0
+	for i, parsedChapter := range parsed.Chapters {
+		chapters[i] = models.Chapter{ChapterNo: parsedChapter.ChapterNumber, Title: parsedChapter.DetectedTitle}
+		for _, parsedSection := range parsedChapter.Sections {
+			chapters[i].Sections = append(chapters[i].Sections, models.Section{
+				SectionNo: parsedSection.SectionNumber,
+				CleanText: parsedSection.CleanText,
+			})
+		}
+		sections += len(parsedChapter.Sections)
+	}
+
+	budget := s.sceneBudget(ctx, chapters)
+	overhead := s.promptOverhead(ctx, &volume.Book)
+
+	estimate := &views.VolumeEstimateView{
+		VolumeID: utils.MaskID(volume.ID),
+		Chapters: len(chapters),
+		Sections: sections,
+		Words:    parsed.WordCount,
+		Images:   sections, // One scene per section
+	}
+
+	// Both counted in EstimateTokens units until scaled below
+	var input, output int
+	if parsed.DetectedTitle == "" || parsed.DetectedAuthor == "" {
+		estimate.Requests++
+		input += overhead + llm.EstimateTokens(s.getSampleText(parsed, 2000))
+		output += metadataReplyTokens
+	}
+
+	for _, batch := range batchChapters(chapters, budget, s.sceneBatch) {
+		if len(batch) > 1 {
+			estimate.Requests++
+			input += overhead
+			for _, chapter := range batch {
+				input += llm.EstimateTokens(formatChapterText(chapter))
+				output += len(chapter.Sections) * sceneReplyTokens
+			}
+			continue
+		}
+
+		chapter := batch[0]
+		chunks := chunkChapter(chapter, budget)
+		for _, chunk := range chunks {
+			estimate.Requests++
+			input += overhead + llm.EstimateTokens(chunk.text)
+		}
+		output += len(chapter.Sections) * sceneReplyTokens
+
+		if len(chunks) > 1 {
+			output += len(chunks) * summaryReplyTokens
+			if len(chapter.Sections) > 1 {
+				estimate.Requests++
+				input += overhead + len(chapter.Sections)*rankingSceneTokens
+				output += len(chapter.Sections) * rankingReplyTokens
+			}
+		}
+	}
+
+	limits := llm.LimitsOf(s.llm, llm.TaskScenes)
+	estimate.Model = limits.Name
+	estimate.InputTokens = int(float64(input) * budget.scale)
+	estimate.OutputTokens = int(float64(output) * budget.scale)
+	estimate.TokensCounted = budget.counted
+
+	cost := float64(estimate.InputTokens)*limits.InputPrice/1e6 + float64(estimate.OutputTokens)*limits.OutputPrice/1e6
+	estimate.CostUSD = math.Round(cost*1e4) / 1e4
+
+	_, model, _ := strings.Cut(limits.Name, ":")
+	if rpm := llm.ParseLimits(config.AppConfig.LLM_MODEL_LIMITS)[model].RPM; rpm > 0 {
+		estimate.Minutes = (estimate.Requests + rpm - 1) / rpm
+	}
+	return estimate, nil
+}
+
+// promptOverhead is the size of the scene prompts without any chapter text.
+func (s *ParserService) promptOverhead(ctx context.Context, book *models.Book) int {
+	prompt, err := s.prompts.Render(ctx, llm.TaskScenes, promptScope(book), prompts.SceneData{
+		BookTitle: book.Title,
+		Author:    book.Author,
+		Part:      1,
+		Parts:     1,
+	})
+	if err != nil {
+		return chunkOverheadTokens
+	}
+	return llm.EstimateTokens(prompt.System + prompt.User)
+}
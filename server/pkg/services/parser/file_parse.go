@@ -15,11 +15,11 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/enums"
 	"github.com/Mahaveer86619/bookture/server/pkg/models"
-	"google.golang.org/genai"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
 )
 
 func (s *ParserService) parseFileStructure(volume *models.Volume) (*ParsedVolume, error) {
@@ -619,7 +619,7 @@ func (s *ParserService) createSection(sectionNum int, text string) ParsedSection
 // LLM METADATA ENHANCEMENT
 // ============================================================================
 
-func (s *ParserService) enhanceMetadataWithLLM(parsed *ParsedVolume, volume *models.Volume) {
+func (s *ParserService) enhanceMetadataWithLLM(ctx context.Context, parsed *ParsedVolume, volume *models.Volume) {
 	log.Printf("Enhancing metadata with LLM for Volume %d", volume.ID)
 
 	// Get sample text (first 2000 words)
@@ -629,41 +629,17 @@ func (s *ParserService) enhanceMetadataWithLLM(parsed *ParsedVolume, volume *mod
 		return
 	}
 
-	// Define JSON schema for Gemini
-	schema := &genai.Schema{
-		Type: genai.TypeObject,
-		Properties: map[string]*genai.Schema{
-			"title": {
-				Type:        genai.TypeString,
-				Description: "The title of the book",
-			},
-			"author": {
-				Type:        genai.TypeString,
-				Description: "The author's name",
-			},
-			"description": {
-				Type:        genai.TypeString,
-				Description: "A brief description or summary of the book (2-3 sentences)",
-			},
-			"genre": {
-				Type:        genai.TypeString,
-				Description: "The primary genre of the book",
-			},
-		},
-		Required: []string{"title", "author", "description"},
-	}
+	schema := llm.SchemaFor(LLMMetadataResponse{})
 
-	sysPrompt := `You are a literary analyst. Analyze the provided book excerpt.
-Extract the Title, Author, and a short Description (2-3 sentences).
-If the title or author cannot be determined from the text, provide your best inference.
-Return strictly a JSON object with the specified fields.`
-
-	userPrompt := fmt.Sprintf("Analyze this book excerpt and extract metadata:\n\n%s", sampleText)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	prompt, err := s.prompts.Render(ctx, llm.TaskMetadata, promptScope(&volume.Book), prompts.MetadataData{Excerpt: sampleText})
+	if err != nil {
+		log.Printf("Failed to render metadata prompt: %v", err)
+		parsed.Errors = append(parsed.Errors, fmt.Sprintf("LLM enhancement failed: %v", err))
+		return
+	}
 
-	jsonResp, err := s.llm.GenerateJSON(ctx, sysPrompt, userPrompt, schema)
+	base := models.AIPrompt{Task: string(llm.TaskMetadata), Attempt: 1, VolumeID: &volume.ID}
+	jsonResp, source, err := s.generateJSON(ctx, base, promptScope(&volume.Book), prompt, schema, nil)
 	if err != nil {
 		log.Printf("LLM metadata generation failed: %v", err)
 		parsed.Errors = append(parsed.Errors, fmt.Sprintf("LLM enhancement failed: %v", err))
@@ -690,7 +666,7 @@ Return strictly a JSON object with the specified fields.`
 		parsed.DetectedDescription = meta.Description
 	}
 
-	log.Printf("LLM metadata enhancement completed: Title=%s, Author=%s", meta.Title, meta.Author)
+	log.Printf("LLM metadata enhancement completed by %s:%s: Title=%s, Author=%s", source.Provider, source.ModelName, meta.Title, meta.Author)
 }
 
 func (s *ParserService) getSampleText(parsed *ParsedVolume, maxWords int) string {
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
+)
+
+// callTimeouts bound each LLM call of a task, long enough for the router to
+// fall back to another provider. A repair gets its own budget.
+var callTimeouts = map[llm.Task]time.Duration{
+	llm.TaskMetadata: 2 * time.Minute,
+	llm.TaskScenes:   3 * time.Minute,
+}
+
+// errInvalidReply is wrapped by generateJSON when the reply is still invalid
+// after a repair.
+var errInvalidReply = errors.New("LLM reply failed validation")
+
+// generateJSON asks the LLM for JSON and checks the reply against schema and
+// then check, which may add problems the schema cannot express. An invalid
+// reply gets one repair request listing its problems before the call fails.
+//
+// Every call is recorded in the audit log on top of base, which carries the
+// task, attempt and the IDs of what is being generated. It returns the JSON,
+// without any code fences or prose, and the audit row of the call that
+// produced it.
+func (s *ParserService) generateJSON(ctx context.Context, base models.AIPrompt, scope prompts.Scope, prompt prompts.Prompt, schema *llm.Schema, check func(string) []string) (string, *models.AIPrompt, error) {
+	task := llm.Task(base.Task)
+	ctx = llm.WithTask(ctx, task)
+
+	reply, first, problems, err := s.callLLM(ctx, base, prompt, schema, check)
+	if err != nil {
+		return "", nil, fmt.Errorf("LLM generation failed: %w", err)
+	}
+	if len(problems) == 0 {
+		return reply, &first.row, nil
+	}
+
+	repairPrompt, err := s.prompts.Render(ctx, prompts.TaskRepair, scope, prompts.RepairData{
+		System:   prompt.System,
+		Request:  prompt.User,
+		Reply:    first.row.Response,
+		Problems: problems,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to render repair prompt: %w", err)
+	}
+	// The content still comes from the task's template
+	repairPrompt.Version, repairPrompt.TemplateID = prompt.Version, prompt.TemplateID
+
+	base.Repair = true
+	reply, repaired, problems, err := s.callLLM(ctx, base, repairPrompt, schema, check)
+	if err != nil {
+		return "", nil, fmt.Errorf("LLM repair failed: %w", err)
+	}
+	if len(problems) > 0 {
+		return "", nil, fmt.Errorf("%w: %s", errInvalidReply, strings.Join(problems, "; "))
+	}
+
+	if !repaired.row.Cached {
+		llm.RecordRepairedReply(repaired.row.Provider, repaired.row.ModelName)
+	}
+	return reply, &repaired.row, nil
+}
+
+// callLLM makes and records one call. A reply that fails validation is not
+// an error; its problems are returned instead.
+func (s *ParserService) callLLM(ctx context.Context, base models.AIPrompt, prompt prompts.Prompt, schema *llm.Schema, check func(string) []string) (string, *promptCall, []string, error) {
+	if timeout, ok := callTimeouts[llm.Task(base.Task)]; ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx = llm.WithCheck(ctx, check)
+	if base.Attempt > 1 || base.Repair {
+		// A cached reply is what the earlier attempt already got
+		ctx = llm.WithoutCachedReply(ctx)
+	}
+	ctx, answeredBy := llm.WithCallInfo(ctx)
+
+	call := newLLMCall(base, prompt, schema)
+	raw, err := s.llm.GenerateJSON(ctx, prompt.System, prompt.User, schema)
+	call.answered(answeredBy, raw)
+	if err != nil {
+		s.savePrompt(ctx, call, err)
+		return "", nil, nil, err
+	}
+
+	reply, problems := llm.Validate(raw, schema)
+	if len(problems) == 0 && check != nil {
+		problems = check(reply)
+	}
+
+	if len(problems) > 0 {
+		call.row.Status = enums.PromptInvalid.ToString()
+		call.row.Error = strings.Join(problems, "\n")
+		if !answeredBy.Cached {
+			llm.RecordInvalidReply(answeredBy.Provider, answeredBy.Model)
+		}
+	}
+	s.savePrompt(ctx, call, nil)
+	return reply, call, problems, nil
+}
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 
 	"github.com/Mahaveer86619/bookture/server/pkg/enums"
 	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
 )
 
 type ParsedVolume struct {
@@ -73,10 +76,10 @@ type EPUBItemRef struct {
 }
 
 type LLMMetadataResponse struct {
-	Title       string `json:"title"`
-	Author      string `json:"author"`
-	Description string `json:"description"`
-	Genre       string `json:"genre,omitempty"`
+	Title       string `json:"title" desc:"The title of the book"`
+	Author      string `json:"author" desc:"The author's name"`
+	Description string `json:"description" desc:"A brief description or summary of the book (2-3 sentences)"`
+	Genre       string `json:"genre,omitempty" desc:"The primary genre of the book"`
 }
 
 type LLMChapterResponse struct {
@@ -88,8 +91,8 @@ type LLMChapter struct {
 	Title  string `json:"title"`
 }
 
-func (s *ParserService) updateVolumeStatus(volumeID uint, status enums.VolumeStatus, progress int) {
-	s.db.Model(&models.Volume{}).Where("id = ?", volumeID).Updates(map[string]interface{}{
+func (s *ParserService) updateVolumeStatus(ctx context.Context, volumeID uint, status enums.VolumeStatus, progress int) {
+	s.db.WithContext(ctx).Model(&models.Volume{}).Where("id = ?", volumeID).Updates(map[string]interface{}{
 		"status":   status.ToString(),
 		"progress": progress,
 	})
@@ -104,10 +107,64 @@ func (s *ParserService) markVolumeError(volumeID uint, errorMsg string) {
 		"parsing_errors": string(errorsJSON),
 		"progress":       -1,
 	})
+
+	s.emitVolumeEvent(enums.WebhookVolumeError, volumeID, views.VolumeEventData{Error: errorMsg})
+}
+
+// markVolumeCancelled runs outside the job context, which is already done by now.
+func (s *ParserService) markVolumeCancelled(volumeID uint) {
+	s.db.Model(&models.Volume{}).Where("id = ?", volumeID).
+		Update("status", enums.VolumeCancelled.ToString())
+
+	s.emitVolumeEvent(enums.WebhookVolumeCancelled, volumeID, views.VolumeEventData{})
+}
+
+// emitVolumeEvent fills in the volume's book, title and status and emits the
+// event for the volume's owner.
+func (s *ParserService) emitVolumeEvent(event enums.WebhookEvent, volumeID uint, data views.VolumeEventData) {
+	if s.emitter == nil {
+		return
+	}
+
+	var owner struct {
+		UserID uint
+		BookID uint
+		Title  string
+		Status string
+	}
+	err := s.db.Model(&models.Volume{}).
+		Select("libraries.user_id, volumes.book_id, volumes.title, volumes.status").
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("volumes.id = ?", volumeID).
+		Scan(&owner).Error
+	if err != nil || owner.UserID == 0 {
+		log.Printf("Failed to emit %s for Volume %d: owner not found", event, volumeID)
+		return
+	}
+
+	data.VolumeID = utils.MaskID(volumeID)
+	data.BookID = utils.MaskID(owner.BookID)
+	data.Title = owner.Title
+	data.Status = owner.Status
+	s.emitter.Emit(owner.UserID, event, data)
 }
 
-func (s *ParserService) saveStructuredData(volume *models.Volume, parsed *ParsedVolume) error {
+// clearStructure hard-deletes chapters left by an earlier run so re-parsing does
+// not duplicate them. Sections and scenes go with them through the FK cascade.
+func (s *ParserService) clearStructure(ctx context.Context, volumeID uint) error {
+	err := s.db.WithContext(ctx).Unscoped().
+		Where("volume_id = ?", volumeID).
+		Delete(&models.Chapter{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to clear previous structure: %w", err)
+	}
+	return nil
+}
+
+func (s *ParserService) saveStructuredData(ctx context.Context, volume *models.Volume, parsed *ParsedVolume) error {
 	log.Printf("Saving structured data for Volume %d: %d chapters", volume.ID, len(parsed.Chapters))
+	db := s.db.WithContext(ctx)
 
 	// Save chapters and sections
 	for _, parsedChapter := range parsed.Chapters {
@@ -121,7 +178,7 @@ func (s *ParserService) saveStructuredData(volume *models.Volume, parsed *Parsed
 			WordCount:           parsedChapter.WordCount,
 		}
 
-		if err := s.db.Create(&chapter).Error; err != nil {
+		if err := db.Create(&chapter).Error; err != nil {
 			return fmt.Errorf("failed to create chapter: %w", err)
 		}
 
@@ -138,7 +195,7 @@ func (s *ParserService) saveStructuredData(volume *models.Volume, parsed *Parsed
 				HasAction:   parsedSection.HasAction,
 			}
 
-			if err := s.db.Create(&section).Error; err != nil {
+			if err := db.Create(&section).Error; err != nil {
 				return fmt.Errorf("failed to create section: %w", err)
 			}
 		}
@@ -146,7 +203,7 @@ func (s *ParserService) saveStructuredData(volume *models.Volume, parsed *Parsed
 
 	// Count sections
 	var sectionCount int64
-	s.db.Model(&models.Section{}).
+	db.Model(&models.Section{}).
 		Joins("JOIN chapters ON sections.chapter_id = chapters.id").
 		Where("chapters.volume_id = ?", volume.ID).
 		Count(&sectionCount)
@@ -162,19 +219,21 @@ func (s *ParserService) saveStructuredData(volume *models.Volume, parsed *Parsed
 		volume.ParsingErrors = string(errorsJSON)
 	}
 
-	return s.db.Save(volume).Error
+	return db.Save(volume).Error
 }
 
-func (s *ParserService) updateMetadata(volume *models.Volume, parsed *ParsedVolume) {
+func (s *ParserService) updateMetadata(ctx context.Context, volume *models.Volume, parsed *ParsedVolume) {
+	db := s.db.WithContext(ctx)
+
 	// Update volume title if detected
 	if parsed.DetectedTitle != "" && (volume.Title == "" || strings.Contains(volume.Title, filepath.Ext(volume.FilePath))) {
 		volume.Title = parsed.DetectedTitle
-		s.db.Save(volume)
+		db.Save(volume)
 	}
 
 	// Update book metadata if needed
 	var book models.Book
-	if err := s.db.First(&book, volume.BookID).Error; err == nil {
+	if err := db.First(&book, volume.BookID).Error; err == nil {
 		updated := false
 
 		// Update title
@@ -196,7 +255,7 @@ func (s *ParserService) updateMetadata(volume *models.Volume, parsed *ParsedVolu
 		}
 
 		if updated {
-			s.db.Save(&book)
+			db.Save(&book)
 			log.Printf("Updated Book %d metadata from Volume %d", book.ID, volume.ID)
 		}
 	}
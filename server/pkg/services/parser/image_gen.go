@@ -1,76 +1,124 @@
 package parser
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/gen_image"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/progress"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
 )
 
-func (s *ParserService) generateImagesForVolume(volumeID uint, reportProgress func(int)) error {
+// generateImagesForVolume returns the number of scene images it stored.
+func (s *ParserService) generateImagesForVolume(ctx context.Context, volumeID uint, report *progress.Reporter) (int, error) {
 	log.Printf("Generating images for Volume %d", volumeID)
+	db := s.db.WithContext(ctx)
+
+	var volume models.Volume
+	if err := db.Select("id", "book_id").First(&volume, volumeID).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch volume: %w", err)
+	}
+
+	defaults := gen_image.BookDefaults(volume.BookID)
 
 	// Fetch all scenes that need images
 	var scenes []models.Scene
-	if err := s.db.Joins("JOIN sections ON sections.id = scenes.section_id").
+	if err := db.Joins("JOIN sections ON sections.id = scenes.section_id").
 		Joins("JOIN chapters ON chapters.id = sections.chapter_id").
-		Where("chapters.volume_id = ? AND scenes.image_url IS NULL", volumeID).
+		Where("chapters.volume_id = ? AND (scenes.image_url IS NULL OR scenes.image_url = '')", volumeID).
 		Order("chapters.chapter_no ASC, sections.section_no ASC").
 		Find(&scenes).Error; err != nil {
-		return fmt.Errorf("failed to fetch scenes: %w", err)
+		return 0, fmt.Errorf("failed to fetch scenes: %w", err)
 	}
 
 	if len(scenes) == 0 {
 		log.Printf("No scenes found requiring images for volume %d", volumeID)
-		return nil
+		return 0, nil
+	}
+
+	// Scenes show their characters as the book describes them
+	cast, err := s.loadCast(ctx, volume.BookID, scenes)
+	if err != nil {
+		report.Warn("Character descriptions left out of image prompts: %v", err)
+		cast = &bookCast{}
 	}
 
 	totalScenes := len(scenes)
 	baseProgress := 60  // Starting at 60%
 	progressRange := 35 // 60% to 95%
+	imagesStored := 0
 
 	for i, scene := range scenes {
+		if err := ctx.Err(); err != nil {
+			return imagesStored, err
+		}
+
 		// Generate image with retry
-		imageBase64, err := s.generateImageWithRetry(scene.ImagePrompt)
+		req := defaults
+		req.Prompt = scene.ImagePrompt
+		req.Mood = scene.Mood
+		req.Caption = scene.Caption
+		req = cast.dress(req, scene)
+		result, err := s.generateImageWithRetry(ctx, scene.ID, req, report)
 		if err != nil {
-			log.Printf("Failed to generate image for Scene %d: %v", scene.ID, err)
+			if ctx.Err() != nil {
+				return imagesStored, ctx.Err()
+			}
 			// Continue with next scene instead of failing
+			report.Warn("Failed to generate image for Scene %d: %v", scene.ID, err)
+			continue
+		}
+
+		if len(result.Bytes) == 0 {
+			// The provider made no image
 			continue
 		}
 
-		// Update scene with image
-		scene.ImageURL = imageBase64 // Store base64 for now
-		if err := s.db.Save(&scene).Error; err != nil {
-			log.Printf("Failed to save image for Scene %d: %v", scene.ID, err)
+		if err := s.assets.SaveSceneImage(ctx, volume.BookID, volumeID, &scene, result.Bytes); err != nil {
+			report.Warn("Failed to save image for Scene %d: %v", scene.ID, err)
 			continue
 		}
+		imagesStored++
 
 		// Update progress
 		currentProgress := baseProgress + ((i + 1) * progressRange / totalScenes)
-		reportProgress(currentProgress)
+		report.Progress(currentProgress)
+		report.Image(imagesStored, totalScenes)
 	}
 
-	return nil
+	return imagesStored, nil
 }
 
-func (s *ParserService) generateImageWithRetry(prompt string) (string, error) {
+func (s *ParserService) generateImageWithRetry(ctx context.Context, sceneID uint, req gen_image.ImageRequest, report *progress.Reporter) (*gen_image.ImageResult, error) {
 	var lastErr error
 
 	for attempt := 1; attempt <= s.maxRetries; attempt++ {
-		imageBase64, err := s.imageGen.GenerateImage(prompt)
+		report.Call(progress.CallImage)
+		call := newImageCall(sceneID, attempt, req.Prompt)
+		result, err := s.imageGen.GenerateImage(ctx, req)
+		call.imageAnswered(req, result)
+		s.savePrompt(ctx, call, err)
 		if err == nil {
-			return imageBase64, nil
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 
 		lastErr = err
-		log.Printf("Image generation attempt %d/%d failed: %v", attempt, s.maxRetries, err)
 
 		if attempt < s.maxRetries {
+			report.Retry(attempt, s.maxRetries, err)
+
 			// Exponential backoff
-			time.Sleep(s.retryDelay * time.Duration(attempt))
+			if err := utils.SleepCtx(ctx, s.retryDelay*time.Duration(attempt)); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	return "", fmt.Errorf("failed after %d attempts: %w", s.maxRetries, lastErr)
+	return nil, fmt.Errorf("failed after %d attempts: %w", s.maxRetries, lastErr)
 }
@@ -3,26 +3,39 @@ package parser
 import (
 	"time"
 
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
 	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/assets"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/events"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/gen_image"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
 	"gorm.io/gorm"
 )
 
 type ParserService struct {
 	db         *gorm.DB
 	llm        llm.LLMService
+	prompts    *prompts.Registry
 	imageGen   gen_image.ImageService
+	assets     *assets.Store
+	emitter    events.Emitter
 	maxRetries int
 	retryDelay time.Duration
+	sceneBatch int // most chapters in one scene request
 }
 
-func NewParserService(llmService llm.LLMService, imageService gen_image.ImageService) *ParserService {
+func NewParserService(llmService llm.LLMService, imageService gen_image.ImageService, ss storage.StorageService, emitter events.Emitter) *ParserService {
 	return &ParserService{
 		db:         db.GetBooktureDB().DB,
 		llm:        llmService,
+		prompts:    prompts.NewRegistry(),
 		imageGen:   imageService,
+		assets:     assets.NewStore(ss),
+		emitter:    emitter,
 		maxRetries: 3,
 		retryDelay: 5 * time.Second,
+		sceneBatch: config.AppConfig.LLM_SCENE_BATCH,
 	}
 }
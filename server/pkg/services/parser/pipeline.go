@@ -1,93 +1,229 @@
 package parser
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/enums"
 	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/progress"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
 	"gorm.io/gorm"
 )
 
-func (s *ParserService) ProcessVolumeComplete(volumeID uint, reportProgress func(int)) {
+// ErrInterrupted is the cancel cause used when a job is stopped by a shutdown
+// rather than by its user. The volume goes back to "uploaded" so it is picked
+// up again.
+var ErrInterrupted = errors.New("interrupted by shutdown")
+
+// ProcessVolumeComplete runs every stage for a volume. When ctx is cancelled the
+// volume is marked cancelled and whatever was already generated is kept.
+func (s *ParserService) ProcessVolumeComplete(ctx context.Context, volumeID uint, report *progress.Reporter) error {
 	log.Printf("Starting complete processing pipeline for Volume %d", volumeID)
 
+	err := s.processVolume(ctx, volumeID, report)
+	if err != nil && errors.Is(context.Cause(ctx), ErrInterrupted) {
+		log.Printf("Volume %d processing interrupted, it will be resumed", volumeID)
+		s.updateVolumeStatus(context.WithoutCancel(ctx), volumeID, enums.VolumeUploaded, 0)
+		return ctx.Err()
+	}
+	if err != nil && ctx.Err() != nil {
+		log.Printf("Volume %d processing cancelled", volumeID)
+		s.markVolumeCancelled(volumeID)
+		return ctx.Err()
+	}
+	return err
+}
+
+// ResumeVolume finishes a volume whose structure was saved before it failed:
+// it generates scenes for the chapters that have none yet and images for
+// the scenes without one, keeping everything already generated. An
+// interrupted resume leaves the volume in error so it is resumed again
+// rather than parsed from scratch.
+func (s *ParserService) ResumeVolume(ctx context.Context, volumeID uint, report *progress.Reporter) error {
+	log.Printf("Resuming processing of Volume %d", volumeID)
+
+	var volume models.Volume
+	if err := s.db.WithContext(ctx).Preload("Book").First(&volume, volumeID).Error; err != nil {
+		return fmt.Errorf("failed to fetch volume: %w", err)
+	}
+	ctx = llm.WithBook(ctx, volume.BookID)
+
+	err := s.enhanceVolume(ctx, &volume, report)
+	if err != nil && errors.Is(context.Cause(ctx), ErrInterrupted) {
+		log.Printf("Volume %d resume interrupted", volumeID)
+		s.updateVolumeStatus(context.WithoutCancel(ctx), volumeID, enums.VolumeError, -1)
+		return ctx.Err()
+	}
+	if err != nil && ctx.Err() != nil {
+		log.Printf("Volume %d processing cancelled", volumeID)
+		s.markVolumeCancelled(volumeID)
+		return ctx.Err()
+	}
+	return err
+}
+
+func (s *ParserService) processVolume(ctx context.Context, volumeID uint, report *progress.Reporter) error {
+	db := s.db.WithContext(ctx)
+
 	// Phase 1: Parse Structure (0-30%)
-	s.updateVolumeStatus(volumeID, enums.VolumeParsing, 0)
-	reportProgress(5)
+	s.updateVolumeStatus(ctx, volumeID, enums.VolumeParsing, 0)
+	report.Stage(enums.StageParsing, 5)
 
 	var volume models.Volume
-	if err := s.db.Preload("Book").First(&volume, volumeID).Error; err != nil {
+	if err := db.Preload("Book").First(&volume, volumeID).Error; err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		s.markVolumeError(volumeID, "Failed to fetch volume")
-		return
+		return fmt.Errorf("failed to fetch volume: %w", err)
+	}
+	ctx = llm.WithBook(ctx, volume.BookID)
+
+	// A reconciled volume may have been interrupted halfway through parsing
+	if err := s.clearStructure(ctx, volumeID); err != nil {
+		return err
 	}
 
 	parsed, err := s.parseFileStructure(&volume)
 	if err != nil {
 		s.markVolumeError(volumeID, err.Error())
-		return
+		return err
+	}
+	for _, parseErr := range parsed.Errors {
+		report.Warn("Volume %d: %s", volumeID, parseErr)
 	}
-	reportProgress(20)
 
 	if parsed.DetectedTitle == "" || parsed.DetectedAuthor == "" {
-		s.enhanceMetadataWithLLM(parsed, &volume)
+		report.Stage(enums.StageMetadata, 20)
+		report.Call(progress.CallLLM)
+		s.enhanceMetadataWithLLM(ctx, parsed, &volume)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	reportProgress(25)
+	report.Stage(enums.StageStructure, 25)
 
-	if err := s.saveStructuredData(&volume, parsed); err != nil {
+	if err := s.saveStructuredData(ctx, &volume, parsed); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		s.markVolumeError(volumeID, err.Error())
-		return
+		return err
 	}
-	s.updateMetadata(&volume, parsed)
-	reportProgress(30)
+	s.updateMetadata(ctx, &volume, parsed)
+	s.emitVolumeEvent(enums.WebhookVolumeParsed, volumeID, views.VolumeEventData{
+		Chapters: volume.ChapterCount,
+		Sections: volume.SectionCount,
+	})
+
+	return s.enhanceVolume(ctx, &volume, report)
+}
+
+// enhanceVolume runs the scene and image stages over a parsed volume and
+// marks it completed. Chapters that already have their scenes and scenes
+// that already have an image are skipped.
+func (s *ParserService) enhanceVolume(ctx context.Context, volume *models.Volume, report *progress.Reporter) error {
+	db := s.db.WithContext(ctx)
+	volumeID := volume.ID
 
 	// Phase 2: Generate Scenes with LLM (30-60%)
-	s.updateVolumeStatus(volumeID, enums.VolumeEnhancing, 30)
+	s.updateVolumeStatus(ctx, volumeID, enums.VolumeEnhancing, 30)
+	report.Stage(enums.StageScenes, 30)
 
-	if err := s.generateScenesForVolume(volumeID, reportProgress); err != nil {
+	sceneCount, err := s.generateScenesForVolume(ctx, volumeID, report)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		log.Printf("Scene generation failed: %v", err)
 		s.markVolumeError(volumeID, fmt.Sprintf("Scene generation failed: %v", err))
-		return
+		return err
 	}
-	reportProgress(60)
 
 	// Phase 3: Generate Images (60-95%)
-	if err := s.generateImagesForVolume(volumeID, reportProgress); err != nil {
-		log.Printf("Image generation failed: %v", err)
+	report.Stage(enums.StageImages, 60)
+	imageCount, err := s.generateImagesForVolume(ctx, volumeID, report)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		// Don't fail the entire process if images fail
-		log.Printf("Continuing despite image generation errors")
+		report.Warn("Image generation failed: %v. Continuing despite image generation errors", err)
 	}
-	reportProgress(95)
+	report.Progress(95)
 
 	// Mark as completed
 	now := time.Now()
 	volume.Status = enums.VolumeCompleted.ToString()
 	volume.CompletedAt = &now
 	volume.Progress = 100
-	s.db.Save(&volume)
+	if err := db.Save(volume).Error; err != nil {
+		return err
+	}
 
-	reportProgress(100)
+	report.SetResult(views.VolumeResultView{
+		VolumeID:        utils.MaskID(volumeID),
+		Chapters:        volume.ChapterCount,
+		Sections:        volume.SectionCount,
+		ScenesGenerated: sceneCount,
+		ImagesGenerated: imageCount,
+	})
+	s.emitVolumeEvent(enums.WebhookVolumeCompleted, volumeID, views.VolumeEventData{
+		Chapters:        volume.ChapterCount,
+		Sections:        volume.SectionCount,
+		ScenesGenerated: sceneCount,
+		ImagesGenerated: imageCount,
+	})
+	report.Progress(100)
 	log.Printf("Volume %d processing completed successfully", volumeID)
+	return nil
 }
 
-func (s *ParserService) generateScenesForVolume(volumeID uint, reportProgress func(int)) error {
+// generateScenesForVolume returns the number of scenes it created.
+func (s *ParserService) generateScenesForVolume(ctx context.Context, volumeID uint, report *progress.Reporter) (int, error) {
 	log.Printf("Generating scenes for Volume %d", volumeID)
+	db := s.db.WithContext(ctx)
+
+	// The book selects the prompt templates
+	var volume models.Volume
+	if err := db.Select("id", "book_id").Preload("Book").First(&volume, volumeID).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch volume: %w", err)
+	}
 
 	// Fetch all chapters with sections
 	var chapters []models.Chapter
-	if err := s.db.Where("volume_id = ?", volumeID).
+	if err := db.Where("volume_id = ?", volumeID).
 		Order("chapter_no ASC").
 		Preload("Sections", func(db *gorm.DB) *gorm.DB {
 			return db.Order("section_no ASC")
 		}).
 		Find(&chapters).Error; err != nil {
-		return fmt.Errorf("failed to fetch chapters: %w", err)
+		return 0, fmt.Errorf("failed to fetch chapters: %w", err)
 	}
 
 	if len(chapters) == 0 {
-		return fmt.Errorf("no chapters found for volume %d", volumeID)
+		return 0, fmt.Errorf("no chapters found for volume %d", volumeID)
+	}
+
+	// Chapters completed by an earlier run keep their scenes
+	pending := chapters[:0]
+	for _, ch := range chapters {
+		if ch.Status != enums.ChapterCompleted.ToString() {
+			pending = append(pending, ch)
+		}
+	}
+	chapters = pending
+	if len(chapters) == 0 {
+		return 0, nil
 	}
 
 	totalSections := 0
@@ -96,120 +232,220 @@ func (s *ParserService) generateScenesForVolume(volumeID uint, reportProgress fu
 	}
 
 	processedSections := 0
+	processedChapters := 0
+	scenesCreated := 0
 	baseProgress := 30  // Starting at 30%
 	progressRange := 30 // 30% to 60%
 
-	// Process each chapter
-	for _, chapter := range chapters {
-		if len(chapter.Sections) == 0 {
-			continue
-		}
-
-		// Generate scenes for this chapter with retry
-		scenes, err := s.generateScenesForChapterWithRetry(chapter)
-		if err != nil {
-			log.Printf("Failed to generate scenes for Chapter %d: %v", chapter.ID, err)
-			// Continue with next chapter instead of failing entirely
-			continue
+	budget := s.sceneBudget(ctx, chapters)
+	for _, batch := range batchChapters(chapters, budget, s.sceneBatch) {
+		if err := ctx.Err(); err != nil {
+			return scenesCreated, err
 		}
 
-		// Save scenes to database
-		for _, scene := range scenes {
-			// Find corresponding section
-			var section *models.Section
-			for i := range chapter.Sections {
-				if chapter.Sections[i].SectionNo == scene.SectionNumber {
-					section = &chapter.Sections[i]
-					break
+		// Chapters missing from a failed batch are sent on their own below
+		var batched map[uint][]chapterScene
+		if len(batch) > 1 {
+			report.Call(progress.CallLLM)
+			var err error
+			batched, err = s.generateSceneBatch(ctx, &volume.Book, batch)
+			if err != nil {
+				if ctx.Err() != nil {
+					return scenesCreated, ctx.Err()
 				}
+				report.Warn("Failed to generate scenes for Chapters %d to %d together, retrying one by one: %v",
+					batch[0].ChapterNo, batch[len(batch)-1].ChapterNo, err)
 			}
+		}
 
-			if section == nil {
-				log.Printf("Section %d not found for scene", scene.SectionNumber)
+		for _, chapter := range batch {
+			processedChapters++
+			if len(chapter.Sections) == 0 {
 				continue
 			}
 
-			// Create scene record
-			sceneModel := models.Scene{
-				SectionID:       section.ID,
-				Summary:         scene.Summary,
-				ImagePrompt:     scene.ImagePrompt,
-				ImportanceScore: scene.ImportanceScore,
-				SceneType:       scene.SceneType,
-				Characters:      strings.Join(scene.Characters, ","),
-				Location:        scene.Location,
-				Mood:            scene.Mood,
-				Status:          enums.SectionCompleted.ToString(),
+			scenes, ok := batched[chapter.ID]
+			if !ok {
+				// Generate scenes for this chapter with retry
+				var err error
+				scenes, err = s.generateScenesForChapterWithRetry(ctx, &volume.Book, chapter, budget, report)
+				if err != nil {
+					if ctx.Err() != nil {
+						return scenesCreated, ctx.Err()
+					}
+					// Continue with next chapter instead of failing entirely
+					report.Warn("Failed to generate scenes for Chapter %d: %v", chapter.ID, err)
+					continue
+				}
 			}
 
-			if err := s.db.Create(&sceneModel).Error; err != nil {
-				log.Printf("Failed to save scene: %v", err)
-				continue
+			scenesCreated += s.saveChapterScenes(ctx, &chapter, scenes, report)
+
+			// Update progress
+			processedSections += len(chapter.Sections)
+			currentProgress := baseProgress + (processedSections * progressRange / totalSections)
+			report.Progress(currentProgress)
+			report.Chapter(processedChapters, len(chapters), scenesCreated)
+		}
+	}
+
+	return scenesCreated, nil
+}
+
+// saveChapterScenes replaces the scenes of a chapter with scenes, marks it
+// completed and returns how many scenes were saved. The old scenes are
+// deleted in the same transaction the new ones are written in, so a run
+// stopped halfway leaves each chapter with either its old or its new
+// scenes.
+func (s *ParserService) saveChapterScenes(ctx context.Context, chapter *models.Chapter, scenes []chapterScene, report *progress.Reporter) int {
+	sceneModels := make([]models.Scene, 0, len(scenes))
+	completed := make(map[uint]bool)
+	for _, scene := range scenes {
+		// Find corresponding section
+		var section *models.Section
+		for i := range chapter.Sections {
+			if chapter.Sections[i].SectionNo == scene.SectionNumber {
+				section = &chapter.Sections[i]
+				break
 			}
+		}
 
-			// Update section status
-			section.Status = enums.SectionCompleted.ToString()
-			s.db.Save(section)
+		if section == nil {
+			report.Warn("Section %d not found for scene in Chapter %d", scene.SectionNumber, chapter.ChapterNo)
+			continue
 		}
 
-		// Update chapter status
-		chapter.Status = enums.ChapterCompleted.ToString()
-		s.db.Save(&chapter)
+		// Create scene record
+		sceneModel := models.Scene{
+			SectionID:       section.ID,
+			Summary:         scene.Summary,
+			ImagePrompt:     scene.ImagePrompt,
+			ImportanceScore: scene.ImportanceScore,
+			SceneType:       scene.SceneType,
+			Characters:      strings.Join(scene.Characters, ","),
+			Location:        scene.Location,
+			Mood:            scene.Mood,
+			Status:          enums.SectionCompleted.ToString(),
+			LLMProvider:     scene.source.Provider,
+			LLMModel:        scene.source.ModelName,
+			PromptVersion:   scene.source.TemplateVersion,
+		}
+		if scene.source.ID != 0 {
+			sceneModel.SourcePromptID = &scene.source.ID
+		}
+		sceneModels = append(sceneModels, sceneModel)
+		completed[section.ID] = true
+	}
 
-		// Update progress
-		processedSections += len(chapter.Sections)
-		currentProgress := baseProgress + (processedSections * progressRange / totalSections)
-		reportProgress(currentProgress)
+	sectionIDs := make([]uint, len(chapter.Sections))
+	for i, section := range chapter.Sections {
+		sectionIDs[i] = section.ID
 	}
+	completedIDs := slices.Collect(maps.Keys(completed))
 
-	return nil
+	var replaced []uint
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.Scene{}).Where("section_id IN ?", sectionIDs).Pluck("id", &replaced).Error; err != nil {
+			return err
+		}
+		if len(replaced) > 0 {
+			if err := tx.Unscoped().Delete(&models.Scene{}, replaced).Error; err != nil {
+				return err
+			}
+		}
+		if len(sceneModels) > 0 {
+			if err := tx.Create(&sceneModels).Error; err != nil {
+				return err
+			}
+		}
+		if len(completedIDs) > 0 {
+			if err := tx.Model(&models.Section{}).Where("id IN ?", completedIDs).
+				Update("status", enums.SectionCompleted.ToString()).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(chapter).Update("status", enums.ChapterCompleted.ToString()).Error
+	})
+	if err != nil {
+		report.Warn("Failed to save scenes of Chapter %d: %v", chapter.ChapterNo, err)
+		return 0
+	}
+
+	// The replaced scenes' images go once their scenes are gone, even when
+	// the run is being stopped
+	if err := s.assets.DeleteImagesOf(context.WithoutCancel(ctx), replaced); err != nil {
+		log.Printf("Failed to delete replaced images of Chapter %d: %v", chapter.ID, err)
+	}
+	return len(sceneModels)
 }
 
-func (s *ParserService) RetrySceneGeneration(volumeID uint, reportProgress func(int)) error {
+// RetrySceneGeneration generates the scenes of every chapter again. Each
+// chapter's scenes are only replaced once its new ones are ready, so a
+// cancelled run keeps the old scenes of the chapters it did not reach and
+// marks the volume cancelled.
+func (s *ParserService) RetrySceneGeneration(ctx context.Context, volumeID uint, report *progress.Reporter) error {
 	log.Printf("Retrying scene generation for Volume %d", volumeID)
 
-	// Delete existing scenes that failed or are incomplete
-	if err := s.db.Exec(`
-		DELETE FROM scenes 
-		WHERE section_id IN (
-			SELECT sections.id FROM sections
-			JOIN chapters ON chapters.id = sections.chapter_id
-			WHERE chapters.volume_id = ?
-		)
-	`, volumeID).Error; err != nil {
-		return fmt.Errorf("failed to clear existing scenes: %w", err)
+	err := s.retryScenes(ctx, volumeID, report)
+	switch {
+	case err != nil && errors.Is(context.Cause(ctx), ErrInterrupted):
+		// Left in error so it is resumed from the chapters still pending
+		log.Printf("Volume %d scene retry interrupted", volumeID)
+		s.updateVolumeStatus(context.WithoutCancel(ctx), volumeID, enums.VolumeError, -1)
+		return ctx.Err()
+	case err != nil && ctx.Err() != nil:
+		log.Printf("Volume %d scene retry cancelled", volumeID)
+		s.markVolumeCancelled(volumeID)
+		return ctx.Err()
+	case err != nil:
+		s.markVolumeError(volumeID, fmt.Sprintf("Scene generation failed: %v", err))
+		return err
+	}
+	return nil
+}
+
+func (s *ParserService) retryScenes(ctx context.Context, volumeID uint, report *progress.Reporter) error {
+	db := s.db.WithContext(ctx)
+
+	var volume models.Volume
+	if err := db.Select("id", "book_id").First(&volume, volumeID).Error; err != nil {
+		return fmt.Errorf("failed to fetch volume: %w", err)
 	}
+	ctx = llm.WithBook(ctx, volume.BookID)
 
-	// Reset section and chapter status
-	s.db.Exec(`
+	// Every chapter is pending again; its scenes stay until replaced
+	if err := db.Exec(`
 		UPDATE sections SET status = ?
 		WHERE chapter_id IN (
 			SELECT id FROM chapters WHERE volume_id = ?
 		)
-	`, enums.SectionParsed.ToString(), volumeID)
-
-	s.db.Exec(`
+	`, enums.SectionParsed.ToString(), volumeID).Error; err != nil {
+		return fmt.Errorf("failed to reset sections: %w", err)
+	}
+	if err := db.Exec(`
 		UPDATE chapters SET status = ?
 		WHERE volume_id = ?
-	`, enums.ChapterParsed.ToString(), volumeID)
+	`, enums.ChapterParsed.ToString(), volumeID).Error; err != nil {
+		return fmt.Errorf("failed to reset chapters: %w", err)
+	}
+	s.updateVolumeStatus(ctx, volumeID, enums.VolumeEnhancing, 30)
 
-	// Re-run scene generation
-	return s.generateScenesForVolume(volumeID, reportProgress)
+	if _, err := s.generateScenesForVolume(ctx, volumeID, report); err != nil {
+		return err
+	}
+	s.updateVolumeStatus(ctx, volumeID, enums.VolumeCompleted, 100)
+	return nil
 }
 
-func (s *ParserService) RetryImageGeneration(volumeID uint, reportProgress func(int)) error {
+func (s *ParserService) RetryImageGeneration(ctx context.Context, volumeID uint, report *progress.Reporter) error {
 	log.Printf("Retrying image generation for Volume %d", volumeID)
 
 	// Clear existing images
-	s.db.Exec(`
-		UPDATE scenes SET image_url = NULL
-		WHERE section_id IN (
-			SELECT sections.id FROM sections
-			JOIN chapters ON chapters.id = sections.chapter_id
-			WHERE chapters.volume_id = ?
-		)
-	`, volumeID)
+	if err := s.assets.DeleteSceneImages(ctx, volumeID); err != nil {
+		return err
+	}
 
 	// Re-run image generation
-	return s.generateImagesForVolume(volumeID, reportProgress)
+	_, err := s.generateImagesForVolume(ctx, volumeID, report)
+	return err
 }
@@ -0,0 +1,232 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/db/dbtest"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/assets"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/progress"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+// sceneLLM answers scene requests with one scene per call, calling before
+// first when set.
+type sceneLLM struct {
+	calls  atomic.Int32
+	before func(call int32) error
+}
+
+func (f *sceneLLM) Init() error        { return nil }
+func (f *sceneLLM) HealthCheck() error { return nil }
+
+func (f *sceneLLM) GenerateJSON(ctx context.Context, sysPrompt, userPrompt string, schema *llm.Schema) (string, error) {
+	call := f.calls.Add(1)
+	if f.before != nil {
+		if err := f.before(call); err != nil {
+			return "", err
+		}
+	}
+	return `{"scenes":[{"section_number":1,"summary":"New scene","importance_score":0.5,"scene_type":"action","image_prompt":"A new scene"}]}`, nil
+}
+
+// testVolume is a completed volume of chapters with one section each, and
+// an old scene with an image in every section.
+type testVolume struct {
+	volume   models.Volume
+	chapters []models.Chapter
+	scenes   []models.Scene
+}
+
+func newTestParser(t *testing.T, svc llm.LLMService) *ParserService {
+	t.Helper()
+	conn := dbtest.Open(t)
+
+	saved := config.AppConfig
+	config.AppConfig.STORAGE_DRIVER = "local"
+	config.AppConfig.STORAGE_PATH = t.TempDir()
+	t.Cleanup(func() { config.AppConfig = saved })
+
+	return &ParserService{
+		db:         conn,
+		llm:        svc,
+		prompts:    prompts.NewRegistry(),
+		assets:     assets.NewStore(storage.NewStorageService()),
+		maxRetries: 1,
+		sceneBatch: 1,
+	}
+}
+
+func seedVolume(t *testing.T, s *ParserService, chapters int) testVolume {
+	t.Helper()
+	ctx := context.Background()
+	conn := db.GetBooktureDB().DB
+
+	user := models.User{Email: "reader@example.com"}
+	conn.Create(&user)
+	library := models.Library{UserID: user.ID, Name: "Shelf"}
+	conn.Create(&library)
+	book := models.Book{LibraryID: library.ID, Title: "Dune"}
+	conn.Create(&book)
+
+	tv := testVolume{volume: models.Volume{BookID: book.ID, Status: enums.VolumeCompleted.ToString(), ChapterCount: chapters}}
+	if err := conn.Create(&tv.volume).Error; err != nil {
+		t.Fatalf("create volume: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= chapters; i++ {
+		chapter := models.Chapter{
+			VolumeID:  tv.volume.ID,
+			ChapterNo: i,
+			Status:    enums.ChapterCompleted.ToString(),
+			Sections: []models.Section{{
+				SectionNo: 1,
+				Status:    enums.SectionCompleted.ToString(),
+				CleanText: strings.Repeat("The spice must flow. ", 20),
+				WordCount: 80,
+			}},
+		}
+		if err := conn.Create(&chapter).Error; err != nil {
+			t.Fatalf("create chapter: %v", err)
+		}
+		tv.chapters = append(tv.chapters, chapter)
+
+		scene := models.Scene{SectionID: chapter.Sections[0].ID, Summary: "Old scene"}
+		if err := conn.Create(&scene).Error; err != nil {
+			t.Fatalf("create scene: %v", err)
+		}
+		if err := s.assets.SaveSceneImage(ctx, book.ID, tv.volume.ID, &scene, buf.Bytes()); err != nil {
+			t.Fatalf("save image: %v", err)
+		}
+		tv.scenes = append(tv.scenes, scene)
+	}
+	return tv
+}
+
+// summaries returns the scene summaries of each chapter, in order.
+func summaries(t *testing.T, tv testVolume) [][]string {
+	t.Helper()
+	out := make([][]string, len(tv.chapters))
+	for i, ch := range tv.chapters {
+		err := db.GetBooktureDB().DB.Model(&models.Scene{}).
+			Where("section_id = ?", ch.Sections[0].ID).
+			Order("id ASC").
+			Pluck("summary", &out[i]).Error
+		if err != nil {
+			t.Fatalf("load scenes: %v", err)
+		}
+	}
+	return out
+}
+
+func countAssets(t *testing.T, sceneID uint) int64 {
+	t.Helper()
+	var n int64
+	db.GetBooktureDB().DB.Model(&models.Asset{}).
+		Where("owner_type = ? AND owner_id = ?", enums.AssetOwnerScene.ToString(), sceneID).
+		Count(&n)
+	return n
+}
+
+func TestSaveChapterScenesReplacesOldScenes(t *testing.T) {
+	s := newTestParser(t, &sceneLLM{})
+	tv := seedVolume(t, s, 1)
+	report := progress.NewReporter(func(views.TaskEvent) {})
+
+	chapter := tv.chapters[0]
+	saved := s.saveChapterScenes(context.Background(), &chapter, []chapterScene{
+		{GeneratedScene: views.GeneratedScene{SectionNumber: 1, Summary: "First"}, source: &models.AIPrompt{}},
+		{GeneratedScene: views.GeneratedScene{SectionNumber: 1, Summary: "Second"}, source: &models.AIPrompt{}},
+		{GeneratedScene: views.GeneratedScene{SectionNumber: 9, Summary: "Nowhere"}, source: &models.AIPrompt{}},
+	}, report)
+
+	if saved != 2 {
+		t.Errorf("saved %d scenes, want 2", saved)
+	}
+	if got := summaries(t, tv)[0]; len(got) != 2 || got[0] != "First" || got[1] != "Second" {
+		t.Errorf("scenes = %q, want the new ones only", got)
+	}
+	if n := countAssets(t, tv.scenes[0].ID); n != 0 {
+		t.Errorf("replaced scene still has %d image assets", n)
+	}
+}
+
+func TestRetrySceneGenerationCancelledKeepsScenes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The second chapter's request is cancelled by the user
+	fake := &sceneLLM{before: func(call int32) error {
+		if call == 2 {
+			cancel()
+			return context.Canceled
+		}
+		return nil
+	}}
+	s := newTestParser(t, fake)
+	tv := seedVolume(t, s, 3)
+
+	err := s.RetrySceneGeneration(ctx, tv.volume.ID, progress.NewReporter(func(views.TaskEvent) {}))
+	if err == nil {
+		t.Fatal("RetrySceneGeneration succeeded after being cancelled")
+	}
+
+	got := summaries(t, tv)
+	want := [][]string{{"New scene"}, {"Old scene"}, {"Old scene"}}
+	for i := range want {
+		if len(got[i]) != 1 || got[i][0] != want[i][0] {
+			t.Errorf("chapter %d scenes = %q, want %q", i+1, got[i], want[i])
+		}
+	}
+	if n := countAssets(t, tv.scenes[0].ID); n != 0 {
+		t.Errorf("replaced scene still has %d image assets", n)
+	}
+	for _, scene := range tv.scenes[1:] {
+		if n := countAssets(t, scene.ID); n != 1 {
+			t.Errorf("scene %d kept %d image assets, want 1", scene.ID, n)
+		}
+	}
+
+	var volume models.Volume
+	db.GetBooktureDB().DB.First(&volume, tv.volume.ID)
+	if volume.Status != enums.VolumeCancelled.ToString() {
+		t.Errorf("volume status = %s, want %s", volume.Status, enums.VolumeCancelled)
+	}
+}
+
+func TestRetrySceneGenerationReplacesEveryChapter(t *testing.T) {
+	s := newTestParser(t, &sceneLLM{})
+	tv := seedVolume(t, s, 2)
+
+	if err := s.RetrySceneGeneration(context.Background(), tv.volume.ID, progress.NewReporter(func(views.TaskEvent) {})); err != nil {
+		t.Fatalf("RetrySceneGeneration: %v", err)
+	}
+
+	for i, got := range summaries(t, tv) {
+		if len(got) != 1 || got[0] != "New scene" {
+			t.Errorf("chapter %d scenes = %q, want the new scene", i+1, got)
+		}
+	}
+	var volume models.Volume
+	db.GetBooktureDB().DB.First(&volume, tv.volume.ID)
+	if volume.Status != enums.VolumeCompleted.ToString() || volume.Progress != 100 {
+		t.Errorf("volume status = %s at %d%%, want completed at 100%%", volume.Status, volume.Progress)
+	}
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/gen_image"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
+)
+
+// promptCall collects one LLM or image call for the audit log in ai_prompts.
+type promptCall struct {
+	row     models.AIPrompt
+	started time.Time
+}
+
+// newLLMCall starts recording a call on top of base, which carries the task,
+// attempt and what is being generated.
+func newLLMCall(base models.AIPrompt, prompt prompts.Prompt, schema *llm.Schema) *promptCall {
+	call := &promptCall{row: base, started: time.Now()}
+	call.row.Kind = enums.PromptLLM.ToString()
+	call.row.SystemPrompt = prompt.System
+	call.row.PromptText = prompt.User
+	call.row.TemplateVersion = prompt.Version
+	call.row.TemplateID = prompt.TemplateID
+	if schema != nil {
+		if schemaJSON, err := json.Marshal(schema.JSONSchema()); err == nil {
+			call.row.Schema = string(schemaJSON)
+		}
+	}
+	return call
+}
+
+func newImageCall(sceneID uint, attempt int, prompt string) *promptCall {
+	return &promptCall{
+		row: models.AIPrompt{
+			SceneID:    &sceneID,
+			Kind:       enums.PromptImage.ToString(),
+			Task:       "image",
+			Attempt:    attempt,
+			Provider:   config.AppConfig.IMAGE_PROVIDER,
+			ModelName:  config.AppConfig.IMAGE_MODEL,
+			PromptText: prompt,
+		},
+		started: time.Now(),
+	}
+}
+
+// answered stores the reply and what the provider reported about it.
+func (c *promptCall) answered(info *llm.CallInfo, response string) {
+	c.row.LatencyMs = time.Since(c.started).Milliseconds()
+	c.row.Response = response
+	if info == nil {
+		return
+	}
+	c.row.Provider = info.Provider
+	c.row.ModelName = info.Model
+	c.row.Cached = info.Cached
+	c.row.PromptTokens = info.PromptTokens
+	c.row.OutputTokens = info.OutputTokens
+	c.row.FinishReason = info.FinishReason
+}
+
+// imageAnswered stores the settings and seed of an image call, so the image
+// can be made again. The image itself is stored as an asset.
+func (c *promptCall) imageAnswered(req gen_image.ImageRequest, result *gen_image.ImageResult) {
+	c.row.LatencyMs = time.Since(c.started).Milliseconds()
+
+	req.Prompt = "" // Already in PromptText
+	record := map[string]any{"request": req}
+	if result != nil {
+		record["seed"] = result.Seed
+		record["mime"] = result.MIME
+		record["metadata"] = result.Metadata
+	}
+	if data, err := json.Marshal(record); err == nil {
+		c.row.Response = string(data)
+	}
+}
+
+// savePrompt writes the call with its outcome, leaving the row's ID zero when
+// it could not be written. The status follows err unless it is already set.
+// It runs even after ctx is cancelled so interrupted calls are kept too.
+func (s *ParserService) savePrompt(ctx context.Context, call *promptCall, err error) {
+	if call.row.LatencyMs == 0 {
+		call.row.LatencyMs = time.Since(call.started).Milliseconds()
+	}
+
+	switch {
+	case err != nil:
+		call.row.Status = enums.PromptFailed.ToString()
+		call.row.Error = err.Error()
+	case call.row.Status == "":
+		call.row.Status = enums.PromptCompleted.ToString()
+	}
+
+	if dbErr := s.db.WithContext(context.WithoutCancel(ctx)).Create(&call.row).Error; dbErr != nil {
+		log.Printf("Failed to record %s prompt: %v", call.row.Task, dbErr)
+	}
+}
+
+// promptScope is where prompt template overrides for book's volumes come
+// from.
+func promptScope(book *models.Book) prompts.Scope {
+	return prompts.Scope{LibraryID: book.LibraryID, BookID: book.ID}
+}
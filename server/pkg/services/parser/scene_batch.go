@@ -0,0 +1,164 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+// batchChapters groups runs of short chapters so they share one scene
+// request, which matters under per minute limits for poetry and plays. A
+// batch holds at most limit chapters whose text and sections together fit
+// budget. Long chapters and chapters without sections are batches of their
+// own.
+func batchChapters(chapters []models.Chapter, budget chunkBudget, limit int) [][]models.Chapter {
+	var batches [][]models.Chapter
+	var current []models.Chapter
+	tokens, sections := 0, 0
+	numbers := make(map[int]bool)
+	flush := func() {
+		if len(current) > 0 {
+			batches = append(batches, current)
+		}
+		current, tokens, sections = nil, 0, 0
+		clear(numbers)
+	}
+
+	for _, chapter := range chapters {
+		chunks := chunkChapter(chapter, budget)
+		if limit <= 1 || len(chunks) != 1 {
+			flush()
+			batches = append(batches, []models.Chapter{chapter})
+			continue
+		}
+
+		// Replies are matched to chapters by number, which must be unique
+		chapterTokens := llm.EstimateTokens(chunks[0].text)
+		if len(current) == limit || tokens+chapterTokens > budget.tokens ||
+			sections+len(chapter.Sections) > budget.sections || numbers[chapter.ChapterNo] {
+			flush()
+		}
+		current = append(current, chapter)
+		tokens += chapterTokens
+		sections += len(chapter.Sections)
+		numbers[chapter.ChapterNo] = true
+	}
+	flush()
+
+	return batches
+}
+
+// generateSceneBatch analyzes the chapters of a batch in one request and
+// returns their scenes by chapter ID. There is no retry: on failure the
+// chapters are sent one by one instead.
+func (eps *ParserService) generateSceneBatch(ctx context.Context, book *models.Book, chapters []models.Chapter) (map[uint][]chapterScene, error) {
+	data := prompts.SceneBatchData{
+		BookTitle: book.Title,
+		Author:    book.Author,
+		Chapters:  make([]prompts.BatchChapter, len(chapters)),
+	}
+	sections := make(map[int][]int, len(chapters))
+	for i, chapter := range chapters {
+		data.Chapters[i] = prompts.BatchChapter{
+			ChapterNo:    chapter.ChapterNo,
+			ChapterTitle: chapter.Title,
+			Text:         formatChapterText(chapter),
+		}
+		for _, section := range chapter.Sections {
+			sections[chapter.ChapterNo] = append(sections[chapter.ChapterNo], section.SectionNo)
+		}
+	}
+
+	prompt, err := eps.prompts.Render(ctx, prompts.TaskSceneBatch, promptScope(book), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render prompt: %w", err)
+	}
+
+	base := models.AIPrompt{
+		Task:     string(llm.TaskScenes),
+		Attempt:  1,
+		VolumeID: &chapters[0].VolumeID,
+	}
+	jsonResp, source, err := eps.generateJSON(ctx, base, promptScope(book), prompt, llm.SchemaFor(views.SceneBatchResponse{}), func(reply string) []string {
+		return checkSceneBatch(reply, sections)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response views.SceneBatchResponse
+	if err := json.Unmarshal([]byte(jsonResp), &response); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
+	}
+
+	byNumber := make(map[int]uint, len(chapters))
+	for _, chapter := range chapters {
+		byNumber[chapter.ChapterNo] = chapter.ID
+	}
+	results := make(map[uint][]chapterScene, len(chapters))
+	for _, entry := range response.Chapters {
+		chapterID := byNumber[entry.ChapterNumber]
+		for _, scene := range entry.Scenes {
+			results[chapterID] = append(results[chapterID], chapterScene{GeneratedScene: scene, source: source})
+		}
+	}
+	return results, nil
+}
+
+// formatChapterText is the text of a chapter that fits in one request.
+func formatChapterText(chapter models.Chapter) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Chapter %d: %s\n\n", chapter.ChapterNo, chapter.Title)
+	for _, section := range chapter.Sections {
+		fmt.Fprintf(&b, "Section %d:\n%s\n\n", section.SectionNo, section.CleanText)
+	}
+	return b.String()
+}
+
+// checkSceneBatch makes sure every chapter of the batch got scenes, once,
+// and only for its own sections. sections lists them by chapter number.
+func checkSceneBatch(reply string, sections map[int][]int) []string {
+	var response views.SceneBatchResponse
+	if err := json.Unmarshal([]byte(reply), &response); err != nil {
+		return []string{fmt.Sprintf("reply does not match the expected structure: %v", err)}
+	}
+
+	var problems []string
+	seen := make(map[int]bool, len(sections))
+	for i, entry := range response.Chapters {
+		sectionNos, ok := sections[entry.ChapterNumber]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("chapters[%d].chapter_number is %d, which was not given", i, entry.ChapterNumber))
+			continue
+		case seen[entry.ChapterNumber]:
+			problems = append(problems, fmt.Sprintf("chapters[%d] repeats chapter %d, put all its scenes in one entry", i, entry.ChapterNumber))
+			continue
+		}
+		seen[entry.ChapterNumber] = true
+
+		for _, problem := range sceneProblems(entry.Scenes, sectionNos) {
+			problems = append(problems, fmt.Sprintf("chapters[%d].%s", i, problem))
+		}
+	}
+
+	var missing []string
+	for _, chapterNo := range slices.Sorted(maps.Keys(sections)) {
+		if !seen[chapterNo] {
+			missing = append(missing, strconv.Itoa(chapterNo))
+		}
+	}
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("chapters %s have no entry", strings.Join(missing, ", ")))
+	}
+	return problems
+}
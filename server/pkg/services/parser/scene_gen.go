@@ -3,150 +3,255 @@ package parser
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/progress"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
 	"github.com/Mahaveer86619/bookture/server/pkg/views"
-	"google.golang.org/genai"
 )
 
-func (eps *ParserService) generateScenesForChapterWithRetry(chapter models.Chapter) ([]views.GeneratedScene, error) {
+func (eps *ParserService) generateScenesForChapterWithRetry(ctx context.Context, book *models.Book, chapter models.Chapter, budget chunkBudget, report *progress.Reporter) ([]chapterScene, error) {
 	var lastErr error
 
 	for attempt := 1; attempt <= eps.maxRetries; attempt++ {
-		scenes, err := eps.generateScenesForChapter(chapter)
+		report.Call(progress.CallLLM)
+		scenes, err := eps.generateScenesForChapter(ctx, book, chapter, budget, attempt)
 		if err == nil {
 			return scenes, nil
 		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 
 		lastErr = err
 
-		sleepDuration := eps.retryDelay * time.Duration(attempt)
-		if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "RESOURCE_EXHAUSTED") {
-			log.Printf("Rate limit hit. Waiting 60s before retry...")
-			sleepDuration = 60 * time.Second
-		}
-
 		if attempt < eps.maxRetries {
-			time.Sleep(sleepDuration)
+			report.Retry(attempt, eps.maxRetries, err)
+
+			sleepDuration := eps.retryDelay * time.Duration(attempt)
+			var rateLimited *llm.RateLimitError
+			if errors.As(err, &rateLimited) {
+				sleepDuration = rateLimited.RetryAfter
+				report.RateLimited(sleepDuration, fmt.Sprintf("Chapter %d", chapter.ChapterNo))
+			} else if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "RESOURCE_EXHAUSTED") {
+				sleepDuration = 60 * time.Second
+				report.RateLimited(sleepDuration, fmt.Sprintf("Chapter %d", chapter.ChapterNo))
+			}
+
+			if err := utils.SleepCtx(ctx, sleepDuration); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return nil, fmt.Errorf("failed after %d attempts: %w", eps.maxRetries, lastErr)
 }
 
-func (eps *ParserService) generateScenesForChapter(chapter models.Chapter) ([]views.GeneratedScene, error) {
-	// Build context from all sections
-	var textBuilder strings.Builder
-	textBuilder.WriteString(fmt.Sprintf("Chapter %d: %s\n\n", chapter.ChapterNo, chapter.Title))
-
-	for _, section := range chapter.Sections {
-		textBuilder.WriteString(fmt.Sprintf("Section %d:\n%s\n\n", section.SectionNo, section.CleanText))
-	}
-
-	chapterText := textBuilder.String()
-
-	// Limit context size (max ~8000 words)
-	words := strings.Fields(chapterText)
-	if len(words) > 8000 {
-		chapterText = strings.Join(words[:8000], " ") + "..."
-	}
-
-	// Define schema for LLM
-	schema := &genai.Schema{
-		Type: genai.TypeObject,
-		Properties: map[string]*genai.Schema{
-			"scenes": {
-				Type: genai.TypeArray,
-				Items: &genai.Schema{
-					Type: genai.TypeObject,
-					Properties: map[string]*genai.Schema{
-						"section_number": {
-							Type:        genai.TypeInteger,
-							Description: "The section number this scene belongs to",
-						},
-						"summary": {
-							Type:        genai.TypeString,
-							Description: "A 2-3 sentence summary of what happens in this scene",
-						},
-						"importance_score": {
-							Type:        genai.TypeNumber,
-							Description: "How important this scene is to the story (0.0 to 1.0)",
-						},
-						"scene_type": {
-							Type:        genai.TypeString,
-							Description: "Type of scene: action, dialogue, exposition, climax, resolution",
-						},
-						"image_prompt": {
-							Type:        genai.TypeString,
-							Description: "A detailed visual prompt for image generation, describing the scene, characters, setting, mood, and style",
-						},
-						"characters": {
-							Type: genai.TypeArray,
-							Items: &genai.Schema{
-								Type: genai.TypeString,
-							},
-							Description: "List of character names present in this scene",
-						},
-						"location": {
-							Type:        genai.TypeString,
-							Description: "Where the scene takes place",
-						},
-						"mood": {
-							Type:        genai.TypeString,
-							Description: "The emotional tone: tense, peaceful, joyful, dark, mysterious, etc.",
-						},
-					},
-					Required: []string{"section_number", "summary", "importance_score", "scene_type", "image_prompt"},
-				},
-			},
-		},
-		Required: []string{"scenes"},
-	}
-
-	sysPrompt := `You are a narrative analyst for visual storytelling.
-Your task is to analyze a chapter and identify key scenes for visual representation.
-
-For each section, create a scene with:
-1. A concise summary of the action/events
-2. An importance score (0.0-1.0) - higher for pivotal moments
-3. Scene type classification
-4. A detailed image prompt that captures the visual essence
-
-Image prompts should:
-- Describe the scene composition, characters, setting, and mood
-- Be specific about visual details (lighting, colors, atmosphere)
-- Maintain consistency with the story's tone
-- Be suitable for AI image generation (avoid text/dialogue in images)
-
-Return a JSON object with an array of scenes.`
-
-	userPrompt := fmt.Sprintf(`Analyze this chapter and generate scenes for visual storytelling:
-
-%s
-
-Create one scene per section. Focus on the most visually compelling moments.`, chapterText)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
-	jsonResp, err := eps.llm.GenerateJSON(ctx, sysPrompt, userPrompt, schema)
+// chapterScene is a generated scene and the audit log row of the call that
+// produced it.
+type chapterScene struct {
+	views.GeneratedScene
+	source *models.AIPrompt
+}
+
+// generateScenesForChapter sends the chapter in chunks that fit the context
+// window of the scene chain, each with a summary of the chunks before it.
+// When there is more than one chunk, reduceScenes merges the results.
+func (eps *ParserService) generateScenesForChapter(ctx context.Context, book *models.Book, chapter models.Chapter, budget chunkBudget, attempt int) ([]chapterScene, error) {
+	chunks := chunkChapter(chapter, budget)
+
+	// A chunked reply also carries the summary for the next chunk
+	schema := llm.SchemaFor(views.SceneGenerationResponse{})
+	if len(chunks) > 1 {
+		schema = llm.SchemaFor(views.SceneChunkResponse{})
+	}
+
+	base := models.AIPrompt{
+		Task:      string(llm.TaskScenes),
+		Attempt:   attempt,
+		ChapterID: &chapter.ID,
+		VolumeID:  &chapter.VolumeID,
+	}
+
+	var scenes []chapterScene
+	var contextSummary string
+	for i, chunk := range chunks {
+		prompt, err := eps.prompts.Render(ctx, llm.TaskScenes, promptScope(book), prompts.SceneData{
+			BookTitle:      book.Title,
+			Author:         book.Author,
+			ChapterNo:      chapter.ChapterNo,
+			ChapterTitle:   chapter.Title,
+			Text:           chunk.text,
+			Part:           i + 1,
+			Parts:          len(chunks),
+			ContextSummary: contextSummary,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to render prompt: %w", err)
+		}
+
+		jsonResp, source, err := eps.generateJSON(ctx, base, promptScope(book), prompt, schema, func(reply string) []string {
+			return checkScenes(reply, chunk.sections)
+		})
+		if err != nil {
+			if len(chunks) > 1 {
+				return nil, fmt.Errorf("part %d of %d: %w", i+1, len(chunks), err)
+			}
+			return nil, err
+		}
+
+		var response views.SceneChunkResponse
+		if err := json.Unmarshal([]byte(jsonResp), &response); err != nil {
+			return nil, fmt.Errorf("failed to parse LLM response: %w", err)
+		}
+		for _, scene := range response.Scenes {
+			scenes = append(scenes, chapterScene{GeneratedScene: scene, source: source})
+		}
+		if response.ContextSummary != "" {
+			contextSummary = response.ContextSummary
+		}
+	}
+
+	if len(chunks) > 1 {
+		scenes = eps.reduceScenes(ctx, base, book, chapter, contextSummary, scenes)
+	}
+	return scenes, nil
+}
+
+// reduceScenes merges the scenes of a chapter analyzed in chunks. It keeps
+// one scene per section, as the prompt asks, and has the LLM score every
+// scene against the whole chapter, since each chunk was scored on its own,
+// and point out scenes that repeat each other. When that fails the chunk
+// scores are kept.
+func (eps *ParserService) reduceScenes(ctx context.Context, base models.AIPrompt, book *models.Book, chapter models.Chapter, contextSummary string, scenes []chapterScene) []chapterScene {
+	// A section split over several chunks has a scene from each part
+	bySection := make(map[int]int, len(scenes))
+	deduped := scenes[:0]
+	for _, scene := range scenes {
+		if i, ok := bySection[scene.SectionNumber]; ok {
+			if scene.ImportanceScore > deduped[i].ImportanceScore {
+				deduped[i] = scene
+			}
+			continue
+		}
+		bySection[scene.SectionNumber] = len(deduped)
+		deduped = append(deduped, scene)
+	}
+	scenes = deduped
+	if len(scenes) < 2 {
+		return scenes
+	}
+
+	data := prompts.SceneRankingData{
+		ChapterNo:      chapter.ChapterNo,
+		ChapterTitle:   chapter.Title,
+		ContextSummary: contextSummary,
+		Scenes:         make([]prompts.RankedScene, len(scenes)),
+	}
+	for i, scene := range scenes {
+		data.Scenes[i] = prompts.RankedScene{Number: i + 1, SectionNumber: scene.SectionNumber, Summary: scene.Summary}
+	}
+
+	prompt, err := eps.prompts.Render(ctx, prompts.TaskSceneRanking, promptScope(book), data)
 	if err != nil {
-		return nil, fmt.Errorf("LLM generation failed: %w", err)
+		log.Printf("Failed to render scene ranking prompt for Chapter %d: %v", chapter.ChapterNo, err)
+		return scenes
 	}
 
-	// Parse response
-	var response views.SceneGenerationResponse
+	jsonResp, _, err := eps.generateJSON(ctx, base, promptScope(book), prompt, llm.SchemaFor(views.SceneRankingResponse{}), func(reply string) []string {
+		return checkRankings(reply, len(scenes))
+	})
+	if err != nil {
+		log.Printf("Scene ranking for Chapter %d failed, keeping per part scores: %v", chapter.ChapterNo, err)
+		return scenes
+	}
+
+	var response views.SceneRankingResponse
 	if err := json.Unmarshal([]byte(jsonResp), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
+		log.Printf("Failed to parse scene ranking for Chapter %d: %v", chapter.ChapterNo, err)
+		return scenes
 	}
 
-	if len(response.Scenes) == 0 {
-		return nil, fmt.Errorf("no scenes generated")
+	duplicate := make([]bool, len(scenes))
+	for _, ranking := range response.Rankings {
+		i := ranking.Number - 1
+		scenes[i].ImportanceScore = ranking.ImportanceScore
+		if ranking.DuplicateOf > 0 && ranking.DuplicateOf < ranking.Number {
+			duplicate[i] = true
+		}
 	}
 
-	return response.Scenes, nil
+	ranked := scenes[:0]
+	for i, scene := range scenes {
+		if !duplicate[i] {
+			ranked = append(ranked, scene)
+		}
+	}
+	return ranked
+}
+
+// checkScenes catches what the schema cannot: a reply without scenes and
+// scenes for sections that were not sent.
+func checkScenes(reply string, sectionNos []int) []string {
+	var response views.SceneGenerationResponse
+	if err := json.Unmarshal([]byte(reply), &response); err != nil {
+		return []string{fmt.Sprintf("reply does not match the expected structure: %v", err)}
+	}
+	return sceneProblems(response.Scenes, sectionNos)
+}
+
+func sceneProblems(scenes []views.GeneratedScene, sectionNos []int) []string {
+	if len(scenes) == 0 {
+		return []string{"scenes is empty, return at least one scene"}
+	}
+
+	sections := make([]string, len(sectionNos))
+	known := make(map[int]bool, len(sectionNos))
+	for i, sectionNo := range sectionNos {
+		sections[i] = strconv.Itoa(sectionNo)
+		known[sectionNo] = true
+	}
+
+	var problems []string
+	for i, scene := range scenes {
+		if !known[scene.SectionNumber] {
+			problems = append(problems, fmt.Sprintf("scenes[%d].section_number is %d, but only sections %s were given",
+				i, scene.SectionNumber, strings.Join(sections, ", ")))
+		}
+	}
+	return problems
+}
+
+// checkRankings makes sure every scene got exactly one ranking.
+func checkRankings(reply string, count int) []string {
+	var response views.SceneRankingResponse
+	if err := json.Unmarshal([]byte(reply), &response); err != nil {
+		return []string{fmt.Sprintf("reply does not match the expected structure: %v", err)}
+	}
+
+	var problems []string
+	seen := make(map[int]bool, count)
+	for i, ranking := range response.Rankings {
+		switch {
+		case ranking.Number < 1 || ranking.Number > count:
+			problems = append(problems, fmt.Sprintf("rankings[%d].number is %d, but there are only scenes 1 to %d", i, ranking.Number, count))
+		case seen[ranking.Number]:
+			problems = append(problems, fmt.Sprintf("rankings[%d] ranks scene %d a second time", i, ranking.Number))
+		}
+		seen[ranking.Number] = true
+	}
+	for number := 1; number <= count; number++ {
+		if !seen[number] {
+			problems = append(problems, fmt.Sprintf("scene %d has no ranking", number))
+		}
+	}
+	return problems
 }
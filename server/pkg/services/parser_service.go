@@ -21,7 +21,6 @@ import (
 	"github.com/Mahaveer86619/bookture/server/pkg/enums"
 	"github.com/Mahaveer86619/bookture/server/pkg/models"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
-	"google.golang.org/genai"
 	"gorm.io/gorm"
 )
 
@@ -110,10 +109,10 @@ type EPUBItemRef struct {
 // ============================================================================
 
 type LLMMetadataResponse struct {
-	Title       string `json:"title"`
-	Author      string `json:"author"`
-	Description string `json:"description"`
-	Genre       string `json:"genre,omitempty"`
+	Title       string `json:"title" desc:"The title of the book"`
+	Author      string `json:"author" desc:"The author's name"`
+	Description string `json:"description" desc:"A brief description or summary of the book (2-3 sentences)"`
+	Genre       string `json:"genre,omitempty" desc:"The primary genre of the book"`
 }
 
 type LLMChapterResponse struct {
@@ -804,29 +803,7 @@ func (ps *ParserService) enhanceMetadataWithLLM(parsed *ParsedVolume, volume *mo
 		return
 	}
 
-	// Define JSON schema for Gemini
-	schema := &genai.Schema{
-		Type: genai.TypeObject,
-		Properties: map[string]*genai.Schema{
-			"title": {
-				Type:        genai.TypeString,
-				Description: "The title of the book",
-			},
-			"author": {
-				Type:        genai.TypeString,
-				Description: "The author's name",
-			},
-			"description": {
-				Type:        genai.TypeString,
-				Description: "A brief description or summary of the book (2-3 sentences)",
-			},
-			"genre": {
-				Type:        genai.TypeString,
-				Description: "The primary genre of the book",
-			},
-		},
-		Required: []string{"title", "author", "description"},
-	}
+	schema := llm.SchemaFor(LLMMetadataResponse{})
 
 	sysPrompt := `You are a literary analyst. Analyze the provided book excerpt.
 Extract the Title, Author, and a short Description (2-3 sentences).
@@ -1,29 +1,115 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/parser"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/progress"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+// Job is a unit of background work. It must stop as soon as ctx is done.
+type Job func(ctx context.Context, report *progress.Reporter) error
+
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskFinished = errors.New("task already finished")
+	ErrTaskActive   = errors.New("task already queued or running")
+	ErrTaskRunning  = errors.New("task already running")
+	ErrQueueFull    = errors.New("job queue is full")
+)
+
+type TaskState struct {
+	Progress int
+	Status   enums.TaskStatus
+}
+
+// taskEntry is what progressMap holds. Finished entries are dropped after
+// taskStateTTL; the task history keeps the record.
+type taskEntry struct {
+	state     TaskState
+	updatedAt time.Time
+}
+
+const (
+	taskStateTTL   = time.Hour
+	expireInterval = 5 * time.Minute
 )
 
-type Job func(reportProgress func(int))
+// JobOptions decide where a job lands in the queue.
+type JobOptions struct {
+	UserID   uint
+	Type     enums.JobType
+	Priority enums.JobPriority
 
+	// Dropped, when set, is called with the job's context when the job is
+	// cancelled before it started, as the job itself then never runs.
+	Dropped func(ctx context.Context)
+}
+
+// ProcessingService runs jobs on a fixed pool of workers. The next job is the
+// highest priority one whose type is under its concurrency limit; ties go to
+// the user with the fewest running jobs, then to the user served least
+// recently, then to queue order.
 type ProcessingService struct {
-	jobQueue    chan jobRequest
+	mu          sync.Mutex
+	cond        *sync.Cond
+	queue       []*jobRequest
+	running     map[string]*jobRequest
+	limits      map[enums.JobType]int
+	runningType map[enums.JobType]int
+	runningUser map[uint]int
+	lastServed  map[uint]uint64
+	serveSeq    uint64
+	paused      bool
+	pausedTypes map[enums.JobType]bool
+	queueSize   int
+	workerCount int
+	quitting    bool
+
 	progressMap sync.Map
+	events      *progress.Hub
 	wg          sync.WaitGroup
-	quit        chan bool
+	stopExpire  chan struct{}
 }
 
 type jobRequest struct {
-	id  string
-	job Job
+	id         string
+	job        Job
+	opts       JobOptions
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	enqueuedAt time.Time
+	startedAt  time.Time
 }
 
-func NewProcessingService(workerCount int, queueSize int) *ProcessingService {
+// NewProcessingService starts workerCount workers. limits caps how many jobs
+// of a type run at once; types without an entry may use every worker.
+func NewProcessingService(workerCount int, queueSize int, limits map[enums.JobType]int) *ProcessingService {
 	ps := &ProcessingService{
-		jobQueue: make(chan jobRequest, queueSize),
-		quit:     make(chan bool),
+		running:     make(map[string]*jobRequest),
+		limits:      limits,
+		runningType: make(map[enums.JobType]int),
+		runningUser: make(map[uint]int),
+		lastServed:  make(map[uint]uint64),
+		pausedTypes: make(map[enums.JobType]bool),
+		queueSize:   queueSize,
+		workerCount: workerCount,
+		events:      progress.NewHub(256),
+		stopExpire:  make(chan struct{}),
 	}
+	ps.cond = sync.NewCond(&ps.mu)
+	go ps.expireLoop()
 
 	// Start workers
 	for i := range workerCount {
@@ -34,56 +120,457 @@ func NewProcessingService(workerCount int, queueSize int) *ProcessingService {
 	return ps
 }
 
+// ParseJobLimits reads a spec such as "parse=2,image=1".
+func ParseJobLimits(spec string) map[enums.JobType]int {
+	limits := make(map[enums.JobType]int)
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+
+		jobType := enums.JobType(strings.TrimSpace(name))
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if !jobType.IsValid() || err != nil || n < 1 {
+			log.Printf("Warning: ignoring invalid job concurrency limit %q", part)
+			continue
+		}
+		limits[jobType] = n
+	}
+	return limits
+}
+
 func (ps *ProcessingService) worker(id int) {
 	defer ps.wg.Done()
 	log.Printf("Worker %d started", id)
 
 	for {
-		select {
-		case req := <-ps.jobQueue:
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						log.Printf("Worker %d panicked: %v", id, r)
-						ps.progressMap.Store(req.id, -1) // Error state
-					}
-				}()
-
-				// Initialize progress
-				ps.progressMap.Store(req.id, 0)
-
-				// Run job with a reporter function
-				req.job(func(p int) {
-					ps.progressMap.Store(req.id, p)
-				})
-
-				// Cleanup or mark complete
-				ps.progressMap.Store(req.id, 100)
-			}()
-		case <-ps.quit:
+		req := ps.next()
+		if req == nil {
 			return
 		}
+		ps.run(id, req)
+		ps.release(req)
 	}
 }
 
-// Enqueue adds a job to the queue. Non-blocking; drops job if queue is full.
-func (ps *ProcessingService) Enqueue(id string, job Job) {
-	select {
-	case ps.jobQueue <- jobRequest{id: id, job: job}:
+// next blocks until a job may start, or returns nil on shutdown.
+func (ps *ProcessingService) next() *jobRequest {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for {
+		if ps.quitting {
+			return nil
+		}
+
+		if i := ps.pick(); i >= 0 {
+			req := ps.queue[i]
+			ps.queue = slices.Delete(ps.queue, i, i+1)
+
+			ps.serveSeq++
+			ps.lastServed[req.opts.UserID] = ps.serveSeq
+			ps.runningType[req.opts.Type]++
+			ps.runningUser[req.opts.UserID]++
+			req.startedAt = time.Now()
+			ps.running[req.id] = req
+			return req
+		}
+
+		ps.cond.Wait()
+	}
+}
+
+// pick returns the index of the job to run next or -1. Caller holds mu.
+func (ps *ProcessingService) pick() int {
+	if ps.paused {
+		return -1
+	}
+
+	best := -1
+	for i, req := range ps.queue {
+		if ps.pausedTypes[req.opts.Type] {
+			continue
+		}
+		if limit, ok := ps.limits[req.opts.Type]; ok && ps.runningType[req.opts.Type] >= limit {
+			continue
+		}
+		if best < 0 || ps.runsBefore(req, ps.queue[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (ps *ProcessingService) runsBefore(a, b *jobRequest) bool {
+	if a.opts.Priority.Rank() != b.opts.Priority.Rank() {
+		return a.opts.Priority.Rank() > b.opts.Priority.Rank()
+	}
+	if ps.runningUser[a.opts.UserID] != ps.runningUser[b.opts.UserID] {
+		return ps.runningUser[a.opts.UserID] < ps.runningUser[b.opts.UserID]
+	}
+	return ps.lastServed[a.opts.UserID] < ps.lastServed[b.opts.UserID]
+}
+
+func (ps *ProcessingService) release(req *jobRequest) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.running, req.id)
+	ps.runningType[req.opts.Type]--
+	ps.runningUser[req.opts.UserID]--
+	if ps.runningUser[req.opts.UserID] == 0 {
+		delete(ps.runningUser, req.opts.UserID)
+	}
+	ps.cond.Broadcast()
+}
+
+func (ps *ProcessingService) run(workerID int, req *jobRequest) {
+	reporter := ps.newReporter(req.id)
+
+	defer req.cancel(nil)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Worker %d panicked: %v", workerID, r)
+			ps.setState(req.id, -1, enums.TaskError)
+			reporter.Finish(enums.EventError, fmt.Errorf("worker panicked: %v", r))
+		}
+	}()
+
+	// Cancelled between being picked and starting
+	if req.ctx.Err() != nil {
+		ps.setState(req.id, ps.progress(req.id), enums.TaskCancelled)
+		reporter.Finish(enums.EventCancelled, nil)
+		if req.opts.Dropped != nil {
+			req.opts.Dropped(req.ctx)
+		}
+		return
+	}
+
+	// Initialize progress
+	reporter.Progress(0)
+
+	// Run job with a reporter
+	err := req.job(req.ctx, reporter)
+
+	switch {
+	case err != nil && errors.Is(context.Cause(req.ctx), parser.ErrInterrupted):
+		log.Printf("Task %s interrupted by shutdown", req.id)
+		ps.setState(req.id, ps.progress(req.id), enums.TaskCancelled)
+		reporter.Finish(enums.EventCancelled, parser.ErrInterrupted)
+	case errors.Is(err, context.Canceled) || (err != nil && req.ctx.Err() != nil):
+		log.Printf("Task %s cancelled", req.id)
+		ps.setState(req.id, ps.progress(req.id), enums.TaskCancelled)
+		reporter.Finish(enums.EventCancelled, nil)
+	case err != nil:
+		log.Printf("Task %s failed: %v", req.id, err)
+		ps.setState(req.id, -1, enums.TaskError)
+		reporter.Finish(enums.EventError, err)
 	default:
-		log.Println("Warning: Job queue is full, job dropped")
+		ps.setState(req.id, 100, enums.TaskCompleted)
+		reporter.Finish(enums.EventCompleted, nil)
+	}
+}
+
+func (ps *ProcessingService) newReporter(id string) *progress.Reporter {
+	return progress.NewReporter(func(e views.TaskEvent) {
+		ps.events.Publish(id, e)
+		if !enums.TaskEventType(e.Type).IsFinal() {
+			ps.setState(id, e.Progress, enums.TaskRunning)
+		}
+	})
+}
+
+func (ps *ProcessingService) setState(id string, progress int, status enums.TaskStatus) {
+	ps.progressMap.Store(id, taskEntry{
+		state:     TaskState{Progress: progress, Status: status},
+		updatedAt: time.Now(),
+	})
+}
+
+func (ps *ProcessingService) expireLoop() {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ps.stopExpire:
+			return
+		case <-ticker.C:
+			ps.expire(time.Now().Add(-taskStateTTL))
+		}
+	}
+}
+
+// expire forgets tasks that finished before cutoff.
+func (ps *ProcessingService) expire(cutoff time.Time) {
+	ps.progressMap.Range(func(key, value any) bool {
+		entry := value.(taskEntry)
+		if entry.state.Status.IsFinal() && entry.updatedAt.Before(cutoff) {
+			// Skip tasks that were enqueued again in the meantime
+			if ps.progressMap.CompareAndDelete(key, value) {
+				ps.events.Forget(key.(string))
+			}
+		}
+		return true
+	})
+}
+
+// Enqueue adds a job to the queue. Non-blocking; returns ErrQueueFull when
+// there is no room and ErrTaskActive when a job with this id is pending.
+func (ps *ProcessingService) Enqueue(id string, opts JobOptions, job Job) error {
+	if !opts.Priority.IsValid() {
+		opts.Priority = enums.PriorityNormal
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.isActive(id) {
+		return ErrTaskActive
+	}
+	if len(ps.queue) >= ps.queueSize {
+		return ErrQueueFull
 	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	req := &jobRequest{id: id, job: job, opts: opts, ctx: ctx, cancel: cancel, enqueuedAt: time.Now()}
+
+	ps.queue = append(ps.queue, req)
+	ps.setState(id, 0, enums.TaskQueued)
+	ps.events.Publish(id, views.TaskEvent{
+		Type:  enums.EventStage.ToString(),
+		Stage: enums.StageQueued.ToString(),
+	})
+	ps.cond.Signal()
+	return nil
 }
 
-// GetProgress is the handler to get update percentage
-func (ps *ProcessingService) GetProgress(id string) int {
+// IsActive reports whether a job with this id is queued or running.
+func (ps *ProcessingService) IsActive(id string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.isActive(id)
+}
+
+func (ps *ProcessingService) isActive(id string) bool {
+	_, running := ps.running[id]
+	return running || ps.indexOf(id) >= 0
+}
+
+// Cancel stops a queued or running job. Work the job already persisted is kept.
+func (ps *ProcessingService) Cancel(id string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if req, ok := ps.running[id]; ok {
+		req.cancel(nil)
+		return nil
+	}
+
+	if i := ps.indexOf(id); i >= 0 {
+		req := ps.queue[i]
+		ps.queue = slices.Delete(ps.queue, i, i+1)
+		req.cancel(nil)
+		ps.setState(id, 0, enums.TaskCancelled)
+		ps.newReporter(id).Finish(enums.EventCancelled, nil)
+		if req.opts.Dropped != nil {
+			go req.opts.Dropped(req.ctx)
+		}
+		return nil
+	}
+
+	if _, ok := ps.progressMap.Load(id); ok {
+		return ErrTaskFinished
+	}
+	return ErrTaskNotFound
+}
+
+// State returns a task's state, or false when the task is unknown or its
+// state has expired.
+func (ps *ProcessingService) State(id string) (TaskState, bool) {
 	if val, ok := ps.progressMap.Load(id); ok {
-		return val.(int)
+		return val.(taskEntry).state, true
+	}
+	return TaskState{}, false
+}
+
+// progress returns a task's last reported percentage.
+func (ps *ProcessingService) progress(id string) int {
+	state, _ := ps.State(id)
+	return state.Progress
+}
+
+// Subscribe streams a task's events, replaying buffered ones after lastEventID.
+func (ps *ProcessingService) Subscribe(id string, lastEventID int64) ([]views.TaskEvent, <-chan views.TaskEvent, func(), bool) {
+	return ps.events.Subscribe(id, lastEventID)
+}
+
+// Pause stops workers from starting new jobs of the given type, or of any
+// type when jobType is empty. Running jobs are not interrupted.
+func (ps *ProcessingService) Pause(jobType enums.JobType) {
+	ps.setPaused(jobType, true)
+}
+
+func (ps *ProcessingService) Resume(jobType enums.JobType) {
+	ps.setPaused(jobType, false)
+}
+
+func (ps *ProcessingService) setPaused(jobType enums.JobType, paused bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if jobType == "" {
+		ps.paused = paused
+	} else if paused {
+		ps.pausedTypes[jobType] = true
+	} else {
+		delete(ps.pausedTypes, jobType)
 	}
-	return 0
+	ps.cond.Broadcast()
 }
 
-func (ps *ProcessingService) Shutdown() {
-	close(ps.quit)
-	ps.wg.Wait()
+// Reorder moves a queued job to position (0 is the front) and optionally
+// changes its priority. Scheduling still honours priorities and fairness;
+// position only breaks ties.
+func (ps *ProcessingService) Reorder(id string, position *int, priority enums.JobPriority) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	i := ps.indexOf(id)
+	if i < 0 {
+		if _, ok := ps.running[id]; ok {
+			return ErrTaskRunning
+		}
+		if _, ok := ps.progressMap.Load(id); ok {
+			return ErrTaskFinished
+		}
+		return ErrTaskNotFound
+	}
+
+	req := ps.queue[i]
+	if priority != "" {
+		req.opts.Priority = priority
+	}
+	if position != nil {
+		pos := min(max(*position, 0), len(ps.queue)-1)
+		ps.queue = slices.Delete(ps.queue, i, i+1)
+		ps.queue = slices.Insert(ps.queue, pos, req)
+	}
+	ps.cond.Broadcast()
+	return nil
+}
+
+// Snapshot describes the running and waiting jobs for the admin API.
+func (ps *ProcessingService) Snapshot() views.QueueView {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	view := views.QueueView{
+		Paused:  ps.paused,
+		Workers: ps.workerCount,
+		Limits:  make(map[string]int, len(ps.limits)),
+		Running: make([]views.QueuedJobView, 0, len(ps.running)),
+		Queued:  make([]views.QueuedJobView, 0, len(ps.queue)),
+	}
+	for jobType, limit := range ps.limits {
+		view.Limits[jobType.ToString()] = limit
+	}
+	for jobType := range ps.pausedTypes {
+		view.PausedTypes = append(view.PausedTypes, jobType.ToString())
+	}
+
+	for _, req := range ps.running {
+		v := toQueuedJobView(req)
+		v.Status = enums.TaskRunning.ToString()
+		v.Progress = ps.progress(req.id)
+		view.Running = append(view.Running, v)
+	}
+	slices.SortFunc(view.Running, func(a, b views.QueuedJobView) int {
+		return a.StartedAt.Compare(*b.StartedAt)
+	})
+
+	for i, req := range ps.queue {
+		v := toQueuedJobView(req)
+		v.Position = &i
+		v.Status = enums.TaskQueued.ToString()
+		view.Queued = append(view.Queued, v)
+	}
+
+	return view
+}
+
+func toQueuedJobView(req *jobRequest) views.QueuedJobView {
+	v := views.QueuedJobView{
+		TaskID:     req.id,
+		UserID:     utils.MaskID(req.opts.UserID),
+		JobType:    req.opts.Type.ToString(),
+		Priority:   req.opts.Priority.ToString(),
+		EnqueuedAt: req.enqueuedAt,
+	}
+	if !req.startedAt.IsZero() {
+		startedAt := req.startedAt
+		v.StartedAt = &startedAt
+	}
+	return v
+}
+
+// indexOf finds a queued job. Caller holds mu.
+func (ps *ProcessingService) indexOf(id string) int {
+	return slices.IndexFunc(ps.queue, func(req *jobRequest) bool { return req.id == id })
+}
+
+// Load is the number of queued and running jobs.
+func (ps *ProcessingService) Load() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return len(ps.queue) + len(ps.running)
+}
+
+// FullTypes lists the job types that are at their concurrency limit.
+func (ps *ProcessingService) FullTypes() []enums.JobType {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var full []enums.JobType
+	for jobType, limit := range ps.limits {
+		if ps.runningType[jobType] >= limit {
+			full = append(full, jobType)
+		}
+	}
+	return full
+}
+
+// Shutdown stops workers from starting new jobs and waits for running ones.
+// Jobs still running when ctx is done are interrupted with
+// parser.ErrInterrupted. Queued jobs are dropped.
+func (ps *ProcessingService) Shutdown(ctx context.Context) {
+	ps.mu.Lock()
+	if !ps.quitting {
+		close(ps.stopExpire)
+	}
+	ps.quitting = true
+	ps.cond.Broadcast()
+	ps.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ps.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	ps.mu.Lock()
+	log.Printf("Drain timeout reached, interrupting %d running jobs", len(ps.running))
+	for _, req := range ps.running {
+		req.cancel(parser.ErrInterrupted)
+	}
+	ps.mu.Unlock()
+
+	<-done
 }
@@ -0,0 +1,146 @@
+package progress
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+// Hub fans task events out to live subscribers and keeps a bounded backlog
+// per task so reconnecting clients can replay what they missed.
+type Hub struct {
+	mu         sync.Mutex
+	streams    map[string]*stream
+	bufferSize int
+}
+
+type stream struct {
+	nextID      int64
+	events      []views.TaskEvent
+	subscribers map[chan views.TaskEvent]struct{}
+	done        bool
+}
+
+func NewHub(bufferSize int) *Hub {
+	return &Hub{
+		streams:    make(map[string]*stream),
+		bufferSize: bufferSize,
+	}
+}
+
+// Publish stamps the event with the next ID for the task and delivers it.
+// Subscribers that cannot keep up are dropped; they can reconnect and replay.
+func (h *Hub) Publish(taskID string, event views.TaskEvent) views.TaskEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, ok := h.streams[taskID]
+	if !ok || st.done {
+		// A re-enqueued task starts a fresh stream but keeps counting IDs
+		var nextID int64
+		if ok {
+			nextID = st.nextID
+		}
+		st = &stream{nextID: nextID, subscribers: make(map[chan views.TaskEvent]struct{})}
+		h.streams[taskID] = st
+	}
+
+	st.nextID++
+	event.ID = st.nextID
+	event.TaskID = taskID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	st.events = append(st.events, event)
+	if len(st.events) > h.bufferSize {
+		st.events = st.events[len(st.events)-h.bufferSize:]
+	}
+
+	for ch := range st.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(st.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	if enums.TaskEventType(event.Type).IsFinal() {
+		st.done = true
+		for ch := range st.subscribers {
+			delete(st.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return event
+}
+
+// Subscribe returns the buffered events after lastEventID and a channel for
+// live events. The channel is closed once the task reaches a final state.
+// ok is false when nothing is known about the task.
+func (h *Hub) Subscribe(taskID string, lastEventID int64) (replay []views.TaskEvent, events <-chan views.TaskEvent, unsubscribe func(), ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, found := h.streams[taskID]
+	if !found {
+		return nil, nil, func() {}, false
+	}
+
+	for _, e := range st.events {
+		if e.ID > lastEventID {
+			replay = append(replay, e)
+		}
+	}
+
+	ch := make(chan views.TaskEvent, 64)
+	if st.done {
+		close(ch)
+		return replay, ch, func() {}, true
+	}
+
+	st.subscribers[ch] = struct{}{}
+	unsubscribe = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := st.subscribers[ch]; ok {
+			delete(st.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return replay, ch, unsubscribe, true
+}
+
+// Last returns the most recent event of a task.
+func (h *Hub) Last(taskID string) (views.TaskEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, ok := h.streams[taskID]
+	if !ok || len(st.events) == 0 {
+		return views.TaskEvent{}, false
+	}
+	return st.events[len(st.events)-1], true
+}
+
+// Forget drops a task's backlog. Open subscriptions are closed; their
+// unsubscribe funcs stay safe to call.
+func (h *Hub) Forget(taskID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, ok := h.streams[taskID]
+	if !ok {
+		return
+	}
+	for ch := range st.subscribers {
+		delete(st.subscribers, ch)
+		close(ch)
+	}
+	delete(h.streams, taskID)
+}
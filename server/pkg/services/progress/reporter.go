@@ -0,0 +1,216 @@
+package progress
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+)
+
+// Reporter is handed to a running job. Every call turns into a structured
+// event carrying the current stage and percentage. A nil *Reporter is valid
+// and only logs.
+type Reporter struct {
+	mu         sync.Mutex
+	sink       func(views.TaskEvent)
+	onProgress []func(int)
+	onEvent    []func(views.TaskEvent)
+	onCall     []func(string)
+	stage      enums.TaskStage
+	percent    int
+	result     any
+}
+
+func NewReporter(sink func(views.TaskEvent)) *Reporter {
+	return &Reporter{sink: sink, stage: enums.StageQueued}
+}
+
+// OnProgress registers an extra callback for percentage updates.
+func (r *Reporter) OnProgress(fn func(int)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onProgress = append(r.onProgress, fn)
+}
+
+// OnEvent registers a callback that sees every event the reporter emits.
+func (r *Reporter) OnEvent(fn func(views.TaskEvent)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onEvent = append(r.onEvent, fn)
+}
+
+// Provider call kinds passed to Call.
+const (
+	CallLLM   = "llm"
+	CallImage = "image"
+)
+
+// OnCall registers a callback for provider calls.
+func (r *Reporter) OnCall(fn func(kind string)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onCall = append(r.onCall, fn)
+}
+
+// Call counts one request to an LLM or image provider. It is recorded in the
+// task history but not streamed.
+func (r *Reporter) Call(kind string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	hooks := r.onCall
+	r.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(kind)
+	}
+}
+
+func (r *Reporter) Progress(percent int) {
+	r.setPercent(percent)
+	r.emit(views.TaskEvent{Type: enums.EventProgress.ToString()})
+}
+
+func (r *Reporter) Stage(stage enums.TaskStage, percent int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.stage = stage
+	r.mu.Unlock()
+
+	r.setPercent(percent)
+	r.emit(views.TaskEvent{Type: enums.EventStage.ToString()})
+}
+
+func (r *Reporter) setPercent(percent int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.percent = percent
+	hooks := r.onProgress
+	r.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(percent)
+	}
+}
+
+// Chapter reports that chapter n of total went through the scene stage.
+func (r *Reporter) Chapter(n, total, scenesGenerated int) {
+	r.emit(views.TaskEvent{
+		Type:            enums.EventChapter.ToString(),
+		Chapter:         n,
+		TotalChapters:   total,
+		ScenesGenerated: scenesGenerated,
+	})
+}
+
+// Image reports that done of total scene images are finished.
+func (r *Reporter) Image(done, total int) {
+	r.emit(views.TaskEvent{
+		Type:            enums.EventImage.ToString(),
+		ImagesGenerated: done,
+		TotalImages:     total,
+	})
+}
+
+func (r *Reporter) Retry(attempt, maxAttempts int, err error) {
+	log.Printf("Attempt %d/%d failed: %v", attempt, maxAttempts, err)
+	r.emit(views.TaskEvent{
+		Type:        enums.EventRetry.ToString(),
+		Attempt:     attempt,
+		MaxAttempts: maxAttempts,
+		Error:       err.Error(),
+	})
+}
+
+// RateLimited reports a wait for a provider's quota window to reopen.
+func (r *Reporter) RateLimited(wait time.Duration, reason string) {
+	log.Printf("Rate limit hit (%s). Waiting %v before retry...", reason, wait)
+	resumeAt := time.Now().Add(wait)
+	r.emit(views.TaskEvent{
+		Type:        enums.EventRateLimited.ToString(),
+		WaitSeconds: int(wait.Seconds()),
+		ResumeAt:    &resumeAt,
+		Message:     reason,
+	})
+}
+
+// Warn logs a non-fatal problem and pushes it to subscribers.
+func (r *Reporter) Warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	r.emit(views.TaskEvent{Type: enums.EventWarning.ToString(), Message: msg})
+}
+
+// SetResult stores the payload for the task's final event.
+func (r *Reporter) SetResult(result any) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result = result
+}
+
+func (r *Reporter) Result() any {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.result
+}
+
+// Finish emits the terminal event for the task.
+func (r *Reporter) Finish(eventType enums.TaskEventType, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if eventType == enums.EventCompleted {
+		r.percent = 100
+	}
+	r.stage = enums.StageFinished
+	result := r.result
+	r.mu.Unlock()
+
+	event := views.TaskEvent{Type: eventType.ToString(), Result: result}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	r.emit(event)
+}
+
+func (r *Reporter) emit(event views.TaskEvent) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	event.Stage = r.stage.ToString()
+	event.Progress = r.percent
+	hooks := r.onEvent
+	r.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(event)
+	}
+	if r.sink != nil {
+		r.sink(event)
+	}
+}
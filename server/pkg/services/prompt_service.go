@@ -0,0 +1,200 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/prompts"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+	"gorm.io/gorm"
+)
+
+// PromptService manages prompt template overrides. Library and book owners
+// manage their own; global templates are for admins.
+type PromptService struct {
+	db       *gorm.DB
+	registry *prompts.Registry
+}
+
+func NewPromptService() *PromptService {
+	return &PromptService{
+		db:       db.GetBooktureDB().DB,
+		registry: prompts.NewRegistry(),
+	}
+}
+
+// CreateTemplate saves the next version of a template for the request's
+// scope. admin allows global templates.
+func (ps *PromptService) CreateTemplate(userID uint, admin bool, req views.CreatePromptTemplateRequest) (*views.PromptTemplateView, error) {
+	if err := ps.registry.Validate(llm.Task(req.Task), req.System, req.User); err != nil {
+		return nil, errz.New(errz.BadRequest, err.Error(), err)
+	}
+
+	row := models.PromptTemplate{
+		Task:   req.Task,
+		System: req.System,
+		User:   req.User,
+		Note:   req.Note,
+	}
+
+	var err error
+	if row.LibraryID, row.BookID, err = ps.scope(userID, req.LibraryID, req.BookID); err != nil {
+		return nil, err
+	}
+	if row.LibraryID == nil && row.BookID == nil && !admin {
+		return nil, errz.New(errz.Forbidden, "Only admins can change global prompt templates", nil)
+	}
+
+	// Versions of deleted templates are not reused, so old labels stay unique
+	var latest int
+	err = ps.scopeQuery(ps.db.Unscoped().Model(&models.PromptTemplate{}), row.Task, row.LibraryID, row.BookID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&latest).Error
+	if err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to save prompt template", err)
+	}
+	row.Version = latest + 1
+
+	if err := ps.db.Create(&row).Error; err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to save prompt template", err)
+	}
+
+	v := views.ToPromptTemplateView(&row)
+	return &v, nil
+}
+
+// GetTemplates lists the templates of a task that apply to a library or
+// book, newest first and in the order they are tried: book, library, global
+// and finally the built-in one.
+func (ps *PromptService) GetTemplates(userID uint, task, libraryID, bookID string) ([]views.PromptTemplateView, error) {
+	if !ps.registry.Has(llm.Task(task)) {
+		return nil, errz.New(errz.BadRequest, "Unknown prompt task", nil)
+	}
+
+	libID, bkID, err := ps.scope(userID, libraryID, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A book inherits its library's templates
+	if bkID != nil {
+		var book models.Book
+		if err := ps.db.Select("library_id").First(&book, *bkID).Error; err != nil {
+			return nil, errz.New(errz.InternalServerError, "Failed to fetch book", err)
+		}
+		libID = &book.LibraryID
+	}
+
+	query := ps.db.Where("task = ?", task)
+	conditions := ps.db.Where("library_id IS NULL AND book_id IS NULL")
+	if libID != nil {
+		conditions = conditions.Or("library_id = ? AND book_id IS NULL", *libID)
+	}
+	if bkID != nil {
+		conditions = conditions.Or("book_id = ?", *bkID)
+	}
+
+	var rows []models.PromptTemplate
+	if err := query.Where(conditions).Order("book_id IS NULL, library_id IS NULL, version DESC").Find(&rows).Error; err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to fetch prompt templates", err)
+	}
+
+	result := make([]views.PromptTemplateView, 0, len(rows)+1)
+	for i := range rows {
+		result = append(result, views.ToPromptTemplateView(&rows[i]))
+	}
+
+	system, user, version, _ := ps.registry.Builtin(llm.Task(task))
+	result = append(result, views.PromptTemplateView{
+		Task:    task,
+		Version: version,
+		System:  system,
+		User:    user,
+	})
+	return result, nil
+}
+
+// DeleteTemplate removes a template version, so the previous one applies
+// again. Generated rows keep their version label.
+func (ps *PromptService) DeleteTemplate(userID uint, admin bool, templateID uint) error {
+	var row models.PromptTemplate
+	if err := ps.db.First(&row, templateID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errz.New(errz.NotFound, "Prompt template not found", err)
+		}
+		return errz.New(errz.InternalServerError, "Failed to fetch prompt template", err)
+	}
+
+	switch {
+	case row.BookID != nil:
+		if err := ps.ownsBook(userID, *row.BookID); err != nil {
+			return errz.New(errz.NotFound, "Prompt template not found", err)
+		}
+	case row.LibraryID != nil:
+		if err := ps.ownsLibrary(userID, *row.LibraryID); err != nil {
+			return errz.New(errz.NotFound, "Prompt template not found", err)
+		}
+	case !admin:
+		return errz.New(errz.Forbidden, "Only admins can change global prompt templates", nil)
+	}
+
+	if err := ps.db.Delete(&row).Error; err != nil {
+		return errz.New(errz.InternalServerError, "Failed to delete prompt template", err)
+	}
+	return nil
+}
+
+// scope unmasks and checks ownership of the library or book of a request.
+// Both are nil for the global scope.
+func (ps *PromptService) scope(userID uint, libraryID, bookID string) (*uint, *uint, error) {
+	switch {
+	case bookID != "":
+		id, err := utils.UnmaskID(bookID)
+		if err != nil {
+			return nil, nil, errz.New(errz.BadRequest, "Invalid book ID", err)
+		}
+		if err := ps.ownsBook(userID, id); err != nil {
+			return nil, nil, errz.New(errz.NotFound, "Book not found", err)
+		}
+		return nil, &id, nil
+
+	case libraryID != "":
+		id, err := utils.UnmaskID(libraryID)
+		if err != nil {
+			return nil, nil, errz.New(errz.BadRequest, "Invalid library ID", err)
+		}
+		if err := ps.ownsLibrary(userID, id); err != nil {
+			return nil, nil, errz.New(errz.NotFound, "Library not found", err)
+		}
+		return &id, nil, nil
+	}
+	return nil, nil, nil
+}
+
+func (ps *PromptService) scopeQuery(query *gorm.DB, task string, libraryID, bookID *uint) *gorm.DB {
+	query = query.Where("task = ?", task)
+	switch {
+	case bookID != nil:
+		return query.Where("book_id = ?", *bookID)
+	case libraryID != nil:
+		return query.Where("library_id = ? AND book_id IS NULL", *libraryID)
+	default:
+		return query.Where("library_id IS NULL AND book_id IS NULL")
+	}
+}
+
+func (ps *PromptService) ownsLibrary(userID, libraryID uint) error {
+	var library models.Library
+	return ps.db.Where("id = ? AND user_id = ?", libraryID, userID).First(&library).Error
+}
+
+func (ps *PromptService) ownsBook(userID, bookID uint) error {
+	var book models.Book
+	return ps.db.Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("books.id = ? AND libraries.user_id = ?", bookID, userID).
+		First(&book).Error
+}
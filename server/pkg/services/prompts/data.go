@@ -0,0 +1,110 @@
+package prompts
+
+import "github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+
+// TaskRepair is the prompt that asks the LLM to fix a reply which failed
+// validation. The request goes down the chain of the task being repaired.
+const TaskRepair llm.Task = "repair"
+
+// TaskSceneRanking scores the scenes of a chapter that was analyzed in parts
+// against the whole chapter. It goes down the scene chain.
+const TaskSceneRanking llm.Task = "scene_ranking"
+
+// TaskSceneBatch analyzes several short chapters in one request. It goes
+// down the scene chain.
+const TaskSceneBatch llm.Task = "scene_batch"
+
+// MetadataData is what the metadata templates can use.
+type MetadataData struct {
+	Excerpt string // The first words of the volume
+}
+
+// SceneData is what the scene templates can use.
+type SceneData struct {
+	BookTitle    string
+	Author       string
+	ChapterNo    int
+	ChapterTitle string
+	Text         string // "Chapter N: Title" followed by each "Section N:" and its text
+
+	// Long chapters are sent in parts, each with a summary of the ones before
+	Part           int
+	Parts          int
+	ContextSummary string
+}
+
+// SceneBatchData is what the scene batch templates can use.
+type SceneBatchData struct {
+	BookTitle string
+	Author    string
+	Chapters  []BatchChapter
+}
+
+type BatchChapter struct {
+	ChapterNo    int
+	ChapterTitle string
+	Text         string // Same as SceneData.Text
+}
+
+// SceneRankingData is what the scene ranking templates can use.
+type SceneRankingData struct {
+	ChapterNo      int
+	ChapterTitle   string
+	ContextSummary string // Summary of the whole chapter from its last part
+	Scenes         []RankedScene
+}
+
+type RankedScene struct {
+	Number        int // 1 based position in the list
+	SectionNumber int
+	Summary       string
+}
+
+// RepairData is what the repair templates can use.
+type RepairData struct {
+	System   string   // System prompt of the failed request
+	Request  string   // User prompt of the failed request
+	Reply    string   // The invalid reply
+	Problems []string // What validation found wrong with it
+}
+
+// exampleData is rendered by Validate to catch templates that use fields
+// which do not exist.
+var exampleData = map[llm.Task]any{
+	llm.TaskMetadata: MetadataData{
+		Excerpt: "It was a bright cold day in April, and the clocks were striking thirteen.",
+	},
+	llm.TaskScenes: SceneData{
+		BookTitle:      "Example",
+		Author:         "Anonymous",
+		ChapterNo:      1,
+		ChapterTitle:   "The Beginning",
+		Text:           "Chapter 1: The Beginning\n\nSection 1:\nIt was a bright cold day in April.\n\n",
+		Part:           2,
+		Parts:          3,
+		ContextSummary: "Winston walks home through the cold.",
+	},
+	TaskSceneBatch: SceneBatchData{
+		BookTitle: "Example",
+		Author:    "Anonymous",
+		Chapters: []BatchChapter{
+			{ChapterNo: 1, ChapterTitle: "Prologue", Text: "Chapter 1: Prologue\n\nSection 1:\nTwo households, both alike in dignity.\n\n"},
+			{ChapterNo: 2, ChapterTitle: "Act I", Text: "Chapter 2: Act I\n\nSection 1:\nEnter Sampson and Gregory.\n\n"},
+		},
+	},
+	TaskSceneRanking: SceneRankingData{
+		ChapterNo:      1,
+		ChapterTitle:   "The Beginning",
+		ContextSummary: "Winston walks home and starts a diary.",
+		Scenes: []RankedScene{
+			{Number: 1, SectionNumber: 1, Summary: "Winston walks home through the cold."},
+			{Number: 2, SectionNumber: 2, Summary: "Winston opens the diary."},
+		},
+	},
+	TaskRepair: RepairData{
+		System:   "You are a literary analyst.",
+		Request:  "Analyze this book excerpt and extract metadata.",
+		Reply:    `{"title": "Example"}`,
+		Problems: []string{"author is missing"},
+	},
+}
@@ -0,0 +1,231 @@
+package prompts
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"gorm.io/gorm"
+)
+
+// Built-in templates are named <task>.v<version>.tmpl and define a "system"
+// and a "user" template. The highest version of a task is used.
+//
+//go:embed templates/*.tmpl
+var builtinFS embed.FS
+
+// Scope selects whose overrides apply. A zero ID skips that level.
+type Scope struct {
+	LibraryID uint
+	BookID    uint
+}
+
+// Prompt is a rendered pair of prompts and the template they came from.
+type Prompt struct {
+	System     string
+	User       string
+	Version    string // e.g. builtin:scenes:v1 or book:scenes:v3
+	TemplateID *uint  // Override row, nil for built-in templates
+}
+
+type builtin struct {
+	version int
+	tmpl    *template.Template
+}
+
+// Registry renders the prompts of each task. Overrides in prompt_templates
+// win over the built-in templates, a book's over its library's and a
+// library's over the global ones.
+type Registry struct {
+	db       *gorm.DB
+	builtins map[llm.Task]builtin
+}
+
+// NewRegistry panics when a built-in template does not parse, as they are
+// compiled into the binary.
+func NewRegistry() *Registry {
+	builtins, err := loadBuiltins()
+	if err != nil {
+		panic(fmt.Sprintf("prompts: %v", err))
+	}
+
+	return &Registry{
+		db:       db.GetBooktureDB().DB,
+		builtins: builtins,
+	}
+}
+
+func loadBuiltins() (map[llm.Task]builtin, error) {
+	files, err := fs.Glob(builtinFS, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	builtins := make(map[llm.Task]builtin)
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		task, rawVersion, ok := strings.Cut(name, ".v")
+		version, err := strconv.Atoi(rawVersion)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("template %s must be named <task>.v<version>.tmpl", file)
+		}
+
+		tmpl, err := template.ParseFS(builtinFS, file)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl.Lookup("system") == nil || tmpl.Lookup("user") == nil {
+			return nil, fmt.Errorf("template %s must define system and user", file)
+		}
+
+		if current, ok := builtins[llm.Task(task)]; !ok || version > current.version {
+			builtins[llm.Task(task)] = builtin{version: version, tmpl: tmpl}
+		}
+	}
+	return builtins, nil
+}
+
+// Has reports whether task has a built-in template, and so can be
+// overridden.
+func (r *Registry) Has(task llm.Task) bool {
+	_, ok := r.builtins[task]
+	return ok
+}
+
+// Render fills the prompts of task with data. An override that fails to
+// render is logged and the built-in template is used instead.
+func (r *Registry) Render(ctx context.Context, task llm.Task, scope Scope, data any) (Prompt, error) {
+	override, err := r.override(ctx, task, scope)
+	if err != nil {
+		log.Printf("Failed to look up %s prompt template: %v", task, err)
+	}
+	if override != nil {
+		prompt, err := renderOverride(override, data)
+		if err == nil {
+			return prompt, nil
+		}
+		log.Printf("Prompt template %s is broken, using the built-in one: %v", override.Label(), err)
+	}
+
+	b, ok := r.builtins[task]
+	if !ok {
+		return Prompt{}, fmt.Errorf("no prompt template for task %s", task)
+	}
+
+	system, err := execute(b.tmpl.Lookup("system"), data)
+	if err != nil {
+		return Prompt{}, err
+	}
+	user, err := execute(b.tmpl.Lookup("user"), data)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	return Prompt{
+		System:  system,
+		User:    user,
+		Version: fmt.Sprintf("builtin:%s:v%d", task, b.version),
+	}, nil
+}
+
+// override returns the template that applies to scope, or nil.
+func (r *Registry) override(ctx context.Context, task llm.Task, scope Scope) (*models.PromptTemplate, error) {
+	conditions := r.db.Where("library_id IS NULL AND book_id IS NULL")
+	if scope.LibraryID != 0 {
+		conditions = conditions.Or("library_id = ? AND book_id IS NULL", scope.LibraryID)
+	}
+	if scope.BookID != 0 {
+		conditions = conditions.Or("book_id = ?", scope.BookID)
+	}
+
+	var row models.PromptTemplate
+	err := r.db.WithContext(ctx).
+		Where("task = ?", task).
+		Where(conditions).
+		Order("book_id IS NULL, library_id IS NULL, version DESC").
+		First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func renderOverride(row *models.PromptTemplate, data any) (Prompt, error) {
+	system, user, err := parse(row.System, row.User)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	prompt := Prompt{Version: row.Label(), TemplateID: &row.ID}
+	if prompt.System, err = execute(system, data); err != nil {
+		return Prompt{}, err
+	}
+	if prompt.User, err = execute(user, data); err != nil {
+		return Prompt{}, err
+	}
+	return prompt, nil
+}
+
+// Validate checks that an override parses and renders with example data.
+func (r *Registry) Validate(task llm.Task, systemSrc, userSrc string) error {
+	if !r.Has(task) {
+		return fmt.Errorf("unknown prompt task %s", task)
+	}
+
+	system, user, err := parse(systemSrc, userSrc)
+	if err != nil {
+		return err
+	}
+
+	data := exampleData[task]
+	if _, err := execute(system, data); err != nil {
+		return err
+	}
+	_, err = execute(user, data)
+	return err
+}
+
+// Builtin returns the sources of the built-in template of task.
+func (r *Registry) Builtin(task llm.Task) (system, user, version string, ok bool) {
+	b, ok := r.builtins[task]
+	if !ok {
+		return "", "", "", false
+	}
+	return b.tmpl.Lookup("system").Tree.Root.String(),
+		b.tmpl.Lookup("user").Tree.Root.String(),
+		fmt.Sprintf("builtin:%s:v%d", task, b.version),
+		true
+}
+
+func parse(systemSrc, userSrc string) (*template.Template, *template.Template, error) {
+	system, err := template.New("system").Option("missingkey=error").Parse(systemSrc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("system template: %w", err)
+	}
+	user, err := template.New("user").Option("missingkey=error").Parse(userSrc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user template: %w", err)
+	}
+	return system, user, nil
+}
+
+func execute(tmpl *template.Template, data any) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("%s template: %w", tmpl.Name(), err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,147 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func bitsOf(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func rangeBits(lo, hi, step int) uint64 {
+	var bits uint64
+	for v := lo; v <= hi; v += step {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec                          string
+		minute, hour, dom, month, dow uint64
+	}{
+		{"* * * * *", rangeBits(0, 59, 1), rangeBits(0, 23, 1), rangeBits(1, 31, 1), rangeBits(1, 12, 1), rangeBits(0, 6, 1) | bitsOf(0)},
+		{"30 2 * * *", bitsOf(30), bitsOf(2), rangeBits(1, 31, 1), rangeBits(1, 12, 1), rangeBits(0, 6, 1)},
+		{"*/15 */6 * * *", bitsOf(0, 15, 30, 45), bitsOf(0, 6, 12, 18), rangeBits(1, 31, 1), rangeBits(1, 12, 1), rangeBits(0, 6, 1)},
+		{"0-10/5 9-17 1,15 1-3 *", bitsOf(0, 5, 10), rangeBits(9, 17, 1), bitsOf(1, 15), bitsOf(1, 2, 3), rangeBits(0, 6, 1)},
+		{"5/20 0 * 6,12 1-5", bitsOf(5, 25, 45), bitsOf(0), rangeBits(1, 31, 1), bitsOf(6, 12), rangeBits(1, 5, 1)},
+		{"0 0 * * 7", bitsOf(0), bitsOf(0), rangeBits(1, 31, 1), rangeBits(1, 12, 1), bitsOf(0)},
+		{"0 0 * * 5-7", bitsOf(0), bitsOf(0), rangeBits(1, 31, 1), rangeBits(1, 12, 1), bitsOf(0, 5, 6)},
+		{"  1   2  3 4 5  ", bitsOf(1), bitsOf(2), bitsOf(3), bitsOf(4), bitsOf(5)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		got := [5]uint64{s.minute, s.hour, s.dom, s.month, s.dow}
+		want := [5]uint64{tt.minute, tt.hour, tt.dom, tt.month, tt.dow}
+		for i, name := range []string{"minute", "hour", "day of month", "month", "day of week"} {
+			if got[i] != want[i] {
+				t.Errorf("Parse(%q) %s = %b, want %b", tt.spec, name, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "5 fields"},
+		{"* * * *", "5 fields"},
+		{"* * * * * *", "5 fields"},
+		{"60 * * * *", "outside"},
+		{"* 24 * * *", "outside"},
+		{"* * 0 * *", "outside"},
+		{"* * 32 * *", "outside"},
+		{"* * * 0 *", "outside"},
+		{"* * * 13 *", "outside"},
+		{"* * * * 8", "outside"},
+		{"10-5 * * * *", "outside"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"1/-2 * * * *", "invalid step"},
+		{"a * * * *", "invalid value"},
+		{"1,,2 * * * *", "invalid value"},
+		{"1-x * * * *", "invalid range"},
+		{"0 0 30 2 *", "never matches"},
+		{"0 0 31 4,6,9,11 *", "never matches"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want one containing %q", tt.spec, err, tt.want)
+		}
+	}
+
+	// A restricted day of week matches some day of any month
+	if _, err := Parse("0 0 31 2 1"); err != nil {
+		t.Errorf("Parse with both day fields restricted: %v", err)
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(value string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from string
+		want string
+	}{
+		{"next step", "*/15 * * * *", "2025-03-10 10:07:30", "2025-03-10 10:15:00"},
+		{"strictly after", "0 * * * *", "2025-03-10 10:00:00", "2025-03-10 11:00:00"},
+		{"hour boundary", "*/15 * * * *", "2025-03-10 10:59:30", "2025-03-10 11:00:00"},
+		{"day boundary", "30 2 * * *", "2025-03-10 03:00:00", "2025-03-11 02:30:00"},
+		{"month boundary", "30 2 * * *", "2025-01-31 03:00:00", "2025-02-01 02:30:00"},
+		{"year boundary", "0 0 1 * *", "2025-12-15 08:00:00", "2026-01-01 00:00:00"},
+		{"month list", "0 6 1 1,7 *", "2025-02-01 00:00:00", "2025-07-01 06:00:00"},
+		{"day of week", "0 12 * * 1", "2025-03-02 13:00:00", "2025-03-03 12:00:00"},
+		{"sunday as 7", "5 4 * * 7", "2025-03-03 00:00:00", "2025-03-09 04:05:00"},
+		{"either day field", "0 0 15 * 1", "2025-03-04 00:00:00", "2025-03-10 00:00:00"},
+		{"short month skipped", "0 0 31 * *", "2025-04-01 00:00:00", "2025-05-31 00:00:00"},
+		{"leap day", "0 0 29 2 *", "2025-03-01 00:00:00", "2028-02-29 00:00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.spec, err)
+			}
+			if got, want := s.Next(at(tt.from)), at(tt.want); !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got.Format(time.DateTime), want.Format(time.DateTime))
+			}
+		})
+	}
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	zone := time.FixedZone("UTC+5:30", 5*3600+1800)
+	s, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := s.Next(time.Date(2025, 3, 10, 10, 0, 0, 0, zone))
+	want := time.Date(2025, 3, 11, 9, 0, 0, 0, zone)
+	if !got.Equal(want) || got.Location() != zone {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
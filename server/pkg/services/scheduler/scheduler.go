@@ -0,0 +1,287 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tickInterval is how often the scheduler looks for due jobs.
+const tickInterval = 15 * time.Second
+
+// leaseSlack is added to a job's timeout so the lease outlives the run.
+const leaseSlack = time.Minute
+
+const (
+	statusSucceeded = "succeeded"
+	statusFailed    = "failed"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown scheduled job")
+	ErrJobRunning = errors.New("scheduled job is already running")
+)
+
+// RunFunc does a job's work and returns a short summary of what it did.
+type RunFunc func(ctx context.Context) (string, error)
+
+type job struct {
+	name        string
+	description string
+	spec        string
+	schedule    *Schedule
+	timeout     time.Duration
+	run         RunFunc
+}
+
+// Scheduler runs registered jobs on their cron specs. Runs are coordinated
+// through the scheduled_jobs table: an instance has to take the row's lease
+// before running a job, so with several servers up each run happens once.
+type Scheduler struct {
+	db    *gorm.DB
+	owner string
+
+	mu      sync.Mutex
+	ctx     context.Context
+	jobs    []*job
+	running sync.WaitGroup
+}
+
+// New returns a scheduler that takes leases under the given owner name.
+func New(owner string) *Scheduler {
+	return &Scheduler{
+		db:    db.GetBooktureDB().DB,
+		owner: owner,
+		ctx:   context.Background(),
+	}
+}
+
+// Register adds a job with a cron spec evaluated in UTC. timeout bounds a
+// single run. When the spec differs from the stored one the next run is
+// recomputed.
+func (s *Scheduler) Register(name, spec, description string, timeout time.Duration, run RunFunc) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	j := &job{
+		name:        name,
+		description: description,
+		spec:        spec,
+		schedule:    schedule,
+		timeout:     timeout,
+		run:         run,
+	}
+	if err := s.sync(j); err != nil {
+		return fmt.Errorf("failed to register job %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, j)
+	return nil
+}
+
+func (s *Scheduler) sync(j *job) error {
+	next := j.schedule.Next(time.Now().UTC())
+
+	var row models.ScheduledJob
+	err := s.db.Where("name = ?", j.name).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		row = models.ScheduledJob{Name: j.name, Spec: j.spec, NextRunAt: &next}
+		return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	if row.Spec == j.spec {
+		return nil
+	}
+	return s.db.Model(&row).Updates(map[string]any{"spec": j.spec, "next_run_at": next}).Error
+}
+
+// Run starts due jobs until ctx is done, then waits for running ones.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.running.Wait()
+			return
+		case <-ticker.C:
+			for _, j := range s.registered() {
+				if s.acquire(j, false) {
+					s.start(j)
+				}
+			}
+		}
+	}
+}
+
+// Trigger runs a job now, outside its schedule.
+func (s *Scheduler) Trigger(name string) error {
+	j := s.find(name)
+	if j == nil {
+		return ErrUnknownJob
+	}
+	if !s.acquire(j, true) {
+		return ErrJobRunning
+	}
+
+	s.start(j)
+	return nil
+}
+
+// List returns the registered jobs with their last run.
+func (s *Scheduler) List() ([]views.ScheduledJobView, error) {
+	jobs := s.registered()
+	names := make([]string, len(jobs))
+	for i, j := range jobs {
+		names[i] = j.name
+	}
+
+	var rows []models.ScheduledJob
+	if err := s.db.Where("name IN ?", names).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*models.ScheduledJob, len(rows))
+	for i := range rows {
+		byName[rows[i].Name] = &rows[i]
+	}
+
+	now := time.Now()
+	result := make([]views.ScheduledJobView, 0, len(jobs))
+	for _, j := range jobs {
+		v := views.ScheduledJobView{Name: j.name, Description: j.description, Spec: j.spec}
+		if row, ok := byName[j.name]; ok {
+			v.Running = row.LockedUntil != nil && row.LockedUntil.After(now)
+			v.NextRunAt = row.NextRunAt
+			v.LastRunAt = row.LastRunAt
+			v.LastFinishedAt = row.LastFinishedAt
+			v.LastStatus = row.LastStatus
+			v.LastResult = row.LastResult
+			v.LastError = row.LastError
+			v.LastDurationMs = row.LastDurationMs
+			v.LastRunBy = row.LastRunBy
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// acquire takes the job's lease. Unless forced, the job must also be due.
+func (s *Scheduler) acquire(j *job, force bool) bool {
+	now := time.Now()
+
+	query := s.db.Model(&models.ScheduledJob{}).
+		Where("name = ?", j.name).
+		Where("locked_until IS NULL OR locked_until < ?", now)
+	if !force {
+		query = query.Where("next_run_at <= ?", now)
+	}
+
+	res := query.Updates(map[string]any{
+		"locked_by":    s.owner,
+		"locked_until": now.Add(j.timeout + leaseSlack),
+		"last_run_at":  now,
+		"last_run_by":  s.owner,
+	})
+	if res.Error != nil {
+		log.Printf("Scheduler: failed to lease job %s: %v", j.name, res.Error)
+		return false
+	}
+	return res.RowsAffected == 1
+}
+
+func (s *Scheduler) start(j *job) {
+	s.mu.Lock()
+	parent := s.ctx
+	s.mu.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+
+		ctx, cancel := context.WithTimeout(parent, j.timeout)
+		defer cancel()
+
+		log.Printf("Scheduler: running job %s", j.name)
+		started := time.Now()
+		result, err := safeRun(ctx, j.run)
+		s.finish(j, started, result, err)
+	}()
+}
+
+// finish records the run and releases the lease. It uses the plain db handle
+// so a run cut short by shutdown is still recorded.
+func (s *Scheduler) finish(j *job, started time.Time, result string, err error) {
+	now := time.Now()
+	next := j.schedule.Next(now.UTC())
+
+	updates := map[string]any{
+		"next_run_at":      next,
+		"locked_by":        "",
+		"locked_until":     nil,
+		"last_finished_at": now,
+		"last_status":      statusSucceeded,
+		"last_result":      result,
+		"last_error":       "",
+		"last_duration_ms": now.Sub(started).Milliseconds(),
+	}
+	if err != nil {
+		updates["last_status"] = statusFailed
+		updates["last_error"] = err.Error()
+		log.Printf("Scheduler: job %s failed: %v", j.name, err)
+	} else {
+		log.Printf("Scheduler: job %s finished: %s", j.name, result)
+	}
+
+	err = s.db.Model(&models.ScheduledJob{}).
+		Where("name = ? AND locked_by = ?", j.name, s.owner).
+		Updates(updates).Error
+	if err != nil {
+		log.Printf("Scheduler: failed to record run of job %s: %v", j.name, err)
+	}
+}
+
+func (s *Scheduler) registered() []*job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*job(nil), s.jobs...)
+}
+
+func (s *Scheduler) find(name string) *job {
+	for _, j := range s.registered() {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// safeRun turns a panicking job into a failed run.
+func safeRun(ctx context.Context, run RunFunc) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return run(ctx)
+}
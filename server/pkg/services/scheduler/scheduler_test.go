@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db/dbtest"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+)
+
+func TestOneInstanceTakesTheLease(t *testing.T) {
+	conn := dbtest.Open(t)
+
+	var runs atomic.Int32
+	release := make(chan struct{})
+	run := func(ctx context.Context) (string, error) {
+		runs.Add(1)
+		<-release
+		return "done", nil
+	}
+
+	instances := []*Scheduler{New("server-a"), New("server-b")}
+	for _, s := range instances {
+		if err := s.Register("compact", "0 3 * * *", "Compacts", time.Minute, run); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+
+	// Make the job due
+	past := time.Now().Add(-time.Minute)
+	conn.Model(&models.ScheduledJob{}).Where("name = ?", "compact").Update("next_run_at", past)
+
+	var wg sync.WaitGroup
+	var acquired atomic.Int32
+	for _, s := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j := s.find("compact")
+			if s.acquire(j, false) {
+				acquired.Add(1)
+				s.start(j)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := acquired.Load(); n != 1 {
+		t.Fatalf("%d instances took the lease, want 1", n)
+	}
+
+	// A held lease also blocks a manual trigger on either instance
+	for _, s := range instances {
+		if err := s.Trigger("compact"); !errors.Is(err, ErrJobRunning) {
+			t.Errorf("Trigger while running = %v, want ErrJobRunning", err)
+		}
+	}
+
+	close(release)
+	for _, s := range instances {
+		s.running.Wait()
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("job ran %d times, want once", n)
+	}
+
+	var row models.ScheduledJob
+	conn.Where("name = ?", "compact").First(&row)
+	if row.LockedUntil != nil || row.LastStatus != statusSucceeded || row.LastResult != "done" {
+		t.Errorf("after the run: locked until %v, status %q, result %q", row.LockedUntil, row.LastStatus, row.LastResult)
+	}
+	if row.NextRunAt == nil || !row.NextRunAt.After(time.Now()) {
+		t.Errorf("next run at %v, want the next 03:00", row.NextRunAt)
+	}
+
+	// Not due any more, so neither instance takes it
+	for _, s := range instances {
+		if s.acquire(s.find("compact"), false) {
+			t.Error("an instance took the lease of a job that is not due")
+		}
+	}
+}
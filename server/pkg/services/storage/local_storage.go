@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type LocalStorage struct {
@@ -41,6 +42,45 @@ func (s *LocalStorage) SaveBookFile(bookID, volumeID string, file io.Reader) (st
 	return filePath, nil
 }
 
+func (s *LocalStorage) SaveAsset(bookID, volumeID, name string, file io.Reader) (string, error) {
+	dirPath := filepath.Join(s.basePath, "book_"+bookID, "vol_"+volumeID, "assets")
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create asset directory: %w", err)
+	}
+
+	// Written aside and renamed, so a reader never sees half a file
+	filePath := filepath.Join(dirPath, filepath.Base(name))
+	outFile, err := os.CreateTemp(dirPath, ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer os.Remove(outFile.Name())
+
+	if _, err := io.Copy(outFile, file); err != nil {
+		outFile.Close()
+		return "", fmt.Errorf("failed to write file content: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to write file content: %w", err)
+	}
+	if err := os.Rename(outFile.Name(), filePath); err != nil {
+		return "", fmt.Errorf("failed to store asset: %w", err)
+	}
+
+	return filePath, nil
+}
+
+func (s *LocalStorage) OpenFile(path string) (io.ReadSeekCloser, error) {
+	return os.Open(path)
+}
+
+func (s *LocalStorage) DeleteFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
 func (s *LocalStorage) GetPath(bookID, relativePath string) string {
 	return filepath.Join(s.basePath, "book_"+bookID, relativePath)
 }
@@ -52,3 +92,48 @@ func (s *LocalStorage) HealthCheck() error {
 
 	return nil
 }
+
+func (s *LocalStorage) ListVolumes() ([]StoredVolume, error) {
+	bookDirs, err := os.ReadDir(s.basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage directory: %w", err)
+	}
+
+	var volumes []StoredVolume
+	for _, bookDir := range bookDirs {
+		bookID, ok := strings.CutPrefix(bookDir.Name(), "book_")
+		if !bookDir.IsDir() || !ok {
+			continue
+		}
+
+		volDirs, err := os.ReadDir(filepath.Join(s.basePath, bookDir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read book directory: %w", err)
+		}
+
+		for _, volDir := range volDirs {
+			volumeID, ok := strings.CutPrefix(volDir.Name(), "vol_")
+			if !volDir.IsDir() || !ok {
+				continue
+			}
+
+			info, err := volDir.Info()
+			if err != nil {
+				continue
+			}
+			volumes = append(volumes, StoredVolume{BookID: bookID, VolumeID: volumeID, ModifiedAt: info.ModTime()})
+		}
+	}
+	return volumes, nil
+}
+
+func (s *LocalStorage) DeleteVolume(bookID, volumeID string) error {
+	bookPath := filepath.Join(s.basePath, "book_"+bookID)
+	if err := os.RemoveAll(filepath.Join(bookPath, "vol_"+volumeID)); err != nil {
+		return fmt.Errorf("failed to delete volume directory: %w", err)
+	}
+
+	// Only succeeds when no other volume is left
+	_ = os.Remove(bookPath)
+	return nil
+}
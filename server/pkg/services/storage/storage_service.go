@@ -2,6 +2,7 @@ package storage
 
 import (
 	"io"
+	"time"
 
 	"github.com/Mahaveer86619/bookture/server/pkg/config"
 )
@@ -9,8 +10,26 @@ import (
 type StorageService interface {
 	Init() error
 	SaveBookFile(bookID, volumeID string, file io.Reader) (string, error)
+	// SaveAsset writes a generated file of a volume, replacing one of the
+	// same name, and returns its path.
+	SaveAsset(bookID, volumeID, name string, file io.Reader) (string, error)
+	// OpenFile opens a file by the path SaveBookFile or SaveAsset returned.
+	OpenFile(path string) (io.ReadSeekCloser, error)
+	// DeleteFile removes a file by its path. A missing file is not an error.
+	DeleteFile(path string) error
 	GetPath(bookID, relativePath string) string
 	HealthCheck() error
+	// ListVolumes returns every volume that has files in storage.
+	ListVolumes() ([]StoredVolume, error)
+	// DeleteVolume removes a volume's files, and its book's once empty.
+	DeleteVolume(bookID, volumeID string) error
+}
+
+// StoredVolume is a volume directory found in storage.
+type StoredVolume struct {
+	BookID     string
+	VolumeID   string
+	ModifiedAt time.Time
 }
 
 func NewStorageService() StorageService {
@@ -0,0 +1,436 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/config"
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/parser"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/progress"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+	"gorm.io/gorm"
+)
+
+// etaWindow limits the history used for remaining-time estimates.
+const etaWindow = 30 * 24 * time.Hour
+
+// stageOrder is the order in which a task kind runs its stages.
+var stageOrder = map[string][]enums.TaskStage{
+	taskKindParse:  {enums.StageParsing, enums.StageMetadata, enums.StageStructure, enums.StageScenes, enums.StageImages},
+	taskKindScenes: {enums.StageScenes},
+	taskKindImages: {enums.StageImages},
+	taskKindResume: {enums.StageScenes, enums.StageImages},
+}
+
+// taskRecorder writes a TaskRun and its stages from a job's progress events.
+// Writes go through the plain db handle so a cancelled job is still recorded.
+type taskRecorder struct {
+	db   *gorm.DB
+	stop chan struct{}
+
+	mu          sync.Mutex
+	run         models.TaskRun
+	stage       *models.TaskStageRun
+	stageErrors []string
+	answered    bool // an LLM answered a call, not the cache
+}
+
+func startTaskRecorder(db *gorm.DB, taskID, kind string, volumeID, userID uint) *taskRecorder {
+	cfg := config.AppConfig
+	now := time.Now()
+	r := &taskRecorder{
+		db:   db,
+		stop: make(chan struct{}),
+		run: models.TaskRun{
+			TaskID:        taskID,
+			Kind:          kind,
+			VolumeID:      volumeID,
+			UserID:        userID,
+			Status:        enums.TaskRunning.ToString(),
+			CurrentStage:  enums.StageQueued.ToString(),
+			LLMProvider:   cfg.LLM_PROVIDER,
+			LLMModel:      cfg.LLM_MODEL,
+			ImageProvider: cfg.IMAGE_PROVIDER,
+			ImageModel:    cfg.IMAGE_MODEL,
+			StartedAt:     now,
+			HeartbeatAt:   &now,
+		},
+	}
+
+	if err := db.Create(&r.run).Error; err != nil {
+		log.Printf("Failed to record task %s: %v", taskID, err)
+	}
+	go r.heartbeat()
+	return r
+}
+
+// heartbeat refreshes the run's heartbeat until finish, so every instance
+// can tell a live run from one whose process died.
+func (r *taskRecorder) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			now := time.Now()
+			r.run.HeartbeatAt = &now
+			if r.run.ID != 0 {
+				r.db.Model(&r.run).UpdateColumn("heartbeat_at", &now)
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// attach records the events of report and the LLM answers of calls made
+// with the returned context.
+func (r *taskRecorder) attach(ctx context.Context, report *progress.Reporter) context.Context {
+	report.OnEvent(r.observe)
+	report.OnCall(r.call)
+	return llm.OnAnswer(ctx, r.llmAnswered)
+}
+
+// llmAnswered records which provider and model did the run's LLM work, as
+// remaining-time estimates compare runs by it. The configured ones are only
+// a guess until the first answer, as the router may fall back.
+func (r *taskRecorder) llmAnswered(info llm.CallInfo) {
+	if info.Cached {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.answered = true
+	if r.run.LLMProvider != info.Provider || r.run.LLMModel != info.Model {
+		r.run.LLMProvider, r.run.LLMModel = info.Provider, info.Model
+		r.saveRun()
+	}
+}
+
+func (r *taskRecorder) observe(e views.TaskEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.run.Progress = e.Progress
+
+	switch enums.TaskEventType(e.Type) {
+	case enums.EventStage:
+		if e.Stage == r.run.CurrentStage {
+			return
+		}
+		r.closeStage(time.Now())
+		r.openStage(enums.TaskStage(e.Stage))
+		r.saveRun()
+	case enums.EventChapter:
+		r.setItems(e.Chapter, e.TotalChapters)
+	case enums.EventImage:
+		r.setItems(e.ImagesGenerated, e.TotalImages)
+	case enums.EventRetry:
+		r.run.Retries++
+		if r.stage != nil {
+			r.stage.Retries++
+		}
+	case enums.EventRateLimited:
+		wait := int64(e.WaitSeconds) * 1000
+		r.run.RateLimitWaitMs += wait
+		if r.stage != nil {
+			r.stage.RateLimitWaitMs += wait
+		}
+	case enums.EventWarning:
+		r.run.Warnings++
+		r.stageErrors = append(r.stageErrors, e.Message)
+	}
+}
+
+func (r *taskRecorder) call(kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch kind {
+	case progress.CallLLM:
+		r.run.LLMCalls++
+		if r.stage != nil {
+			r.stage.LLMCalls++
+		}
+	case progress.CallImage:
+		r.run.ImageCalls++
+		if r.stage != nil {
+			r.stage.ImageCalls++
+		}
+	}
+}
+
+// setItems records how far the current stage is. Caller holds mu.
+func (r *taskRecorder) setItems(done, total int) {
+	r.run.ItemsDone = done
+	r.run.ItemsTotal = total
+	if r.stage != nil {
+		r.stage.Items = done
+		r.db.Save(r.stage)
+	}
+	r.saveRun()
+}
+
+// openStage starts a stage row. Caller holds mu.
+func (r *taskRecorder) openStage(stage enums.TaskStage) {
+	r.run.CurrentStage = stage.ToString()
+	r.run.ItemsDone = 0
+	r.run.ItemsTotal = 0
+	if stage == enums.StageQueued || stage == enums.StageFinished {
+		return
+	}
+
+	r.stage = &models.TaskStageRun{
+		TaskRunID: r.run.ID,
+		Stage:     stage.ToString(),
+		StartedAt: time.Now(),
+	}
+	if err := r.db.Create(r.stage).Error; err != nil {
+		log.Printf("Failed to record stage %s of task %s: %v", stage, r.run.TaskID, err)
+	}
+}
+
+// closeStage finishes the current stage row. Caller holds mu.
+func (r *taskRecorder) closeStage(now time.Time) {
+	if r.stage == nil {
+		return
+	}
+
+	r.stage.FinishedAt = &now
+	r.stage.DurationMs = now.Sub(r.stage.StartedAt).Milliseconds()
+	if len(r.stageErrors) > 0 {
+		errorsJSON, _ := json.Marshal(r.stageErrors)
+		r.stage.Errors = string(errorsJSON)
+	}
+	r.db.Save(r.stage)
+
+	r.stage = nil
+	r.stageErrors = nil
+}
+
+// saveRun persists the run row. Caller holds mu.
+func (r *taskRecorder) saveRun() {
+	if r.run.ID == 0 {
+		return
+	}
+	if err := r.db.Save(&r.run).Error; err != nil {
+		log.Printf("Failed to update history of task %s: %v", r.run.TaskID, err)
+	}
+}
+
+// finish records how the job ended. ctx is the job's context.
+func (r *taskRecorder) finish(ctx context.Context, err error) {
+	close(r.stop)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := enums.TaskCompleted
+	switch {
+	case errors.Is(context.Cause(ctx), parser.ErrInterrupted):
+		status = enums.TaskCancelled
+		err = parser.ErrInterrupted
+	case ctx.Err() != nil:
+		status = enums.TaskCancelled
+		err = nil
+	case err != nil:
+		status = enums.TaskError
+	}
+
+	if err != nil {
+		r.run.Error = err.Error()
+		r.stageErrors = append(r.stageErrors, err.Error())
+	}
+
+	now := time.Now()
+	r.closeStage(now)
+
+	if r.run.LLMCalls > 0 && !r.answered {
+		// Replies from the cache say nothing about a provider's speed
+		r.run.LLMProvider, r.run.LLMModel = "", ""
+	}
+	r.run.Status = status.ToString()
+	r.run.CurrentStage = enums.StageFinished.ToString()
+	r.run.FinishedAt = &now
+	r.run.DurationMs = now.Sub(r.run.StartedAt).Milliseconds()
+	if status == enums.TaskCompleted {
+		r.run.Progress = 100
+	}
+	r.saveRun()
+}
+
+// TaskHistoryService answers questions about past and running tasks.
+type TaskHistoryService struct {
+	db *gorm.DB
+}
+
+func NewTaskHistoryService() *TaskHistoryService {
+	return &TaskHistoryService{
+		db: db.GetBooktureDB().DB,
+	}
+}
+
+// VolumeHistory lists the task runs of a volume owned by the user, newest first.
+func (ths *TaskHistoryService) VolumeHistory(userID, volumeID uint) ([]views.TaskRunView, error) {
+	var count int64
+	err := ths.db.Model(&models.Volume{}).
+		Joins("JOIN books ON books.id = volumes.book_id").
+		Joins("JOIN libraries ON libraries.id = books.library_id").
+		Where("volumes.id = ? AND libraries.user_id = ?", volumeID, userID).
+		Count(&count).Error
+	if err != nil {
+		return nil, errz.New(errz.InternalServerError, "Database error", err)
+	}
+	if count == 0 {
+		return nil, errz.New(errz.NotFound, "Volume not found", nil)
+	}
+
+	var runs []models.TaskRun
+	err = ths.db.Where("volume_id = ?", volumeID).
+		Preload("Stages", func(db *gorm.DB) *gorm.DB {
+			return db.Order("task_stage_runs.started_at ASC")
+		}).
+		Order("started_at DESC").
+		Find(&runs).Error
+	if err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to load task history", err)
+	}
+
+	result := make([]views.TaskRunView, len(runs))
+	for i := range runs {
+		result[i] = views.ToTaskRunView(&runs[i])
+		if runs[i].Status == enums.TaskRunning.ToString() {
+			if eta, ok := ths.estimate(&runs[i]); ok {
+				seconds := int(eta.Seconds())
+				result[i].ETASeconds = &seconds
+			}
+		}
+	}
+	return result, nil
+}
+
+// Latest returns the most recent run of a task.
+func (ths *TaskHistoryService) Latest(taskID string) (*models.TaskRun, error) {
+	var run models.TaskRun
+	if err := ths.db.Where("task_id = ?", taskID).Order("id DESC").First(&run).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// IsLive reports whether a run of the task is going on anywhere, judged by
+// its heartbeat rather than by the local queue.
+func (ths *TaskHistoryService) IsLive(taskID string) bool {
+	return liveTaskRun(ths.db, taskID)
+}
+
+func liveTaskRun(db *gorm.DB, taskID string) bool {
+	var count int64
+	db.Model(&models.TaskRun{}).
+		Where("task_id = ? AND status = ? AND heartbeat_at > ?", taskID, enums.TaskRunning.ToString(), time.Now().Add(-staleAfter)).
+		Count(&count)
+	return count > 0
+}
+
+// Estimate returns the remaining time of a running task.
+func (ths *TaskHistoryService) Estimate(taskID string) (time.Duration, bool) {
+	run, err := ths.Latest(taskID)
+	if err != nil || run.Status != enums.TaskRunning.ToString() {
+		return 0, false
+	}
+	return ths.estimate(run)
+}
+
+// estimate adds up what is left of the current stage and the typical
+// duration of the stages after it, using recent runs on the same provider
+// and model.
+func (ths *TaskHistoryService) estimate(run *models.TaskRun) (time.Duration, bool) {
+	stages := stageOrder[run.Kind]
+
+	var remaining time.Duration
+	reached := false
+	known := false
+
+	for _, stage := range stages {
+		if !reached {
+			if stage.ToString() != run.CurrentStage {
+				continue
+			}
+			reached = true
+
+			avg, perItem, ok := ths.throughput(run, stage)
+			if !ok {
+				continue
+			}
+			known = true
+
+			if perItem > 0 && run.ItemsTotal > 0 {
+				remaining += time.Duration(run.ItemsTotal-run.ItemsDone) * perItem
+				continue
+			}
+
+			var current models.TaskStageRun
+			err := ths.db.Where("task_run_id = ? AND stage = ?", run.ID, stage.ToString()).
+				Order("id DESC").First(&current).Error
+			if left := avg - time.Since(current.StartedAt); err == nil && left > 0 {
+				remaining += left
+			}
+			continue
+		}
+
+		if avg, _, ok := ths.throughput(run, stage); ok {
+			remaining += avg
+			known = true
+		}
+	}
+
+	return remaining, known
+}
+
+// throughput returns the average duration of a stage and, for stages that
+// work through chapters or images, the average time per item.
+func (ths *TaskHistoryService) throughput(run *models.TaskRun, stage enums.TaskStage) (time.Duration, time.Duration, bool) {
+	query := ths.db.Model(&models.TaskStageRun{}).
+		Joins("JOIN task_runs ON task_runs.id = task_stage_runs.task_run_id").
+		Where("task_stage_runs.stage = ? AND task_stage_runs.finished_at IS NOT NULL", stage.ToString()).
+		Where("task_runs.status = ? AND task_runs.started_at > ?", enums.TaskCompleted.ToString(), time.Now().Add(-etaWindow))
+
+	switch stage {
+	case enums.StageMetadata, enums.StageScenes:
+		query = query.Where("task_runs.llm_provider = ? AND task_runs.llm_model = ?", run.LLMProvider, run.LLMModel)
+	case enums.StageImages:
+		query = query.Where("task_runs.image_provider = ? AND task_runs.image_model = ?", run.ImageProvider, run.ImageModel)
+	}
+
+	var stats struct {
+		Runs    int64
+		TotalMs int64
+		Items   int64
+	}
+	err := query.Select("COUNT(*) AS runs, COALESCE(SUM(task_stage_runs.duration_ms), 0) AS total_ms, COALESCE(SUM(task_stage_runs.items), 0) AS items").
+		Scan(&stats).Error
+	if err != nil || stats.Runs == 0 {
+		return 0, 0, false
+	}
+
+	avg := time.Duration(stats.TotalMs/stats.Runs) * time.Millisecond
+	var perItem time.Duration
+	if stats.Items > 0 {
+		perItem = time.Duration(stats.TotalMs/stats.Items) * time.Millisecond
+	}
+	return avg, perItem, true
+}
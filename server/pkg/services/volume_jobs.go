@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/parser"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/progress"
+	"gorm.io/gorm"
+)
+
+// queueRetryAfter is the Retry-After hint sent when the queue is full.
+const queueRetryAfter = 30 * time.Second
+
+// taskPollInterval is how often a task stream re-reads its job row in
+// external mode.
+const taskPollInterval = 2 * time.Second
+
+const (
+	taskKindParse  = "parse"
+	taskKindScenes = "scenes"
+	taskKindImages = "images"
+	taskKindResume = "resume"
+)
+
+// volumeTaskID names a job that works on a volume, e.g. "parse-vol-12".
+func volumeTaskID(kind string, volumeID uint) string {
+	return fmt.Sprintf("%s-vol-%d", kind, volumeID)
+}
+
+func volumeIDFromTask(taskID string) (uint, bool) {
+	kind, rawID, ok := strings.Cut(taskID, "-vol-")
+	if !ok {
+		return 0, false
+	}
+
+	var volumeID uint
+	if _, err := fmt.Sscanf(rawID, "%d", &volumeID); err != nil {
+		return 0, false
+	}
+	return volumeID, volumeTaskID(kind, volumeID) == taskID
+}
+
+// volumeJobOptions schedules uploads as bulk work and regenerations, which a
+// user is actively waiting on, as interactive.
+func volumeJobOptions(kind string, userID uint) (JobOptions, error) {
+	switch kind {
+	case taskKindParse, taskKindResume:
+		return JobOptions{UserID: userID, Type: enums.JobTypeParse, Priority: enums.PriorityBulk}, nil
+	case taskKindScenes:
+		return JobOptions{UserID: userID, Type: enums.JobTypeEnhance, Priority: enums.PriorityInteractive}, nil
+	case taskKindImages:
+		return JobOptions{UserID: userID, Type: enums.JobTypeImage, Priority: enums.PriorityInteractive}, nil
+	default:
+		return JobOptions{}, fmt.Errorf("unknown task kind %q", kind)
+	}
+}
+
+// newVolumeJob builds the job for a task kind. Both the in-process
+// ProcessingService and cmd/worker run volumes through it. Every run is
+// recorded in the task history.
+func newVolumeJob(db *gorm.DB, p *parser.ParserService, kind string, volumeID, userID uint) (Job, error) {
+	var run Job
+
+	switch kind {
+	case taskKindParse:
+		run = func(ctx context.Context, report *progress.Reporter) error {
+			report.OnProgress(func(percent int) {
+				db.WithContext(ctx).Model(&models.Volume{}).Where("id = ?", volumeID).Update("progress", percent)
+			})
+			return p.ProcessVolumeComplete(ctx, volumeID, report)
+		}
+	case taskKindScenes:
+		run = func(ctx context.Context, report *progress.Reporter) error {
+			report.Stage(enums.StageScenes, 0)
+			return p.RetrySceneGeneration(ctx, volumeID, report)
+		}
+	case taskKindImages:
+		run = func(ctx context.Context, report *progress.Reporter) error {
+			report.Stage(enums.StageImages, 0)
+			return p.RetryImageGeneration(ctx, volumeID, report)
+		}
+	case taskKindResume:
+		run = func(ctx context.Context, report *progress.Reporter) error {
+			return p.ResumeVolume(ctx, volumeID, report)
+		}
+	default:
+		return nil, fmt.Errorf("unknown task kind %q", kind)
+	}
+
+	return func(ctx context.Context, report *progress.Reporter) error {
+		// Another instance may have picked the volume up in the meantime;
+		// running it twice would have one run clear what the other wrote
+		taskID := volumeTaskID(kind, volumeID)
+		if liveTaskRun(db, taskID) {
+			return fmt.Errorf("%w: %s runs elsewhere", ErrTaskActive, taskID)
+		}
+
+		recorder := startTaskRecorder(db, taskID, kind, volumeID, userID)
+		ctx = recorder.attach(ctx, report)
+
+		err := run(ctx, report)
+		recorder.finish(ctx, err)
+		return err
+	}, nil
+}
@@ -0,0 +1,437 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/errz"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"github.com/Mahaveer86619/bookture/server/pkg/views"
+	"gorm.io/gorm"
+)
+
+const (
+	// deliveryTimeout bounds a single POST to a receiver.
+	deliveryTimeout = 10 * time.Second
+	// deliveryBatch is how many due deliveries a dispatcher claims at once.
+	deliveryBatch = 20
+	// deliveryLease keeps other dispatchers away from a claimed delivery. It
+	// outlasts a batch sent one by one with every receiver timing out, so a
+	// delivery cannot be claimed again while it is still being sent.
+	deliveryLease = deliveryBatch*deliveryTimeout + time.Minute
+	// maxResponseBody is how much of a receiver's answer is kept in the log.
+	maxResponseBody = 1024
+)
+
+// deliveryBackoff is the wait before each retry. A delivery fails for good
+// once it runs out.
+var deliveryBackoff = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	6 * time.Hour,
+}
+
+// WebhookService stores user webhooks and delivers events to them. Emit only
+// writes delivery rows; RunDispatcher sends them, so events raised in
+// cmd/worker are delivered by the API server.
+//
+// Each request carries:
+//
+//	X-Bookture-Event:     the event name, e.g. volume.completed
+//	X-Bookture-Delivery:  the event ID, identical across retries
+//	X-Bookture-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+type WebhookService struct {
+	db     *gorm.DB
+	client *http.Client
+}
+
+func NewWebhookService() *WebhookService {
+	return &WebhookService{
+		db:     db.GetBooktureDB().DB,
+		client: newWebhookClient(),
+	}
+}
+
+// newWebhookClient returns the client deliveries are sent with. Webhook
+// URLs come from users, so it only connects to public addresses, checked
+// after DNS resolution, and does not follow redirects, which could lead
+// anywhere.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: deliveryTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !utils.IsPublicIP(addr.Addr()) {
+				return fmt.Errorf("%s is not a public address", addr.Addr())
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: deliveryTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (ws *WebhookService) CreateWebhook(userID uint, req views.CreateWebhookRequest) (*views.WebhookView, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to generate secret", err)
+	}
+
+	eventsJSON, _ := json.Marshal(req.Events)
+	hook := models.Webhook{
+		UserID:      userID,
+		URL:         req.URL,
+		Secret:      secret,
+		Events:      string(eventsJSON),
+		Description: req.Description,
+		Active:      true,
+	}
+	if err := ws.db.Create(&hook).Error; err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to create webhook", err)
+	}
+
+	v := views.ToWebhookView(&hook)
+	v.Secret = hook.Secret
+	return &v, nil
+}
+
+func (ws *WebhookService) GetWebhooks(userID uint) ([]views.WebhookView, error) {
+	var hooks []models.Webhook
+	if err := ws.db.Where("user_id = ?", userID).Order("id ASC").Find(&hooks).Error; err != nil {
+		return nil, errz.New(errz.InternalServerError, "Database error", err)
+	}
+	return views.ToWebhookViews(hooks), nil
+}
+
+func (ws *WebhookService) UpdateWebhook(userID, id uint, req views.UpdateWebhookRequest) (*views.WebhookView, error) {
+	hook, err := ws.getWebhook(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL != nil {
+		hook.URL = *req.URL
+	}
+	if req.Events != nil {
+		eventsJSON, _ := json.Marshal(req.Events)
+		hook.Events = string(eventsJSON)
+	}
+	if req.Description != nil {
+		hook.Description = *req.Description
+	}
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+
+	if err := ws.db.Save(hook).Error; err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to update webhook", err)
+	}
+
+	v := views.ToWebhookView(hook)
+	return &v, nil
+}
+
+func (ws *WebhookService) DeleteWebhook(userID, id uint) error {
+	res := ws.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Webhook{})
+	if res.Error != nil {
+		return errz.New(errz.InternalServerError, "Database error", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return errz.New(errz.NotFound, "Webhook not found", nil)
+	}
+	return nil
+}
+
+// GetDeliveries returns the newest deliveries of a webhook.
+func (ws *WebhookService) GetDeliveries(userID, id uint, limit int) ([]views.WebhookDeliveryView, error) {
+	if _, err := ws.getWebhook(userID, id); err != nil {
+		return nil, err
+	}
+
+	var deliveries []models.WebhookDelivery
+	err := ws.db.Where("webhook_id = ?", id).Order("id DESC").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, errz.New(errz.InternalServerError, "Database error", err)
+	}
+
+	result := make([]views.WebhookDeliveryView, len(deliveries))
+	for i := range deliveries {
+		result[i] = views.ToWebhookDeliveryView(&deliveries[i])
+	}
+	return result, nil
+}
+
+// SendTest delivers a webhook.test event right away and returns the outcome.
+// Failed test deliveries are retried like any other.
+func (ws *WebhookService) SendTest(userID, id uint) (*views.WebhookDeliveryView, error) {
+	hook, err := ws.getWebhook(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := ws.newDelivery(hook, enums.WebhookTest, map[string]string{
+		"message": "This is a test event from Bookture",
+	})
+	if err != nil {
+		return nil, errz.New(errz.InternalServerError, "Failed to create delivery", err)
+	}
+
+	ws.deliver(context.Background(), delivery, hook)
+
+	v := views.ToWebhookDeliveryView(delivery)
+	return &v, nil
+}
+
+func (ws *WebhookService) getWebhook(userID, id uint) (*models.Webhook, error) {
+	var hook models.Webhook
+	if err := ws.db.Where("id = ? AND user_id = ?", id, userID).First(&hook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errz.New(errz.NotFound, "Webhook not found", err)
+		}
+		return nil, errz.New(errz.InternalServerError, "Database error", err)
+	}
+	return &hook, nil
+}
+
+// Emit queues the event for every active webhook of the user subscribed to it.
+func (ws *WebhookService) Emit(userID uint, event enums.WebhookEvent, data any) {
+	var hooks []models.Webhook
+	if err := ws.db.Where("user_id = ? AND active = ?", userID, true).Find(&hooks).Error; err != nil {
+		log.Printf("Failed to load webhooks for %s: %v", event, err)
+		return
+	}
+
+	eventID, err := randomHex(16)
+	if err != nil {
+		log.Printf("Failed to generate event ID for %s: %v", event, err)
+		return
+	}
+
+	for i := range hooks {
+		var subscribed []string
+		_ = json.Unmarshal([]byte(hooks[i].Events), &subscribed)
+		if !slices.Contains(subscribed, event.ToString()) {
+			continue
+		}
+
+		if _, err := ws.createDelivery(&hooks[i], eventID, event, data); err != nil {
+			log.Printf("Failed to queue %s for webhook %d: %v", event, hooks[i].ID, err)
+		}
+	}
+}
+
+func (ws *WebhookService) newDelivery(hook *models.Webhook, event enums.WebhookEvent, data any) (*models.WebhookDelivery, error) {
+	eventID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	return ws.createDelivery(hook, eventID, event, data)
+}
+
+func (ws *WebhookService) createDelivery(hook *models.Webhook, eventID string, event enums.WebhookEvent, data any) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	payload, err := json.Marshal(views.WebhookPayload{
+		ID:        eventID,
+		Event:     event.ToString(),
+		CreatedAt: now,
+		Data:      data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	delivery := models.WebhookDelivery{
+		WebhookID:     hook.ID,
+		EventID:       eventID,
+		Event:         event.ToString(),
+		Payload:       string(payload),
+		Status:        enums.DeliveryPending.ToString(),
+		NextAttemptAt: &now,
+	}
+	if err := ws.db.Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// RunDispatcher sends due deliveries every interval until ctx is done. Several
+// API instances may run it; deliveries are claimed with SKIP LOCKED.
+func (ws *WebhookService) RunDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ws.dispatchDue(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Webhook dispatcher failed: %v", err)
+			}
+		}
+	}
+}
+
+func (ws *WebhookService) dispatchDue(ctx context.Context) error {
+	due, leaseEnd, err := ws.claimDue(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range due {
+		// Stop before the lease runs out; the rest are claimed again later
+		if ctx.Err() != nil || time.Until(leaseEnd) < deliveryTimeout {
+			return nil
+		}
+
+		var hook models.Webhook
+		if err := ws.db.First(&hook, due[i].WebhookID).Error; err != nil || !hook.Active {
+			ws.fail(&due[i], "webhook deleted or disabled")
+			continue
+		}
+		ws.deliver(ctx, &due[i], &hook)
+	}
+	return nil
+}
+
+// claimDue leases up to deliveryBatch due deliveries to this dispatcher and
+// returns them with the end of the lease.
+func (ws *WebhookService) claimDue(ctx context.Context) ([]models.WebhookDelivery, time.Time, error) {
+	leaseEnd := time.Now().Add(deliveryLease)
+
+	var due []models.WebhookDelivery
+	err := ws.db.WithContext(ctx).Raw(`
+		UPDATE webhook_deliveries
+		SET next_attempt_at = @lease, updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE deleted_at IS NULL AND status = @pending AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at ASC
+			LIMIT @batch
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		map[string]interface{}{
+			"lease":   leaseEnd,
+			"pending": enums.DeliveryPending.ToString(),
+			"batch":   deliveryBatch,
+		}).Scan(&due).Error
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+	return due, leaseEnd, nil
+}
+
+// deliver makes one attempt and records the outcome on the delivery.
+func (ws *WebhookService) deliver(ctx context.Context, d *models.WebhookDelivery, hook *models.Webhook) {
+	d.Attempts++
+
+	code, body, err := ws.post(ctx, hook, d)
+	d.ResponseCode = code
+	d.ResponseBody = body
+	d.Error = ""
+
+	switch {
+	case err == nil && code >= 200 && code < 300:
+		now := time.Now()
+		d.Status = enums.DeliverySucceeded.ToString()
+		d.DeliveredAt = &now
+		d.NextAttemptAt = nil
+	case d.Attempts > len(deliveryBackoff):
+		d.Status = enums.DeliveryFailed.ToString()
+		d.NextAttemptAt = nil
+	default:
+		next := time.Now().Add(deliveryBackoff[d.Attempts-1])
+		d.NextAttemptAt = &next
+	}
+
+	if err != nil {
+		d.Error = err.Error()
+	} else if d.Status != enums.DeliverySucceeded.ToString() {
+		d.Error = fmt.Sprintf("receiver answered %d", code)
+	}
+
+	if err := ws.db.Save(d).Error; err != nil {
+		log.Printf("Failed to record delivery %d: %v", d.ID, err)
+	}
+}
+
+func (ws *WebhookService) fail(d *models.WebhookDelivery, reason string) {
+	d.Status = enums.DeliveryFailed.ToString()
+	d.NextAttemptAt = nil
+	d.Error = reason
+	ws.db.Save(d)
+}
+
+func (ws *WebhookService) post(ctx context.Context, hook *models.Webhook, d *models.WebhookDelivery) (int, string, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Bookture-Webhooks/1.0")
+	req.Header.Set("X-Bookture-Event", d.Event)
+	req.Header.Set("X-Bookture-Delivery", d.EventID)
+	req.Header.Set("X-Bookture-Signature", "t="+timestamp+",v1="+signPayload(hook.Secret, timestamp, d.Payload))
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return resp.StatusCode, string(body), nil
+}
+
+// signPayload is the hex HMAC-SHA256 of "<timestamp>.<payload>".
+func signPayload(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
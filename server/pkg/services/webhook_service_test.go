@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db/dbtest"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+)
+
+// webhookFixture creates a webhook posting to url with count due
+// deliveries.
+func webhookFixture(t *testing.T, ws *WebhookService, url string, count int) []*models.WebhookDelivery {
+	t.Helper()
+
+	hook := models.Webhook{UserID: 1, URL: url, Secret: "secret", Events: `["webhook.test"]`, Active: true}
+	if err := ws.db.Create(&hook).Error; err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+
+	deliveries := make([]*models.WebhookDelivery, count)
+	for i := range deliveries {
+		d, err := ws.newDelivery(&hook, enums.WebhookTest, map[string]int{"n": i})
+		if err != nil {
+			t.Fatalf("create delivery: %v", err)
+		}
+		deliveries[i] = d
+	}
+	return deliveries
+}
+
+func TestDeliveryLeaseOutlastsABatch(t *testing.T) {
+	if deliveryLease <= deliveryBatch*deliveryTimeout {
+		t.Fatalf("lease %v does not cover a batch of %d sent one by one", deliveryLease, deliveryBatch)
+	}
+}
+
+func TestClaimDueLeasesDeliveries(t *testing.T) {
+	dbtest.Open(t)
+	ws := NewWebhookService()
+	webhookFixture(t, ws, "https://example.com/hook", 3)
+
+	later := time.Now().Add(time.Hour)
+	notDue := webhookFixture(t, ws, "https://example.com/other", 1)[0]
+	ws.db.Model(notDue).Update("next_attempt_at", later)
+
+	claimed, leaseEnd, err := ws.claimDue(context.Background())
+	if err != nil {
+		t.Fatalf("claimDue: %v", err)
+	}
+	if len(claimed) != 3 {
+		t.Fatalf("claimed %d deliveries, want the 3 due ones", len(claimed))
+	}
+	if time.Until(leaseEnd) < deliveryBatch*deliveryTimeout {
+		t.Errorf("lease ends in %v, before a batch can be sent", time.Until(leaseEnd))
+	}
+	for _, d := range claimed {
+		if d.NextAttemptAt == nil || d.NextAttemptAt.Before(leaseEnd.Add(-time.Second)) {
+			t.Errorf("delivery %d leased until %v, want %v", d.ID, d.NextAttemptAt, leaseEnd)
+		}
+	}
+
+	// Leased deliveries are not due for anyone else
+	again, _, err := ws.claimDue(context.Background())
+	if err != nil {
+		t.Fatalf("claimDue: %v", err)
+	}
+	if len(again) != 0 {
+		t.Errorf("claimed %d leased deliveries again", len(again))
+	}
+}
+
+func TestDispatchDueSendsEachDeliveryOnce(t *testing.T) {
+	dbtest.Open(t)
+
+	var mu sync.Mutex
+	received := make(map[string]int)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Slow enough that both dispatchers run at the same time
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		received[r.Header.Get("X-Bookture-Delivery")]++
+		mu.Unlock()
+	}))
+	t.Cleanup(receiver.Close)
+
+	dispatchers := []*WebhookService{NewWebhookService(), NewWebhookService()}
+	for _, ws := range dispatchers {
+		ws.client = receiver.Client()
+	}
+	deliveries := webhookFixture(t, dispatchers[0], receiver.URL, 5)
+
+	var wg sync.WaitGroup
+	for _, ws := range dispatchers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ws.dispatchDue(context.Background()); err != nil {
+				t.Errorf("dispatchDue: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, d := range deliveries {
+		if n := received[d.EventID]; n != 1 {
+			t.Errorf("delivery %d sent %d times, want once", d.ID, n)
+		}
+
+		var stored models.WebhookDelivery
+		dispatchers[0].db.First(&stored, d.ID)
+		if stored.Status != enums.DeliverySucceeded.ToString() || stored.Attempts != 1 {
+			t.Errorf("delivery %d is %s after %d attempts", d.ID, stored.Status, stored.Attempts)
+		}
+	}
+}
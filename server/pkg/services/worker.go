@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/db"
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/parser"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/progress"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// workerPollInterval is how long an idle worker waits before claiming again.
+	workerPollInterval = 2 * time.Second
+	// heartbeatInterval is how often a running job reports that it is alive.
+	heartbeatInterval = 10 * time.Second
+	// staleAfter is how long a job may go without a heartbeat before another
+	// worker takes it over.
+	staleAfter = time.Minute
+)
+
+// Worker claims jobs from the JobStore and runs them on a local
+// ProcessingService. It is what cmd/worker runs.
+type Worker struct {
+	id        string
+	db        *gorm.DB
+	store     *JobStore
+	parser    *parser.ParserService
+	processor *ProcessingService
+	slots     int
+
+	mu      sync.Mutex
+	claimed map[uint]*claimedJob
+}
+
+// claimedJob is a job this worker holds, queued locally or running.
+type claimedJob struct {
+	job           *models.PipelineJob
+	stopHeartbeat func()
+}
+
+func NewWorker(id string, store *JobStore, parser *parser.ParserService, slots int, limits map[enums.JobType]int) *Worker {
+	return &Worker{
+		id:        id,
+		db:        db.GetBooktureDB().DB,
+		store:     store,
+		parser:    parser,
+		processor: NewProcessingService(slots, slots, limits),
+		slots:     slots,
+		claimed:   make(map[uint]*claimedJob),
+	}
+}
+
+// Run claims and runs jobs until ctx is done, then drains: running jobs get
+// drainTimeout to finish before they are interrupted and handed back to the
+// queue.
+func (w *Worker) Run(ctx context.Context, drainTimeout time.Duration) {
+	log.Printf("Worker %s started with %d slots", w.id, w.slots)
+
+	for ctx.Err() == nil {
+		if w.processor.Load() >= w.slots {
+			utils.SleepCtx(ctx, workerPollInterval)
+			continue
+		}
+
+		job, err := w.store.Claim(ctx, w.id, staleAfter, w.processor.FullTypes())
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Worker %s: %v", w.id, err)
+			}
+			utils.SleepCtx(ctx, workerPollInterval)
+			continue
+		}
+		if job == nil {
+			utils.SleepCtx(ctx, workerPollInterval)
+			continue
+		}
+
+		w.start(job)
+	}
+
+	w.drain(drainTimeout)
+}
+
+func (w *Worker) start(job *models.PipelineJob) {
+	log.Printf("Worker %s claimed task %s (attempt %d)", w.id, job.TaskID, job.Attempts)
+
+	run, err := newVolumeJob(w.db, w.parser, job.Kind, job.VolumeID, job.UserID)
+	if err != nil {
+		w.store.Finish(job.ID, enums.TaskError, err)
+		return
+	}
+
+	// The claim is kept alive, and cancel requests are heard, from now on,
+	// also while the job waits in the local queue
+	var percent atomic.Int64
+	w.track(job, w.heartbeat(job, &percent))
+
+	opts := JobOptions{
+		UserID:   job.UserID,
+		Type:     enums.JobType(job.JobType),
+		Priority: enums.JobPriority(job.Priority),
+		Dropped: func(ctx context.Context) {
+			w.finish(ctx, job, ctx.Err())
+		},
+	}
+
+	err = w.processor.Enqueue(job.TaskID, opts, func(ctx context.Context, report *progress.Reporter) error {
+		report.OnProgress(func(p int) { percent.Store(int64(p)) })
+
+		err := run(ctx, report)
+		w.finish(ctx, job, err)
+		return err
+	})
+	if err != nil {
+		log.Printf("Worker %s could not start task %s: %v", w.id, job.TaskID, err)
+		w.untrack(job.ID)
+		w.store.Release(job.ID)
+	}
+}
+
+// heartbeat keeps the claim alive and forwards cancel requests from the API
+// until the returned func is called.
+func (w *Worker) heartbeat(job *models.PipelineJob, percent *atomic.Int64) func() {
+	hbCtx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hbCtx.Done():
+				return
+			case <-ticker.C:
+				cancelRequested, err := w.store.Heartbeat(hbCtx, job.ID, int(percent.Load()))
+				if err != nil {
+					log.Printf("Worker %s: heartbeat for task %s failed: %v", w.id, job.TaskID, err)
+					continue
+				}
+				if cancelRequested {
+					w.processor.Cancel(job.TaskID)
+				}
+			}
+		}
+	}()
+
+	return cancel
+}
+
+func (w *Worker) finish(ctx context.Context, job *models.PipelineJob, err error) {
+	// A late heartbeat would overwrite the final progress
+	w.untrack(job.ID)
+
+	switch {
+	case errors.Is(context.Cause(ctx), parser.ErrInterrupted):
+		w.store.Release(job.ID)
+	case errors.Is(err, ErrTaskActive):
+		// A worker thought dead still runs it and finishes the job itself
+		log.Printf("Worker %s: %v", w.id, err)
+	case ctx.Err() != nil:
+		w.store.Finish(job.ID, enums.TaskCancelled, nil)
+	case err != nil:
+		w.store.Finish(job.ID, enums.TaskError, err)
+	default:
+		w.store.Finish(job.ID, enums.TaskCompleted, nil)
+	}
+}
+
+func (w *Worker) drain(timeout time.Duration) {
+	log.Printf("Worker %s draining, waiting up to %v for running jobs", w.id, timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	w.processor.Shutdown(ctx)
+
+	// Claimed jobs that never started go back to the queue
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, claimed := range w.claimed {
+		log.Printf("Worker %s releasing task %s", w.id, claimed.job.TaskID)
+		claimed.stopHeartbeat()
+		w.store.Release(id)
+	}
+
+	log.Printf("Worker %s stopped", w.id)
+}
+
+func (w *Worker) track(job *models.PipelineJob, stopHeartbeat func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.claimed[job.ID] = &claimedJob{job: job, stopHeartbeat: stopHeartbeat}
+}
+
+// untrack forgets a job and stops its heartbeat.
+func (w *Worker) untrack(id uint) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if claimed, ok := w.claimed[id]; ok {
+		claimed.stopHeartbeat()
+		delete(w.claimed, id)
+	}
+}
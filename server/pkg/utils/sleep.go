@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// SleepCtx waits for d, or returns the context's error once it is done.
+func SleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
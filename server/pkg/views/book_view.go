@@ -80,6 +80,7 @@ type VolumeView struct {
 	Index     int        `json:"index"` // Volume number (1, 2, 3...)
 	Status    string     `json:"status"`
 	TaskID    string     `json:"task_id,omitempty"`
+	Pending   bool       `json:"pending,omitempty"` // Queue was full; no task until the reconciler queues it
 	FilePath  *string    `json:"file_path,omitempty"`
 	Uploaded  bool       `json:"uploaded"`
 	ParsedAt  *time.Time `json:"parsed_at,omitempty"`
@@ -150,6 +151,21 @@ func (r UpdateVolumeRequest) Valid() error {
 	return nil
 }
 
+type RegenerateVolumeRequest struct {
+	VolumeID string `json:"volume_id"`
+	Stage    string `json:"stage"` // scenes / images
+}
+
+func (r RegenerateVolumeRequest) Valid() error {
+	if r.VolumeID == "" {
+		return errors.New("volume_id is required")
+	}
+	if r.Stage == "" {
+		return errors.New("stage is required")
+	}
+	return nil
+}
+
 type VolumeDetailView struct {
 	ID           uint          `json:"id"`
 	Title        string        `json:"title"`
@@ -189,4 +205,6 @@ type SceneView struct {
 	SceneType       string  `json:"scene_type"`
 	Location        string  `json:"location"`
 	Mood            string  `json:"mood"`
+	GeneratedBy     string  `json:"generated_by,omitempty"` // provider:model
+	PromptVersion   string  `json:"prompt_version,omitempty"`
 }
@@ -0,0 +1,45 @@
+package views
+
+import "time"
+
+type LLMCacheStatsView struct {
+	Hits       int64   `json:"hits"`   // Since the server started
+	Misses     int64   `json:"misses"` // Since the server started
+	HitRate    float64 `json:"hit_rate"`
+	Entries    int64   `json:"entries"`
+	Expired    int64   `json:"expired"`
+	StoredHits int64   `json:"stored_hits"` // Hits on the stored entries, across restarts and instances
+	TTLSeconds int64   `json:"ttl_seconds"`
+}
+
+type LLMUsageView struct {
+	Provider           string    `json:"provider"`
+	Model              string    `json:"model"`
+	RequestsToday      int       `json:"requests_today"`
+	RequestsThisMinute int       `json:"requests_this_minute"`
+	PromptTokensToday  int64     `json:"prompt_tokens_today"`
+	OutputTokensToday  int64     `json:"output_tokens_today"`
+	InvalidToday       int       `json:"invalid_replies_today"`
+	RepairedToday      int       `json:"repaired_replies_today"`
+	RPM                int       `json:"rpm_limit"` // 0 is unlimited
+	RPD                int       `json:"rpd_limit"` // 0 is unlimited
+	RemainingToday     *int      `json:"remaining_today,omitempty"`
+	ResetAt            time.Time `json:"reset_at"`
+}
+
+// VolumeEstimateView is what processing a volume should take with the
+// current LLM settings, worked out before it starts.
+type VolumeEstimateView struct {
+	VolumeID      string  `json:"volume_id"`
+	Chapters      int     `json:"chapters"`
+	Sections      int     `json:"sections"`
+	Words         int     `json:"words"`
+	Model         string  `json:"model"` // provider:model that answers first
+	Requests      int     `json:"llm_requests"`
+	InputTokens   int     `json:"input_tokens"`
+	OutputTokens  int     `json:"output_tokens"`
+	TokensCounted bool    `json:"tokens_counted"` // false when the provider could not count and the numbers are estimates
+	CostUSD       float64 `json:"cost_usd"`       // 0 when the model is free or its prices are unknown
+	Minutes       int     `json:"min_minutes,omitempty"`
+	Images        int     `json:"images"`
+}
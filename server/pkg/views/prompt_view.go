@@ -0,0 +1,68 @@
+package views
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+)
+
+// PromptTemplateView is a prompt template override, or a built-in template
+// when ID is empty.
+type PromptTemplateView struct {
+	ID        string     `json:"id,omitempty"`
+	Task      string     `json:"task"`
+	Version   string     `json:"version"` // e.g. library:scenes:v2
+	LibraryID string     `json:"library_id,omitempty"`
+	BookID    string     `json:"book_id,omitempty"`
+	System    string     `json:"system"`
+	User      string     `json:"user"`
+	Note      string     `json:"note,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+func ToPromptTemplateView(t *models.PromptTemplate) PromptTemplateView {
+	v := PromptTemplateView{
+		ID:        utils.MaskID(t.ID),
+		Task:      t.Task,
+		Version:   t.Label(),
+		System:    t.System,
+		User:      t.User,
+		Note:      t.Note,
+		CreatedAt: &t.CreatedAt,
+	}
+	if t.LibraryID != nil {
+		v.LibraryID = utils.MaskID(*t.LibraryID)
+	}
+	if t.BookID != nil {
+		v.BookID = utils.MaskID(*t.BookID)
+	}
+	return v
+}
+
+// CreatePromptTemplateRequest saves a new version of a task's template for a
+// library or a book. Without either it is a global template, which only
+// admins may save.
+type CreatePromptTemplateRequest struct {
+	Task      string `json:"task"`
+	LibraryID string `json:"library_id"`
+	BookID    string `json:"book_id"`
+	System    string `json:"system"`
+	User      string `json:"user"`
+	Note      string `json:"note"`
+}
+
+func (r CreatePromptTemplateRequest) Valid() error {
+	if r.Task == "" {
+		return errors.New("task is required")
+	}
+	if strings.TrimSpace(r.System) == "" || strings.TrimSpace(r.User) == "" {
+		return errors.New("system and user templates are required")
+	}
+	if r.LibraryID != "" && r.BookID != "" {
+		return errors.New("set library_id or book_id, not both")
+	}
+	return nil
+}
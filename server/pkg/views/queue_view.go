@@ -0,0 +1,43 @@
+package views
+
+import (
+	"errors"
+	"time"
+)
+
+type QueueView struct {
+	Paused      bool            `json:"paused"`
+	PausedTypes []string        `json:"paused_types,omitempty"`
+	Workers     int             `json:"workers"`
+	Limits      map[string]int  `json:"limits"`
+	Running     []QueuedJobView `json:"running"`
+	Queued      []QueuedJobView `json:"queued"`
+}
+
+type QueuedJobView struct {
+	TaskID     string     `json:"task_id"`
+	UserID     string     `json:"user_id"`
+	JobType    string     `json:"job_type"`
+	Priority   string     `json:"priority"`
+	Status     string     `json:"status"`
+	Position   *int       `json:"position,omitempty"` // Only for queued jobs, 0 is next in line
+	Progress   int        `json:"progress"`
+	EnqueuedAt time.Time  `json:"enqueued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+}
+
+type ReorderQueueRequest struct {
+	TaskID   string `json:"task_id"`
+	Position *int   `json:"position"`
+	Priority string `json:"priority"`
+}
+
+func (r ReorderQueueRequest) Valid() error {
+	if r.TaskID == "" {
+		return errors.New("task_id is required")
+	}
+	if r.Position == nil && r.Priority == "" {
+		return errors.New("position or priority is required")
+	}
+	return nil
+}
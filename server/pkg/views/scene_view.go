@@ -1,16 +1,110 @@
 package views
 
+import (
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+)
+
 type SceneGenerationResponse struct {
 	Scenes []GeneratedScene `json:"scenes"`
 }
 
+// GeneratedScene is also the schema the LLM is asked to fill, see llm.SchemaFor.
 type GeneratedScene struct {
-	SectionNumber   int      `json:"section_number"`
-	Summary         string   `json:"summary"`
-	ImportanceScore float64  `json:"importance_score"`
-	SceneType       string   `json:"scene_type"`
-	ImagePrompt     string   `json:"image_prompt"`
-	Characters      []string `json:"characters,omitempty"`
-	Location        string   `json:"location,omitempty"`
-	Mood            string   `json:"mood,omitempty"`
+	SectionNumber   int      `json:"section_number" desc:"The section number this scene belongs to" min:"1"`
+	Summary         string   `json:"summary" desc:"A 2-3 sentence summary of what happens in this scene"`
+	ImportanceScore float64  `json:"importance_score" desc:"How important this scene is to the story (0.0 to 1.0)" min:"0" max:"1"`
+	SceneType       string   `json:"scene_type" desc:"Type of scene: action, dialogue, exposition, climax, resolution"`
+	ImagePrompt     string   `json:"image_prompt" desc:"A detailed visual prompt for image generation, describing the scene, characters, setting, mood, and style"`
+	Characters      []string `json:"characters,omitempty" desc:"List of character names present in this scene"`
+	Location        string   `json:"location,omitempty" desc:"Where the scene takes place"`
+	Mood            string   `json:"mood,omitempty" desc:"The emotional tone: tense, peaceful, joyful, dark, mysterious, etc."`
+}
+
+// SceneChunkResponse is the reply for one part of a chapter too long to
+// analyze at once.
+type SceneChunkResponse struct {
+	Scenes         []GeneratedScene `json:"scenes"`
+	ContextSummary string           `json:"context_summary" desc:"What has happened in the chapter up to the end of this part, in at most five sentences"`
+}
+
+// SceneBatchResponse is the reply for several short chapters analyzed in one
+// request.
+type SceneBatchResponse struct {
+	Chapters []BatchChapterScenes `json:"chapters"`
+}
+
+type BatchChapterScenes struct {
+	ChapterNumber int              `json:"chapter_number" desc:"The chapter number the scenes belong to" min:"1"`
+	Scenes        []GeneratedScene `json:"scenes"`
+}
+
+// SceneRankingResponse scores the scenes of a long chapter against the whole
+// chapter once all its parts are analyzed.
+type SceneRankingResponse struct {
+	Rankings []SceneRanking `json:"rankings"`
+}
+
+type SceneRanking struct {
+	Number          int     `json:"number" desc:"The number of the scene in the list" min:"1"`
+	ImportanceScore float64 `json:"importance_score" desc:"How important this scene is to the whole chapter (0.0 to 1.0)" min:"0" max:"1"`
+	DuplicateOf     int     `json:"duplicate_of,omitempty" desc:"The number of an earlier scene this one repeats, if any"`
+}
+
+// AIPromptView is one recorded LLM or image call.
+type AIPromptView struct {
+	ID           uint      `json:"id"`
+	Kind         string    `json:"kind"`
+	Task         string    `json:"task"`
+	Attempt      int       `json:"attempt"`
+	Repair       bool      `json:"repair,omitempty"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	Prompt       string    `json:"prompt"`
+	Schema       string    `json:"schema,omitempty"`
+	Response     string    `json:"response,omitempty"`
+	Cached       bool      `json:"cached"`
+	Template     string    `json:"template,omitempty"` // Prompt template version
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	FinishReason string    `json:"finish_reason,omitempty"`
+	LatencyMs    int64     `json:"latency_ms"`
+	PromptTokens int       `json:"prompt_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ScenePromptsView is the generation history of a scene: the call that
+// wrote it and every attempt at its image.
+type ScenePromptsView struct {
+	SceneID uint           `json:"scene_id"`
+	Source  *AIPromptView  `json:"source,omitempty"`
+	Images  []AIPromptView `json:"images"`
+}
+
+func ToAIPromptView(p *models.AIPrompt) AIPromptView {
+	return AIPromptView{
+		ID:           p.ID,
+		Kind:         p.Kind,
+		Task:         p.Task,
+		Attempt:      p.Attempt,
+		Repair:       p.Repair,
+		Provider:     p.Provider,
+		Model:        p.ModelName,
+		SystemPrompt: p.SystemPrompt,
+		Prompt:       p.PromptText,
+		Schema:       p.Schema,
+		Response:     p.Response,
+		Cached:       p.Cached,
+		Template:     p.TemplateVersion,
+		Status:       p.Status,
+		Error:        p.Error,
+		FinishReason: p.FinishReason,
+		LatencyMs:    p.LatencyMs,
+		PromptTokens: p.PromptTokens,
+		OutputTokens: p.OutputTokens,
+		CreatedAt:    p.CreatedAt,
+	}
 }
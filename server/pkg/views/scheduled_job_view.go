@@ -0,0 +1,18 @@
+package views
+
+import "time"
+
+type ScheduledJobView struct {
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	Spec           string     `json:"spec"` // cron spec in UTC
+	Running        bool       `json:"running"`
+	NextRunAt      *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+	LastStatus     string     `json:"last_status,omitempty"`
+	LastResult     string     `json:"last_result,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastDurationMs int64      `json:"last_duration_ms"`
+	LastRunBy      string     `json:"last_run_by,omitempty"`
+}
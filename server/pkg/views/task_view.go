@@ -0,0 +1,134 @@
+package views
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+)
+
+// TaskEvent is a single entry of a task's progress stream (SSE / WebSocket).
+type TaskEvent struct {
+	ID       int64  `json:"id"`
+	TaskID   string `json:"task_id"`
+	Type     string `json:"type"`
+	Stage    string `json:"stage,omitempty"`
+	Progress int    `json:"progress"`
+
+	Chapter         int `json:"chapter,omitempty"`
+	TotalChapters   int `json:"total_chapters,omitempty"`
+	ScenesGenerated int `json:"scenes_generated,omitempty"`
+	ImagesGenerated int `json:"images_generated,omitempty"`
+	TotalImages     int `json:"total_images,omitempty"`
+
+	Attempt     int        `json:"attempt,omitempty"`
+	MaxAttempts int        `json:"max_attempts,omitempty"`
+	WaitSeconds int        `json:"wait_seconds,omitempty"`
+	ResumeAt    *time.Time `json:"resume_at,omitempty"`
+
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Result  any    `json:"result,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// VolumeResultView is attached to the final event of a volume processing task.
+type VolumeResultView struct {
+	VolumeID        string `json:"volume_id"`
+	Chapters        int    `json:"chapters"`
+	Sections        int    `json:"sections"`
+	ScenesGenerated int    `json:"scenes_generated"`
+	ImagesGenerated int    `json:"images_generated"`
+}
+
+// TaskRunView is one entry of a volume's task history.
+type TaskRunView struct {
+	ID       string `json:"id"`
+	TaskID   string `json:"task_id"`
+	Kind     string `json:"kind"`
+	VolumeID string `json:"volume_id"`
+	Status   string `json:"status"`
+	Stage    string `json:"stage"`
+	Progress int    `json:"progress"`
+
+	LLMProvider   string `json:"llm_provider,omitempty"`
+	LLMModel      string `json:"llm_model,omitempty"`
+	ImageProvider string `json:"image_provider,omitempty"`
+	ImageModel    string `json:"image_model,omitempty"`
+
+	LLMCalls        int    `json:"llm_calls"`
+	ImageCalls      int    `json:"image_calls"`
+	Retries         int    `json:"retries"`
+	RateLimitWaitMs int64  `json:"rate_limit_wait_ms"`
+	Warnings        int    `json:"warnings"`
+	Error           string `json:"error,omitempty"`
+
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+	ETASeconds *int       `json:"eta_seconds,omitempty"` // Only while running
+
+	Stages []TaskStageView `json:"stages"`
+}
+
+type TaskStageView struct {
+	Stage           string     `json:"stage"`
+	Items           int        `json:"items"`
+	LLMCalls        int        `json:"llm_calls"`
+	ImageCalls      int        `json:"image_calls"`
+	Retries         int        `json:"retries"`
+	RateLimitWaitMs int64      `json:"rate_limit_wait_ms"`
+	Errors          []string   `json:"errors,omitempty"`
+	StartedAt       time.Time  `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	DurationMs      int64      `json:"duration_ms"`
+}
+
+func ToTaskRunView(r *models.TaskRun) TaskRunView {
+	v := TaskRunView{
+		ID:              utils.MaskID(r.ID),
+		TaskID:          r.TaskID,
+		Kind:            r.Kind,
+		VolumeID:        utils.MaskID(r.VolumeID),
+		Status:          r.Status,
+		Stage:           r.CurrentStage,
+		Progress:        r.Progress,
+		LLMProvider:     r.LLMProvider,
+		LLMModel:        r.LLMModel,
+		ImageProvider:   r.ImageProvider,
+		ImageModel:      r.ImageModel,
+		LLMCalls:        r.LLMCalls,
+		ImageCalls:      r.ImageCalls,
+		Retries:         r.Retries,
+		RateLimitWaitMs: r.RateLimitWaitMs,
+		Warnings:        r.Warnings,
+		Error:           r.Error,
+		StartedAt:       r.StartedAt,
+		FinishedAt:      r.FinishedAt,
+		DurationMs:      r.DurationMs,
+		Stages:          make([]TaskStageView, len(r.Stages)),
+	}
+
+	for i, st := range r.Stages {
+		var errs []string
+		if st.Errors != "" {
+			_ = json.Unmarshal([]byte(st.Errors), &errs)
+		}
+
+		v.Stages[i] = TaskStageView{
+			Stage:           st.Stage,
+			Items:           st.Items,
+			LLMCalls:        st.LLMCalls,
+			ImageCalls:      st.ImageCalls,
+			Retries:         st.Retries,
+			RateLimitWaitMs: st.RateLimitWaitMs,
+			Errors:          errs,
+			StartedAt:       st.StartedAt,
+			FinishedAt:      st.FinishedAt,
+			DurationMs:      st.DurationMs,
+		}
+	}
+	return v
+}
@@ -0,0 +1,168 @@
+package views
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Mahaveer86619/bookture/server/pkg/enums"
+	"github.com/Mahaveer86619/bookture/server/pkg/models"
+	"github.com/Mahaveer86619/bookture/server/pkg/utils"
+)
+
+// WebhookPayload is the JSON body POSTed to a webhook.
+type WebhookPayload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// VolumeEventData is the payload of volume.* events.
+type VolumeEventData struct {
+	VolumeID        string `json:"volume_id"`
+	BookID          string `json:"book_id"`
+	Title           string `json:"title"`
+	Status          string `json:"status"`
+	Chapters        int    `json:"chapters,omitempty"`
+	Sections        int    `json:"sections,omitempty"`
+	ScenesGenerated int    `json:"scenes_generated,omitempty"`
+	ImagesGenerated int    `json:"images_generated,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// LibraryEventData is the payload of library.* events.
+type LibraryEventData struct {
+	LibraryID string `json:"library_id"`
+}
+
+type WebhookView struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Active      bool      `json:"active"`
+	Secret      string    `json:"secret,omitempty"` // Only returned on creation
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func ToWebhookView(w *models.Webhook) WebhookView {
+	events := []string{}
+	_ = json.Unmarshal([]byte(w.Events), &events)
+
+	return WebhookView{
+		ID:          utils.MaskID(w.ID),
+		URL:         w.URL,
+		Events:      events,
+		Description: w.Description,
+		Active:      w.Active,
+		CreatedAt:   w.CreatedAt,
+		UpdatedAt:   w.UpdatedAt,
+	}
+}
+
+func ToWebhookViews(webhooks []models.Webhook) []WebhookView {
+	views := make([]WebhookView, len(webhooks))
+	for i := range webhooks {
+		views[i] = ToWebhookView(&webhooks[i])
+	}
+	return views
+}
+
+type WebhookDeliveryView struct {
+	ID            string     `json:"id"`
+	EventID       string     `json:"event_id"`
+	Event         string     `json:"event"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	ResponseCode  int        `json:"response_code,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func ToWebhookDeliveryView(d *models.WebhookDelivery) WebhookDeliveryView {
+	return WebhookDeliveryView{
+		ID:            utils.MaskID(d.ID),
+		EventID:       d.EventID,
+		Event:         d.Event,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		NextAttemptAt: d.NextAttemptAt,
+		ResponseCode:  d.ResponseCode,
+		Error:         d.Error,
+		DeliveredAt:   d.DeliveredAt,
+		CreatedAt:     d.CreatedAt,
+	}
+}
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+}
+
+func (r CreateWebhookRequest) Valid() error {
+	if err := validWebhookURL(r.URL); err != nil {
+		return err
+	}
+	return validWebhookEvents(r.Events)
+}
+
+type UpdateWebhookRequest struct {
+	ID          string   `json:"id"`
+	URL         *string  `json:"url"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description"`
+	Active      *bool    `json:"active"`
+}
+
+func (r UpdateWebhookRequest) Valid() error {
+	if r.ID == "" {
+		return errors.New("id cannot be empty")
+	}
+	if r.URL != nil {
+		if err := validWebhookURL(*r.URL); err != nil {
+			return err
+		}
+	}
+	if r.Events != nil {
+		return validWebhookEvents(r.Events)
+	}
+	return nil
+}
+
+// validWebhookURL rejects URLs that obviously point at the server or its
+// network. Names are resolved and checked again on every delivery.
+func validWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("url must not point at a private address")
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !utils.IsPublicIP(ip) {
+		return errors.New("url must not point at a private address")
+	}
+	return nil
+}
+
+func validWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	for _, e := range events {
+		if !enums.WebhookEvent(e).IsValid() {
+			return errors.New("unknown event: " + e)
+		}
+	}
+	return nil
+}
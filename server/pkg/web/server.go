@@ -15,15 +15,18 @@ import (
 	"github.com/Mahaveer86619/bookture/server/pkg/handlers"
 	"github.com/Mahaveer86619/bookture/server/pkg/middleware"
 	"github.com/Mahaveer86619/bookture/server/pkg/services"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/assets"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/gen_image"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/llm"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/parser"
+	"github.com/Mahaveer86619/bookture/server/pkg/services/scheduler"
 	"github.com/Mahaveer86619/bookture/server/pkg/services/storage"
 )
 
 type Server struct {
-	cfg    config.Config
-	router *http.ServeMux
+	cfg       config.Config
+	router    *http.ServeMux
+	processor *services.ProcessingService
 }
 
 func NewServer() *Server {
@@ -37,13 +40,21 @@ func (s *Server) initSystem() {
 	db.InitBookture()
 }
 
-func (s *Server) setupRoutes() {
+// webhookDispatchInterval is how often due webhook deliveries are sent.
+const webhookDispatchInterval = 5 * time.Second
+
+func (s *Server) setupRoutes(ctx context.Context) {
 	storageService := storage.NewStorageService()
 	if err := storageService.Init(); err != nil {
 		log.Fatalf("Fatal: Failed to initialize storage: %v", err)
 	}
 
 	llmService := llm.NewLLMService()
+	var llmCache *llm.CachedLLMService
+	if s.cfg.LLM_CACHE_TTL_HOURS > 0 && !llm.Recording() {
+		llmCache = llm.NewCachedLLMService(llmService, time.Duration(s.cfg.LLM_CACHE_TTL_HOURS)*time.Hour)
+		llmService = llmCache
+	}
 	if err := llmService.Init(); err != nil {
 		log.Printf("Warning: LLM Service failed to init: %v", err)
 	}
@@ -53,23 +64,55 @@ func (s *Server) setupRoutes() {
 		log.Printf("Warning: Image Service failed to init: %v", err)
 	}
 
-	processingService := services.NewProcessingService(3, 100)
-	parserService := parser.NewParserService(llmService, imageService)
+	// In external mode jobs go to the database for cmd/worker, so the API
+	// server keeps no workers of its own.
+	var jobStore *services.JobStore
+	workerCount := s.cfg.WORKER_COUNT
+	if s.cfg.PROCESSING_MODE == "external" {
+		jobStore = services.NewJobStore()
+		workerCount = 0
+	}
+
+	processingService := services.NewProcessingService(
+		workerCount,
+		s.cfg.QUEUE_SIZE,
+		services.ParseJobLimits(s.cfg.JOB_CONCURRENCY),
+	)
+	s.processor = processingService
+	webhookService := services.NewWebhookService()
+	assetStore := assets.NewStore(storageService)
+	parserService := parser.NewParserService(llmService, imageService, storageService, webhookService)
 
 	// 3. Domain Services
-	libraryService := services.NewLibraryService()
+	libraryService := services.NewLibraryService(webhookService)
 
 	// Inject dependencies into BookService
-	bookService := services.NewBookService(storageService, libraryService, processingService, parserService)
+	bookService := services.NewBookService(storageService, libraryService, processingService, parserService, jobStore, webhookService)
+	go webhookService.RunDispatcher(ctx, webhookDispatchInterval)
+
+	// Maintenance jobs, leased through the database so each run happens on
+	// one instance only
+	jobScheduler := scheduler.New(s.cfg.WORKER_ID)
+	maintenanceService := services.NewMaintenanceService(storageService, bookService)
+	if err := maintenanceService.Register(jobScheduler); err != nil {
+		log.Fatalf("Fatal: Failed to register scheduled jobs: %v", err)
+	}
+	go jobScheduler.Run(ctx)
 
 	healthService := services.NewHealthService(storageService)
 	userService := services.NewUserService()
+	promptService := services.NewPromptService()
+	assetService := services.NewAssetService(assetStore)
 
 	// 4. Handlers
 	healthHandler := handlers.NewHealthHandler(healthService)
 	userHandler := handlers.NewUserHandler(userService)
 	libraryHandler := handlers.NewLibraryHander(libraryService)
 	bookHandler := handlers.NewBookHandler(bookService)
+	adminHandler := handlers.NewAdminHandler(processingService, jobStore, jobScheduler, llmCache)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	promptHandler := handlers.NewPromptHandler(promptService)
+	assetHandler := handlers.NewAssetHandler(assetService)
 
 	// Health
 	s.router.HandleFunc("GET /health", healthHandler.CheckHealth)
@@ -96,12 +139,51 @@ func (s *Server) setupRoutes() {
 	s.router.HandleFunc("POST /book", middleware.Middleware(bookHandler.CreateDraft))
 	s.router.HandleFunc("POST /volume/upload", middleware.Middleware(bookHandler.UploadVolume))
 	s.router.HandleFunc("GET /volume/details", middleware.Middleware(bookHandler.GetVolumeDetails))
+	s.router.HandleFunc("GET /volume/history", middleware.Middleware(bookHandler.GetVolumeHistory))
+	s.router.HandleFunc("GET /volume/estimate", middleware.Middleware(bookHandler.EstimateVolume))
+	s.router.HandleFunc("GET /scene/prompts", middleware.Middleware(bookHandler.GetScenePrompts))
 	s.router.HandleFunc("GET /task/progress", middleware.Middleware(bookHandler.GetTaskProgress))
+	s.router.HandleFunc("DELETE /task", middleware.Middleware(bookHandler.CancelTask))
+	s.router.HandleFunc("GET /task/events", middleware.StreamMiddleware(bookHandler.StreamTaskEvents))
+	s.router.HandleFunc("GET /task/ws", middleware.StreamMiddleware(bookHandler.StreamTaskEventsWS))
+	s.router.HandleFunc("POST /volume/regenerate", middleware.Middleware(bookHandler.RegenerateVolume))
+
+	// Stored files, e.g. scene images. Token by query too, for img tags
+	s.router.HandleFunc("GET /asset", middleware.StreamMiddleware(assetHandler.GetAsset))
+
+	// Prompt templates
+	s.router.HandleFunc("POST /prompt/template", middleware.Middleware(promptHandler.CreateTemplate))
+	s.router.HandleFunc("GET /prompt/template", middleware.Middleware(promptHandler.GetTemplates))
+	s.router.HandleFunc("DELETE /prompt/template", middleware.Middleware(promptHandler.DeleteTemplate))
+
+	// Webhooks
+	s.router.HandleFunc("POST /webhook", middleware.Middleware(webhookHandler.CreateWebhook))
+	s.router.HandleFunc("GET /webhook", middleware.Middleware(webhookHandler.GetWebhooks))
+	s.router.HandleFunc("PUT /webhook", middleware.Middleware(webhookHandler.UpdateWebhook))
+	s.router.HandleFunc("DELETE /webhook", middleware.Middleware(webhookHandler.DeleteWebhook))
+	s.router.HandleFunc("POST /webhook/test", middleware.Middleware(webhookHandler.SendTestEvent))
+	s.router.HandleFunc("GET /webhook/deliveries", middleware.Middleware(webhookHandler.GetDeliveries))
+
+	// Admin
+	s.router.HandleFunc("GET /admin/queue", middleware.AdminMiddleware(adminHandler.GetQueue))
+	s.router.HandleFunc("PUT /admin/queue", middleware.AdminMiddleware(adminHandler.ReorderQueue))
+	s.router.HandleFunc("POST /admin/queue/pause", middleware.AdminMiddleware(adminHandler.PauseQueue))
+	s.router.HandleFunc("POST /admin/queue/resume", middleware.AdminMiddleware(adminHandler.ResumeQueue))
+	s.router.HandleFunc("GET /admin/jobs", middleware.AdminMiddleware(adminHandler.GetScheduledJobs))
+	s.router.HandleFunc("POST /admin/jobs/run", middleware.AdminMiddleware(adminHandler.RunScheduledJob))
+	s.router.HandleFunc("GET /admin/llm/usage", middleware.AdminMiddleware(adminHandler.GetLLMUsage))
+	s.router.HandleFunc("GET /admin/llm/cache", middleware.AdminMiddleware(adminHandler.GetLLMCacheStats))
+	s.router.HandleFunc("DELETE /admin/llm/cache", middleware.AdminMiddleware(adminHandler.InvalidateLLMCache))
+	s.router.HandleFunc("POST /admin/prompt/template", middleware.AdminMiddleware(promptHandler.CreateGlobalTemplate))
+	s.router.HandleFunc("DELETE /admin/prompt/template", middleware.AdminMiddleware(promptHandler.DeleteGlobalTemplate))
 }
 
 func (s *Server) Run() error {
 	s.initSystem()
-	s.setupRoutes()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	s.setupRoutes(ctx)
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%s", s.cfg.PORT),
@@ -128,15 +210,22 @@ func (s *Server) Run() error {
 	case sig := <-shutdown:
 		log.Printf("Shutdown signal received: %v", sig)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		if err := srv.Shutdown(ctx); err != nil {
-			return srv.Close()
+		var closeErr error
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			closeErr = srv.Close()
 		}
-	}
 
-	return nil
+		stop()
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Duration(s.cfg.DRAIN_TIMEOUT)*time.Second)
+		defer cancelDrain()
+
+		log.Printf("Waiting up to %ds for running jobs", s.cfg.DRAIN_TIMEOUT)
+		s.processor.Shutdown(drainCtx)
+		return closeErr
+	}
 }
 
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {